@@ -1,71 +1,303 @@
 package db
 
 import (
+	"context"
 	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/event"
 	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/logger"
 	"github.com/jo3yzhu/goveldb/memtable"
+	"github.com/jo3yzhu/goveldb/opt"
+	"github.com/jo3yzhu/goveldb/statistics"
 	"github.com/jo3yzhu/goveldb/version"
-	"io/ioutil"
-	"os"
+	"github.com/jo3yzhu/goveldb/wal"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	kMaxBatchGroupSize   = 1 << 20   // upper bound of the size of merged write batches
+	kSmallBatchGroupSize = 128 << 10 // a small write batch can only grow by this size, so that small writes are not slowed down too much
+)
+
+// writer is a pending Write call waiting in the writer queue
+
+type writer struct {
+	batch *WriteBatch
+	sync  bool
+	done  bool
+	err   error
+	cond  *sync.Cond // signaled when the writer is done or becomes the head of queue
+
+	grouped bool // the batch has been merged by a leader, so the writer can't leave the queue
+}
+
+// Range is a range of user keys [Start, Limit)
+
+type Range struct {
+	Start []byte // inclusive
+	Limit []byte // exclusive
+}
+
 type Db struct {
 	name                  string
+	opts                  *opt.Options
 	mu                    sync.Mutex // no MVCC is implemented here, so we need a mutex to make Get, Put and Delete exclusive
 	cond                  *sync.Cond // indicate that minor compaction is finished
 	mem                   *memtable.MemTable
 	imm                   *memtable.MemTable
 	current               *version.Version
-	bgCompactionScheduled bool // indicate that if there is a compaction processing
+	bgCompactionScheduled bool  // indicate that if there is a compaction processing
+	bgErr                 error // error of minor compaction, writes fail after that
+	numBgErrors           int   // number of errors of background compactions
+
+	stats      [internal.NumLevels]version.CompactionStats // compaction stats of each level
+	writeStall event.WriteStallCondition
+
+	writers     []*writer  // queue of writers, the head of it is the leader who writes on behalf of the group
+	tmpBatch    WriteBatch // batch group merged by leader
+	logFile     env.WritableFile
+	log         *wal.Writer
+	logNumber   uint64 // file number of the log which mem is written in
+	logSequence uint64 // last sequence before the log which mem is written in
+
+	manifestNumber uint64 // number of the manifest which CURRENT points to, manifests before it are obsolete
+
+	versions        map[*version.Version]int // reference counts of versions read by Get and iterators, their tables are not removed
+	unmanagedTables map[uint64]bool          // tables found on open without manifest, they are kept for Repair
+	removingFiles   bool                     // obsolete files are being removed by a goroutine
+	removePending   bool                     // more files become obsolete while removing, so the removing goroutine goes on
+	closed          bool
+
+	infoLogFile *logger.FileLogger // the default info logger owned by database, nil if it's provided by options
+	env         env.Env
+	lock        io.Closer // lock of LOCK file, which is released on close
 }
 
 // @description: current file in leveldb knows which the newest manifest file
 //               when database is restarted, ask current file for it
 
-func (db *Db) SetCurrentFile(descriptorNumber uint64) {
+//				 current file of leveldb contains the file name of manifest followed by a newline, such as "MANIFEST-000123\n"
+
+// @return: error if current file can't be switched, the previous one is left untouched then
+
+func (db *Db) SetCurrentFile(descriptorNumber uint64) error {
+	content := fmt.Sprintf("%d", descriptorNumber)
+	if db.opts.GetLevelDBCompatible() {
+		content = fmt.Sprintf("MANIFEST-%06d\n", descriptorNumber)
+	}
+
+	// the content must be durable before it's renamed, otherwise an empty current file may be left by crash
 	temp := internal.TempFileName(db.name, descriptorNumber)
-	ioutil.WriteFile(temp, []byte(fmt.Sprintf("%d", descriptorNumber)), 0600)
-	os.Rename(temp, internal.CurrentFileName(db.name))
+	err := env.WriteFile(db.env, temp, []byte(content), true)
+	if err == nil {
+		err = db.env.RenameFile(temp, internal.CurrentFileName(db.name))
+	}
+	if err != nil {
+		_ = db.env.RemoveFile(temp)
+	}
+	return err
 }
 
-func (db *Db) ReadCurrentFile() uint64 {
-	b, err := ioutil.ReadFile(internal.CurrentFileName(db.name))
+// @description: save version to a new manifest file and switch current file to it
+// @param: the version to save
+// @return: the number of the new manifest, which is recorded by the caller when the version is installed,
+//          and error if any, the previous manifest is still the current one then
+
+func (db *Db) saveVersion(v *version.Version) (uint64, error) {
+	descriptorNumber, err := v.Save()
+	if err != nil {
+		_ = db.env.RemoveFile(internal.DescriptorFileName(db.name, descriptorNumber))
+		return 0, err
+	}
+	if err := db.SetCurrentFile(descriptorNumber); err != nil {
+		return 0, err
+	}
+	return descriptorNumber, nil
+}
+
+// @return: the number of newest manifest file and if current file exists
+// @note: manifest number of leveldb may be 0, so existence is told separately
+
+func (db *Db) ReadCurrentFile() (uint64, bool) {
+	b, err := env.ReadFile(db.env, internal.CurrentFileName(db.name))
 	if err != nil {
-		return 0
+		return 0, false
 	}
-	descriptorNumber, err := strconv.ParseUint(string(b), 10, 64)
+	content := strings.TrimPrefix(strings.TrimSuffix(string(b), "\n"), "MANIFEST-")
+	descriptorNumber, err := strconv.ParseUint(content, 10, 64)
 	if err != nil {
-		return 0
+		return 0, false
 	}
 
-	return descriptorNumber
+	return descriptorNumber, true
 }
 
 func (db *Db) backgroundCompaction() {
 	imm := db.imm
+	logNumber := db.logNumber // imm has been written in logs before the current one
+	logSequence := db.logSequence
 	v := db.current.Copy()
 	db.mu.Unlock()
 
 	// minor compaction
 	if imm != nil {
-		v.WriteLevel0Table(imm)
+		stats, err := v.WriteLevel0Table(imm)
+		if err != nil {
+			db.opts.GetInfoLog().Log(logger.Error, "minor compaction error", "error", err)
+			db.opts.GetEventListeners().OnBackgroundError(event.BackgroundErrorInfo{DbName: db.name, Reason: "flush", Err: err})
+			db.mu.Lock()
+			db.bgErr = err
+			db.numBgErrors++
+			return
+		}
+		v.SetLogNumber(logNumber)
+		db.addStats(stats)
+		db.opts.GetStatistics().RecordTick(statistics.FlushWriteBytes, stats.BytesWritten)
+		db.opts.GetStatistics().MeasureTime(statistics.FlushMicros, stats.Duration)
 	}
 
-	// major compaction
-	for v.DoCompactionWork() {
+	// major compaction, an aborted one doesn't modify version and will be retried next time
+	for {
+		stats, err := v.DoCompactionWork()
+		if err != nil {
+			db.opts.GetInfoLog().Log(logger.Error, "major compaction error", "error", err)
+			db.opts.GetEventListeners().OnBackgroundError(event.BackgroundErrorInfo{DbName: db.name, Reason: "compaction", Err: err})
+			db.mu.Lock()
+			db.numBgErrors++
+			if err == internal.ErrCorruption && db.opts.GetParanoidChecks() {
+				db.bgErr = err // stop writing rather than going on with broken data
+			}
+			db.mu.Unlock()
+		}
+		if stats == nil {
+			break
+		}
+		db.addStats(stats)
+		db.opts.GetStatistics().RecordTick(statistics.CompactReadBytes, stats.BytesRead)
+		db.opts.GetStatistics().RecordTick(statistics.CompactWriteBytes, stats.BytesWritten)
+		db.opts.GetStatistics().MeasureTime(statistics.CompactionMicros, stats.Duration)
 		v.Log()
 	}
 
-	descriptorNumber, _ := v.Save()
-	db.SetCurrentFile(descriptorNumber)
+	// writes after imm are not in sstables yet, and they are recovered from log with greater sequence
+	v.SetLastSequence(logSequence)
+	manifestNumber, err := db.saveVersion(v)
+	if err != nil {
+		// the log of imm can't be dropped, stop writing since there's no room for mem any more
+		db.opts.GetInfoLog().Log(logger.Error, "manifest write error", "error", err)
+		db.opts.GetEventListeners().OnBackgroundError(event.BackgroundErrorInfo{DbName: db.name, Reason: "manifest", Err: err})
+		db.mu.Lock()
+		db.bgErr = err
+		db.numBgErrors++
+		return
+	}
 
 	// TODO: maybe better
 	db.mu.Lock()
+	v.SetLastSequence(db.current.LastSequence()) // writers may go on while compacting
 	db.imm = nil
 	db.current = v
+	db.manifestNumber = manifestNumber
+	db.removeObsoleteFiles(false)
+}
+
+// @description: compact the key range [start, limit] in all levels, so that deleted and overwritten data is discarded
+// @param: the range, nil start means before all keys and nil limit means after all keys
+// @return: error if any
+
+func (db *Db) CompactRange(start, limit []byte) error {
+	return db.CompactRangeContext(context.Background(), start, limit)
+}
+
+// @description: compact the key range [start, limit] in all levels, which can be cancelled by context
+// @param: the context and the range, nil start means before all keys and nil limit means after all keys
+// @return: error if any, ctx.Err() if it's cancelled
+// @note: the range is compacted level by level, and each level is installed as a whole,
+//        so a cancelled compaction leaves the levels compacted so far and no partial output
+
+func (db *Db) CompactRangeContext(ctx context.Context, start, limit []byte) error {
+	// flush memtable so that the data in it is compacted too,
+	// it's switched by a writer with nil batch, since the leader of writers may be writing to it without lock
+	if err := db.WriteContext(ctx, nil, nil); err != nil {
+		return err
+	}
+
+	stop := db.watchContext(ctx, nil)
+	defer stop()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.waitForCompaction(ctx); err != nil {
+		return err
+	}
+
+	// take the place of background compaction, writers wait for it when memtable is full
+	db.bgCompactionScheduled = true
+	defer func() {
+		db.bgCompactionScheduled = false
+		db.cond.Broadcast()
+	}()
+
+	for level := 0; level < internal.NumLevels-1; level++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		logSequence := db.logSequence
+		v := db.current.Copy()
+		db.mu.Unlock()
+
+		stats, err := v.CompactRange(ctx, level, start, limit)
+		var manifestNumber uint64
+		if err == nil && stats != nil {
+			v.SetLastSequence(logSequence)
+			manifestNumber, err = db.saveVersion(v)
+		}
+
+		db.mu.Lock()
+		if err != nil {
+			db.opts.GetInfoLog().Log(logger.Error, "manual compaction error", "level", level, "error", err)
+			return err
+		}
+		if stats != nil {
+			v.SetLastSequence(db.current.LastSequence()) // writers may go on while compacting
+			db.current = v
+			db.manifestNumber = manifestNumber
+			db.removeObsoleteFiles(false)
+			db.stats[stats.Level].Add(stats)
+			db.opts.GetStatistics().RecordTick(statistics.CompactReadBytes, stats.BytesRead)
+			db.opts.GetStatistics().RecordTick(statistics.CompactWriteBytes, stats.BytesWritten)
+			db.opts.GetStatistics().MeasureTime(statistics.CompactionMicros, stats.Duration)
+		}
+	}
+
+	return nil
+}
+
+// @description: wait until the immutable memtable is compacted and there's no background compaction
+// @return: error of background compaction, or ctx.Err() if the context is done before that
+// @note: REQUIRES: db.mu is held and the context is watched by db.watchContext
+
+func (db *Db) waitForCompaction(ctx context.Context) error {
+	for db.imm != nil || db.bgCompactionScheduled {
+		// imm is never compacted once background compaction fails
+		if db.bgErr != nil {
+			return db.bgErr
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		db.cond.Wait()
+	}
+	return db.bgErr
 }
 
 func (db *Db) backgroundCall() {
@@ -84,109 +316,804 @@ func (db *Db) maybeScheduleCompaction() {
 	go db.backgroundCall()
 }
 
-func (db *Db) makeRoomForWrite() (uint64, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// dbFile is a file of database found in its directory
+
+type dbFile struct {
+	name     string // the file name without directory
+	number   uint64 // 0 if there's no number in the name
+	fileType internal.FileType
+}
+
+// @description: take an inventory of database directory, the files not named by database are skipped
+// @return: the files in ascending order of number and error if any
+
+func (db *Db) listFiles() ([]dbFile, error) {
+	names, err := db.env.GetChildren(db.name)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []dbFile
+	for _, name := range names {
+		if number, fileType, ok := internal.ParseFileName(name); ok {
+			files = append(files, dbFile{name: name, number: number, fileType: fileType})
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].number < files[j].number
+	})
+	return files, nil
+}
+
+// @description: find out the numbers of log files in database directory in ascending order
+
+func (db *Db) logFileNumbers() ([]uint64, error) {
+	files, err := db.listFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []uint64
+	for _, f := range files {
+		if f.fileType == internal.LogFile {
+			numbers = append(numbers, f.number)
+		}
+	}
+	return numbers, nil
+}
+
+// @description: find out the tables of version which are missing in database directory
+// @param: the version and the inventory of directory
+// @return: the missing file numbers in ascending order
+// @note: tables of legacy leveldb named .sst are not found by table cache, so they are missing until they are repaired
+
+func (db *Db) missingTables(v *version.Version, files []dbFile) []uint64 {
+	live := v.LiveFiles()
+	for _, f := range files {
+		if f.fileType == internal.TableFile && f.name == filepath.Base(internal.TableFileName(db.name, f.number)) {
+			delete(live, f.number)
+		}
+	}
+
+	var missing []uint64
+	for number := range live {
+		missing = append(missing, number)
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i] < missing[j]
+	})
+	return missing
+}
+
+// @description: check the tables of current version in paranoid mode, they must have the sizes recorded in manifest,
+//               and files in each level >= 1 must be sorted without overlap
+// @return: internal.ErrCorruption if they're not, or error of reading file sizes
+
+func (db *Db) checkTables() error {
+	for number, fileSize := range db.current.LiveFiles() {
+		size, err := db.env.GetFileSize(internal.TableFileName(db.name, number))
+		if err != nil {
+			return err
+		}
+		if uint64(size) != fileSize {
+			db.opts.GetInfoLog().Log(logger.Error, "table size mismatches manifest", "file", number,
+				"size", size, "recorded", fileSize)
+			return internal.ErrCorruption
+		}
+	}
+	return db.current.CheckLevels()
+}
+
+// @description: keep the tables of version from being removed while it's read without lock
+// @note: REQUIRES: db.mu is held
+
+func (db *Db) refVersion(v *version.Version) {
+	db.versions[v]++
+}
+
+// @description: release the version referred by refVersion, the tables only in it are removed if it's no longer current
+// @note: REQUIRES: db.mu is held
+
+func (db *Db) unrefVersion(v *version.Version) {
+	db.versions[v]--
+	if db.versions[v] > 0 {
+		return
+	}
+	delete(db.versions, v)
+	if v != db.current && !db.closed {
+		db.removeObsoleteFiles(false)
+	}
+}
+
+// @description: remove the files in database directory which are no longer needed:
+//		log files compacted into sstable, manifests before the current one, temp files left by failed switches of CURRENT
+//		and tables neither in current version nor in the versions still read by Get and iterators
+// @param: if files numbered from the next file number are removed, which is true only on open,
+//         otherwise they may be being written by compaction and they are never taken as obsolete
+// @note: REQUIRES: db.mu is held, which is released while the directory is listed and files are removed
+
+func (db *Db) removeObsoleteFiles(removeNewFiles bool) {
+	// files are removed by one goroutine at a time, the others leave their work to it
+	if db.removingFiles {
+		db.removePending = true
+		return
+	}
+	db.removingFiles = true
+	defer func() {
+		db.removingFiles = false
+		db.cond.Broadcast()
+	}()
+
+	for {
+		db.removePending = false
+		live := db.current.LiveFiles()
+		for v := range db.versions {
+			for number, size := range v.LiveFiles() {
+				live[number] = size
+			}
+		}
+		for number := range db.unmanagedTables {
+			live[number] = 0
+		}
+		nextFileNumber := db.current.NextFileNumber()
+		if removeNewFiles {
+			nextFileNumber = math.MaxUint64
+		}
+		versionLogNumber := db.current.LogNumber()
+		logNumber := db.logNumber
+		manifestNumber := db.manifestNumber
+		current := db.current
+		db.mu.Unlock()
+
+		files, err := db.listFiles()
+		if err != nil {
+			db.opts.GetInfoLog().Log(logger.Warn, "list files fail", "error", err)
+		}
+		for _, f := range files {
+			keep := true
+			switch f.fileType {
+			case internal.LogFile:
+				keep = f.number >= versionLogNumber || f.number == logNumber
+			case internal.DescriptorFile:
+				keep = f.number >= manifestNumber
+			case internal.TempFile:
+				keep = f.number >= nextFileNumber
+			case internal.TableFile:
+				_, ok := live[f.number]
+				keep = ok || f.number >= nextFileNumber
+			}
+			if keep {
+				continue
+			}
+
+			err := db.env.RemoveFile(filepath.Join(db.name, f.name))
+			db.opts.GetInfoLog().Log(logger.Info, "remove obsolete file", "file", f.name, "error", err)
+			if f.fileType == internal.TableFile {
+				current.EvictTable(f.number)
+				db.opts.GetEventListeners().OnTableFileDeleted(event.TableFileInfo{
+					DbName:     db.name,
+					FileNumber: f.number,
+					FileName:   filepath.Join(db.name, f.name),
+					Err:        err,
+				})
+			}
+		}
+
+		db.mu.Lock()
+		if !db.removePending {
+			return
+		}
+	}
+}
+
+// @description: create a new log file for mem, the old one is synced and closed
+// @note: REQUIRES: db.mu is held
+
+func (db *Db) newLogFile() error {
+	// writes in the old log must not be lost if a synced write in the new log survives a crash
+	if db.logFile != nil {
+		if err := db.logFile.Sync(); err != nil {
+			return err
+		}
+	}
+
+	number := db.current.NewFileNumber()
+	file, err := db.env.NewWritableFile(internal.LogFileName(db.name, number))
+	if err != nil {
+		return err
+	}
+
+	if db.logFile != nil {
+		_ = db.logFile.Close()
+	}
+	db.logFile = file
+	db.log = wal.NewWriter(file)
+	db.logNumber = number
+	db.logSequence = db.current.LastSequence()
+	return nil
+}
+
+// @description: replay log files which have not been compacted into sstable to mem
+
+func (db *Db) recoverLogFiles() error {
+	numbers, err := db.logFileNumbers()
+	if err != nil {
+		return err
+	}
+
+	for _, number := range numbers {
+		db.current.MarkFileNumberUsed(number)
+		if number < db.current.LogNumber() {
+			continue // obsolete log file left by a crash
+		}
+		if err := db.recoverLogFile(number); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Db) recoverLogFile(number uint64) error {
+	file, err := db.env.NewSequentialFile(internal.LogFileName(db.name, number))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := wal.NewReader(file)
+	var batch WriteBatch
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			return nil
+		} else if err == internal.ErrCorruption {
+			continue // drop the corrupted record as leveldb does
+		} else if err != nil {
+			return err
+		}
+
+		// a batch is applied atomically, so it must be validated before inserting
+		if batch.setContents(record) != nil || batch.iterate(func(internal.ValueType, []byte, []byte) {}) != nil {
+			continue
+		}
+		_ = batch.insertInto(db.mem)
+
+		lastSeq := batch.sequence() + uint64(batch.Count()) - 1
+		if batch.Count() > 0 && lastSeq > db.current.LastSequence() {
+			db.current.SetLastSequence(lastSeq)
+		}
+	}
+}
 
+// @description: make sure that there's room in mem for writing
+// @param: the context, and force to switch memtable even if there's room unless it's empty
+// @note: REQUIRES: db.mu is held and the caller is the leader of writers
+
+func (db *Db) makeRoomForWrite(ctx context.Context, force bool) error {
+	defer db.setWriteStall(event.WriteStallNormal)
 	for true {
+		// data in imm can't be persisted, stop writing
+		if db.bgErr != nil {
+			return db.bgErr
+		}
+
+		// the caller gives up waiting for the stall
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// if there are too many files in level0, slow it down
-		if db.current.NumLevelFiles(0) >= internal.L0SlowdownWriteTrigger {
+		if !force && db.current.NumLevelFiles(0) >= internal.L0SlowdownWriteTrigger {
+			db.setWriteStall(event.WriteStallDelayed)
 			db.mu.Unlock()
-			time.Sleep(time.Duration(1000) * time.Microsecond)
+			select {
+			case <-time.After(time.Duration(1000) * time.Microsecond):
+			case <-ctx.Done():
+			}
+			db.opts.GetStatistics().RecordTick(statistics.StallMicros, 1000)
 			db.mu.Lock()
 			continue
 		}
 
 		// if there is room for data in memtable, just write it
-		if db.mem.ApproximateMemoryUsage() <= internal.WriteBufferSize {
-			return db.current.NextSeq(), nil
+		if !force && db.mem.ApproximateMemoryUsage() <= internal.WriteBufferSize {
+			return nil
+		}
+
+		// nothing to flush
+		if force {
+			iter := db.mem.NewIterator()
+			iter.SeekToFirst()
+			empty := !iter.Valid()
+			iter.Close()
+			if empty {
+				return nil
+			}
 		}
 
 		// memtable is full and immutable has not been compacted, wait until compaction is finished
-		if db.imm != nil {
+		// a manual compaction allocates file numbers from its own version, so wait until it's finished too
+		if db.imm != nil || db.bgCompactionScheduled {
+			db.setWriteStall(event.WriteStallStopped)
+			start := time.Now()
 			db.cond.Wait()
+			db.opts.GetStatistics().RecordTick(statistics.StallMicros, uint64(time.Since(start).Microseconds()))
 		} else {
+			// switch to a new log file and a new memtable
+			if err := db.newLogFile(); err != nil {
+				return err
+			}
 			db.imm = db.mem
 			db.mem = memtable.New()
+			force = false
 			db.maybeScheduleCompaction()
 		}
 	}
 
-	return db.current.NextSeq(), nil
+	return nil
 }
 
-func Open(dbName string) *Db {
+// @description: notify listeners if the write stall condition is changed
+// @note: REQUIRES: db.mu is held
+
+func (db *Db) setWriteStall(condition event.WriteStallCondition) {
+	if db.writeStall == condition {
+		return
+	}
+	info := event.WriteStallInfo{DbName: db.name, Previous: db.writeStall, Current: condition}
+	db.writeStall = condition
+	db.opts.GetEventListeners().OnWriteStallChange(info)
+}
+
+// @description: merge write batches of writers in queue from the leader
+// @return: the merged batch and the last writer in the group
+// @note: REQUIRES: db.mu is held and the writer queue is not empty
+
+func (db *Db) buildBatchGroup() (*WriteBatch, *writer) {
+	first := db.writers[0]
+	result := first.batch
+	size := first.batch.ApproximateSize()
+
+	// limit the growth of small writes, so that they are not slowed down too much
+	maxSize := kMaxBatchGroupSize
+	if size <= kSmallBatchGroupSize {
+		maxSize = size + kSmallBatchGroupSize
+	}
+
+	last := first
+	for _, w := range db.writers[1:] {
+		// a sync write should not be handled by a non-sync leader
+		if w.sync && !first.sync {
+			break
+		}
+
+		// the writer with nil batch switches memtable by itself as a leader
+		if w.batch == nil {
+			break
+		}
+
+		size += w.batch.ApproximateSize()
+		if size > maxSize {
+			break
+		}
+
+		// don't modify the batch of caller, merge them in tmpBatch
+		if result == first.batch {
+			db.tmpBatch.Clear()
+			db.tmpBatch.Append(first.batch)
+			result = &db.tmpBatch
+		}
+		result.Append(w.batch)
+		w.grouped = true
+		last = w
+	}
+
+	return result, last
+}
+
+func Open(dbName string, opts *opt.Options) (*Db, error) {
 	var db Db
 	db.name = dbName
 	db.mem = memtable.New()
 	db.imm = nil
 	db.bgCompactionScheduled = false
 	db.cond = sync.NewCond(&db.mu)
+	db.env = opts.GetEnv()
+	db.versions = make(map[*version.Version]int)
+	db.unmanagedTables = make(map[uint64]bool)
 
-	num := db.ReadCurrentFile()
-	if num > 0 {
-		v, err := version.Load(dbName, num)
+	if err := db.env.CreateDir(dbName); err != nil {
+		return nil, err
+	}
+
+	// the database can't be opened by others until it's closed, and LOG of it must not be rotated by them either
+	lock, err := db.env.LockFile(internal.LockFileName(dbName))
+	if err != nil {
+		return nil, err
+	}
+	db.lock = lock
+
+	// options are copied so that the default info logger can be filled in and shared by version
+	var sanitized opt.Options
+	if opts != nil {
+		sanitized = *opts
+	}
+	if sanitized.InfoLog == nil {
+		infoLogFile, err := logger.NewFileLogger(db.env, dbName, logger.Info)
 		if err != nil {
-			return nil
+			_ = lock.Close()
+			return nil, err
+		}
+		sanitized.InfoLog = infoLogFile
+		db.infoLogFile = infoLogFile
+	}
+	opts = &sanitized
+	db.opts = opts
+
+	fail := func(err error) (*Db, error) {
+		if db.infoLogFile != nil {
+			_ = db.infoLogFile.Close()
+		}
+		_ = lock.Close()
+		return nil, err
+	}
+
+	num, loaded := db.ReadCurrentFile()
+	if loaded {
+		v, err := version.Load(dbName, num, opts)
+		if err != nil {
+			return fail(err)
 		}
 		db.current = v
+		db.manifestNumber = num
 	} else {
-		db.current = version.New(dbName)
+		db.current = version.New(dbName, opts)
+	}
+
+	// a missing table can't be told until it's read, so find it out before serving any read
+	files, err := db.listFiles()
+	if err != nil {
+		return fail(err)
+	}
+	if missing := db.missingTables(db.current, files); len(missing) > 0 {
+		opts.GetInfoLog().Log(logger.Error, "tables in manifest are missing", "files", missing)
+		return fail(internal.ErrCorruption)
+	}
+	if opts.GetParanoidChecks() {
+		if err := db.checkTables(); err != nil {
+			return fail(err)
+		}
+	}
+
+	// numbers of the files found are never handed out again, or the files kept are overwritten
+	// tables are kept if there's no manifest, since they are needed by Repair
+	for _, f := range files {
+		db.current.MarkFileNumberUsed(f.number)
+		if !loaded && f.fileType == internal.TableFile {
+			db.unmanagedTables[f.number] = true
+		}
+	}
+
+	if err := db.recoverLogFiles(); err != nil {
+		return fail(err)
+	}
+	if err := db.newLogFile(); err != nil {
+		return fail(err)
+	}
+
+	// nothing reads tables yet, so the ones left by crashed compactions can be removed
+	db.mu.Lock()
+	db.removeObsoleteFiles(true)
+	db.mu.Unlock()
+
+	return &db, nil
+}
+
+// @description: wake up the waiters on cond of database and the writer once the context is done,
+//               so that they can check the context and give up waiting
+// @param: the context and the writer, nil if there's no writer
+// @return: the function to stop watching, which must be called when waiting is finished
+
+func (db *Db) watchContext(ctx context.Context, w *writer) func() {
+	if ctx.Done() == nil {
+		return func() {}
 	}
 
-	return &db
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			db.mu.Lock()
+			db.cond.Broadcast()
+			if w != nil {
+				w.cond.Signal()
+			}
+			db.mu.Unlock()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
 }
 
 func (db *Db) Close() {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	for db.bgCompactionScheduled {
+	for db.bgCompactionScheduled || db.removingFiles {
 		db.cond.Wait()
 	}
+	db.closed = true
+	if db.logFile != nil {
+		_ = db.logFile.Close()
+	}
+
+	// tables read by iterators which are still open are closed along with the iterators
+	db.current.PurgeTables()
+	if db.infoLogFile != nil {
+		_ = db.infoLogFile.Close()
+	}
+	if db.lock != nil {
+		_ = db.lock.Close()
+		db.lock = nil
+	}
 }
 
-func (db *Db) Put(key, value []byte) error {
-	seq, err := db.makeRoomForWrite()
-	if err != nil {
-		return err
+// @description: apply a write batch to database atomically
+//               concurrent writers are queued, the head of queue merges batches of others and writes them to log at once
+// @param: write options and the batch
+// @return: error if any
+
+func (db *Db) Write(opts *opt.WriteOptions, batch *WriteBatch) error {
+	return db.WriteContext(context.Background(), opts, batch)
+}
+
+// @description: apply a write batch to database atomically, which gives up if the context is done before it's written
+// @param: the context, write options and the batch, nil batch switches memtable so that it's flushed
+// @return: error if any, ctx.Err() if the write is stalled or queued until the context is done
+// @note: the batch is either written as a whole or not at all, it's too late to give up once it's merged by a leader
+
+func (db *Db) WriteContext(ctx context.Context, opts *opt.WriteOptions, batch *WriteBatch) error {
+	stats := db.opts.GetStatistics()
+	if batch != nil {
+		defer stats.MeasureSince(statistics.DbWrite, stats.Start())
+		stats.RecordTick(statistics.KeysWritten, uint64(batch.Count()))
+		stats.RecordTick(statistics.BytesWritten, uint64(len(batch.contents())))
 	}
 
-	// TODO: WAL log
+	w := writer{
+		batch: batch,
+		sync:  opts.GetSync(),
+		cond:  sync.NewCond(&db.mu),
+	}
 
-	db.mem.Add(seq, internal.TypeValue, key, value)
-	return nil
+	stop := db.watchContext(ctx, &w)
+	defer stop()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// wait until the writer is done by a leader or become the leader
+	db.writers = append(db.writers, &w)
+	for !w.done && &w != db.writers[0] {
+		if err := ctx.Err(); err != nil && !w.grouped {
+			db.removeWriter(&w)
+			return err
+		}
+		w.cond.Wait()
+	}
+	if w.done {
+		return w.err
+	}
+
+	err := db.makeRoomForWrite(ctx, batch == nil)
+	last := &w
+	if err == nil && batch != nil {
+		var updates *WriteBatch
+		updates, last = db.buildBatchGroup()
+		seq := db.current.LastSequence() + 1
+		updates.setSequence(seq)
+
+		// only the leader writes log and mem, so other writers can be queued while writing
+		mem := db.mem
+		log := db.log
+		logFile := db.logFile
+		db.mu.Unlock()
+
+		err = log.AddRecord(updates.contents())
+		if err == nil && w.sync {
+			err = logFile.Sync()
+		}
+		if err == nil {
+			err = updates.insertInto(mem)
+		}
+
+		db.mu.Lock()
+		if err == nil {
+			db.current.SetLastSequence(seq + uint64(updates.Count()) - 1)
+		} else {
+			// the record may be partly written or written but not synced, it's unknown whether it's in log after recovery,
+			// so stop writing like leveldb does, otherwise later writes may be acknowledged after the failed one is recovered
+			db.bgErr = err
+		}
+		if updates == &db.tmpBatch {
+			db.tmpBatch.Clear()
+		}
+	}
+
+	// wake up the writers in group with result, and then the new head of queue
+	for {
+		ready := db.writers[0]
+		db.writers = db.writers[1:]
+		if ready != &w {
+			ready.err = err
+			ready.done = true
+			ready.cond.Signal()
+		}
+		if ready == last {
+			break
+		}
+	}
+	if len(db.writers) > 0 {
+		db.writers[0].cond.Signal()
+	}
+
+	return err
+}
+
+// @description: remove a writer which gives up waiting from the queue
+// @note: REQUIRES: db.mu is held and the writer is not the head of queue
+
+func (db *Db) removeWriter(w *writer) {
+	for i, queued := range db.writers {
+		if queued == w {
+			db.writers = append(db.writers[:i], db.writers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (db *Db) Put(key, value []byte) error {
+	return db.PutContext(context.Background(), key, value)
+}
+
+// @description: put the key and value, which gives up if the context is done before it's written
+
+func (db *Db) PutContext(ctx context.Context, key, value []byte) error {
+	stats := db.opts.GetStatistics()
+	defer stats.MeasureSince(statistics.DbPut, stats.Start())
+
+	var batch WriteBatch
+	batch.Put(key, value)
+	return db.WriteContext(ctx, nil, &batch)
 }
 
 func (db *Db) Get(key []byte) ([]byte, error) {
+	return db.GetWithOptions(nil, key)
+}
+
+// @description: get the value of key
+// @param: read options, the perf context in it records how the key is found, and the key
+// @return: the value and error, ErrNotFound if the key doesn't exist
+
+func (db *Db) GetWithOptions(opts *opt.ReadOptions, key []byte) ([]byte, error) {
+	return db.getContext(context.Background(), opts, key)
+}
+
+// @description: get the value of key, which gives up if the context is done before sstables are searched
+// @param: the context and the key, the perf context attached to the context by statistics.WithPerfContext is used if any
+// @return: the value and error, ErrNotFound if the key doesn't exist and ctx.Err() if the context is done
+
+func (db *Db) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	return db.getContext(ctx, nil, key)
+}
+
+func (db *Db) getContext(ctx context.Context, opts *opt.ReadOptions, key []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := db.opts.GetStatistics()
+	defer stats.MeasureSince(statistics.DbGet, stats.Start())
+	stats.RecordTick(statistics.KeysRead, 1)
+	perf := opts.GetPerfContext()
+	if perf == nil {
+		perf = statistics.PerfContextFromContext(ctx)
+	}
+	start := time.Now()
+
 	db.mu.Lock()
 	mem := db.mem
 	imm := db.imm
 	current := db.current
+	db.refVersion(current)
 	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.unrefVersion(current)
+		db.mu.Unlock()
+	}()
 
 	// first try to find it in memtable
 	value, err := mem.Get(key)
-	if err != internal.ErrNotFound {
-		return value, err
+	memtables := uint64(1)
+	if err == internal.ErrNotFound && imm != nil {
+		// then try to find it in immutable
+		value, err = imm.Get(key)
+		memtables++
+	}
+	if perf != nil {
+		perf.MemtablesConsulted += memtables
+		perf.MemtableGetTime += time.Since(start)
 	}
 
-	// then try to find it in immutable
-	if imm != nil {
-		value, err := imm.Get(key)
-		if err != internal.ErrNotFound {
-			return value, err
+	if err != internal.ErrNotFound {
+		stats.RecordTick(statistics.MemtableHit, 1)
+	} else if ctxErr := ctx.Err(); ctxErr != nil {
+		value, err = nil, ctxErr
+	} else {
+		// finally try to find it in version
+		stats.RecordTick(statistics.MemtableMiss, 1)
+		tableStart := time.Now()
+		value, err = current.Get(key, perf)
+		if perf != nil {
+			perf.TableGetTime += time.Since(tableStart)
 		}
 	}
 
-	// finally try to find it in version
-	return current.Get(key)
+	if err == nil {
+		stats.RecordTick(statistics.BytesRead, uint64(len(value)))
+	}
+	if perf != nil {
+		perf.GetTime += time.Since(start)
+	}
+	return value, err
 }
 
 func (db *Db) Delete(key []byte) error {
-	seq, err := db.makeRoomForWrite()
-	if err != nil {
-		return err
+	return db.DeleteContext(context.Background(), key)
+}
+
+// @description: delete the key, which gives up if the context is done before it's written
+
+func (db *Db) DeleteContext(ctx context.Context, key []byte) error {
+	stats := db.opts.GetStatistics()
+	defer stats.MeasureSince(statistics.DbDelete, stats.Start())
+
+	var batch WriteBatch
+	batch.Delete(key)
+	return db.WriteContext(ctx, nil, &batch)
+}
+
+// @description: estimate the number of bytes in sstables used by each key range
+// @param: the key ranges, nil start means before all keys and nil limit means after all keys
+// @return: the approximate size of each range, data in memtable is not counted
+// @note: the result may be imprecise if the data is compressed
+
+func (db *Db) GetApproximateSizes(ranges []Range) []uint64 {
+	db.mu.Lock()
+	current := db.current
+	db.refVersion(current)
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.unrefVersion(current)
+		db.mu.Unlock()
+	}()
+
+	sizes := make([]uint64, len(ranges))
+	for i, r := range ranges {
+		start := current.ApproximateOffsetOf(r.Start)
+		var limit uint64
+		if r.Limit == nil {
+			for level := 0; level < internal.NumLevels; level++ {
+				limit += current.NumLevelBytes(level)
+			}
+		} else {
+			limit = current.ApproximateOffsetOf(r.Limit)
+		}
+		if limit > start {
+			sizes[i] = limit - start
+		}
 	}
-	db.mem.Add(seq, internal.TypeDeletion, key, nil)
-	return nil
+	return sizes
 }
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/opt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// the crash test writes to database with faults injected, crashes it by dropping unsynced data, and then reopens it
+// recovered database must be the result of a prefix of acknowledged writes, which includes every synced write
+
+type crashWrite struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// @description: find the prefix of writes which the database is the result of
+// @param: the writes and the number of writes which must be in the prefix
+// @return: the length of the prefix
+
+func verifyCrashRecovery(t *testing.T, db *Db, history []crashWrite, durable int) int {
+	recovered := make(map[string][]byte)
+	iter := db.NewIterator(nil)
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		recovered[string(iter.Key())] = append([]byte(nil), iter.Value()...)
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal("iterate recovered database fail", err)
+	}
+	iter.Close()
+
+	expected := make(map[string][]byte)
+	apply := func(w crashWrite) {
+		if w.delete {
+			delete(expected, w.key)
+		} else {
+			expected[w.key] = w.value
+		}
+	}
+	equal := func() bool {
+		if len(expected) != len(recovered) {
+			return false
+		}
+		for key, value := range expected {
+			if !bytes.Equal(recovered[key], value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, w := range history[:durable] {
+		apply(w)
+	}
+	for n := durable; ; n++ {
+		if equal() {
+			return n
+		}
+		if n == len(history) {
+			break
+		}
+		apply(history[n])
+	}
+	t.Fatal("recovered database is not a prefix of writes with all synced ones", durable, len(history), len(recovered))
+	return 0
+}
+
+func Test_Db_Crash(t *testing.T) {
+	for _, compatible := range []bool{false, true} {
+		t.Run(fmt.Sprintf("LevelDBCompatible=%v", compatible), func(t *testing.T) {
+			testCrash(t, compatible)
+		})
+	}
+}
+
+func testCrash(t *testing.T, levelDBCompatible bool) {
+	seed := time.Now().UnixNano()
+	t.Log("seed", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	fs := env.NewFaultInjectionEnv(env.NewMemEnv())
+	opts := &opt.Options{Env: fs, LevelDBCompatible: levelDBCompatible}
+	dbName := "/crash"
+	faultPoints := [...]int{
+		env.FaultOpWrite:  3000,
+		env.FaultOpSync:   100,
+		env.FaultOpCreate: 20,
+		env.FaultOpRename: 5,
+		env.FaultOpRemove: 5,
+	}
+
+	var history []crashWrite // acknowledged writes, and the failed one at the end which may or may not be written
+	durable := 0             // number of writes which must survive the crash
+	for round := 0; round < 20; round++ {
+		db, err := Open(dbName, opts)
+		if err != nil {
+			t.Fatal("open fail", round, err)
+		}
+		durable = verifyCrashRecovery(t, db, history, durable)
+		history = history[:durable]
+
+		// make an operation fail at some point in half of rounds
+		if r.Intn(2) == 0 {
+			op := env.FaultOp(r.Intn(len(faultPoints)))
+			fs.FailAfter(op, r.Intn(faultPoints[op]))
+		}
+
+		// enough data to trigger flushes and compactions
+		n := r.Intn(2000)
+		for i := 0; i < n; i++ {
+			w := crashWrite{
+				key:    fmt.Sprintf("key%03d", r.Intn(200)),
+				value:  bytes.Repeat([]byte(fmt.Sprintf("%d-%d;", round, i)), r.Intn(1000)),
+				delete: r.Intn(10) == 0,
+			}
+			var batch WriteBatch
+			if w.delete {
+				batch.Delete([]byte(w.key))
+			} else {
+				batch.Put([]byte(w.key), w.value)
+			}
+			sync := r.Intn(50) == 0
+
+			history = append(history, w)
+			if err := db.Write(&opt.WriteOptions{Sync: sync}, &batch); err != nil {
+				break
+			}
+			if sync {
+				durable = len(history)
+			}
+		}
+
+		// crash, the database can't modify files any more before it's closed
+		fs.SetActive(false)
+		db.Close()
+		for op := range faultPoints {
+			fs.FailAfter(env.FaultOp(op), -1)
+		}
+		if err := fs.DropUnsyncedData(); err != nil {
+			t.Fatal("drop unsynced data fail", err)
+		}
+		fs.SetActive(true)
+	}
+}
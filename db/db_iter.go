@@ -0,0 +1,412 @@
+// The iterator of database merges memtable, immutable and sstables, and yields user keys in order:
+// entries newer than the snapshot sequence are invisible, and only the newest visible entry of a user key is yielded unless it's a deletion
+// The underlying iterator is positioned at the yielded entry when moving forward,
+// and it's positioned before all entries of the yielded user key when moving backward, so the key and value are saved
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"github.com/jo3yzhu/goveldb/filter"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
+	"github.com/jo3yzhu/goveldb/statistics"
+	"github.com/jo3yzhu/goveldb/version"
+)
+
+type Iterator interface {
+	// Returns true if the iterator is positioned at a valid node.
+	Valid() bool
+
+	// Returns the key at the current position.
+	// REQUIRES: Valid()
+	Key() []byte
+
+	// Return the value for the current entry.  The underlying storage for
+	// the returned slice is valid only until the next modification of
+	// the iterator.
+	// REQUIRES: Valid()
+	Value() []byte
+
+	// Advances to the next position.
+	// REQUIRES: Valid()
+	Next()
+
+	// Advances to the previous position.
+	// REQUIRES: Valid()
+	Prev()
+
+	// Advance to the first entry with a key >= target
+	Seek(target []byte)
+
+	// Position at the first entry in list.
+	// Final state of iterator is Valid() iff list is not empty.
+	SeekToFirst()
+
+	// Position at the last entry in list.
+	// Final state of iterator is Valid() iff list is not empty.
+	SeekToLast()
+
+	// Returns the error encountered while iterating, such as read failure or corruption.
+	// The iterator may be invalid before reaching the end if there's error.
+	Error() error
+
+	// Release the resources held by iterator, it must not be used after that.
+	Close()
+}
+
+const (
+	kForward = iota
+	kReverse
+)
+
+type dbIter struct {
+	iter       internal.InternalIterator
+	sequence   uint64 // snapshot of database
+	lowerBound []byte // inclusive, nil means unbounded
+	upperBound []byte // exclusive, nil means unbounded
+	direction  int
+	valid      bool
+	savedKey   []byte // current key when moving backward, or the key to skip when moving forward
+	savedValue []byte // current value when moving backward
+
+	prefixExtractor filter.PrefixExtractor // not nil in prefix mode, where sstables are skipped by prefix bloom filter on Seek
+	prefix          []byte                 // prefix of the seek target in prefix mode, nil means keys are not confined
+	err             error
+	stats           *statistics.Statistics
+
+	ctx  context.Context // the iteration stops with its error once it's done
+	done <-chan struct{} // nil if the context can never be done
+
+	release func() // release the version iterated, so that its tables can be removed, nil once it's closed
+}
+
+// @description: create an iterator over the database at the current sequence
+// @param: read options, keys out of the bounds in it are invisible, nil means the default one
+// @note: the iterator is not affected by writes after it's created
+
+func (db *Db) NewIterator(opts *opt.ReadOptions) Iterator {
+	return db.NewIteratorContext(context.Background(), opts)
+}
+
+// @description: create an iterator over the database at the current sequence, which is bound to a context
+// @param: the context and read options, the perf context attached to the context is used if there's none in read options
+// @note: once the context is done, the iterator becomes invalid and Error returns ctx.Err(),
+//        so that a long scan over deleted or invisible entries can be interrupted
+
+func (db *Db) NewIteratorContext(ctx context.Context, opts *opt.ReadOptions) Iterator {
+	if opts.GetPerfContext() == nil {
+		if perf := statistics.PerfContextFromContext(ctx); perf != nil {
+			// the read options of caller are not modified
+			withPerf := opt.ReadOptions{}
+			if opts != nil {
+				withPerf = *opts
+			}
+			withPerf.PerfContext = perf
+			opts = &withPerf
+		}
+	}
+
+	db.mu.Lock()
+	list := []internal.InternalIterator{db.mem.NewIterator()}
+	if db.imm != nil {
+		list = append(list, db.imm.NewIterator())
+	}
+	current := db.current
+	list = append(list, current.NewIterators(opts)...)
+	sequence := current.LastSequence()
+	db.refVersion(current)
+	db.mu.Unlock()
+
+	iter := &dbIter{
+		iter:       version.NewMergingIterator(list),
+		sequence:   sequence,
+		lowerBound: opts.GetLowerBound(),
+		upperBound: opts.GetUpperBound(),
+		stats:      db.opts.GetStatistics(),
+		ctx:        ctx,
+		done:       ctx.Done(),
+		release: func() {
+			db.mu.Lock()
+			db.unrefVersion(current)
+			db.mu.Unlock()
+		},
+	}
+	if opts.GetPrefixSameAsStart() {
+		iter.prefixExtractor = db.opts.GetPrefixExtractor()
+	}
+	return iter
+}
+
+// @description: create an iterator over keys with certain prefix
+// @param: the prefix, all keys are iterated if it's empty
+
+func (db *Db) PrefixIterator(prefix []byte) Iterator {
+	return db.NewIterator(&opt.ReadOptions{
+		LowerBound: prefix,
+		UpperBound: prefixSuccessor(prefix),
+	})
+}
+
+// @description: find the smallest key greater than all keys with prefix
+// @return: the key, nil if there's no such key, such as prefix of all 0xff
+
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			successor := append([]byte(nil), prefix[:i+1]...)
+			successor[i]++
+			return successor
+		}
+	}
+	return nil
+}
+
+func (iter *dbIter) Valid() bool {
+	return iter.valid
+}
+
+func (iter *dbIter) Key() []byte {
+	if iter.direction == kForward {
+		return iter.iter.InternalKey().UserKey
+	}
+	return iter.savedKey
+}
+
+func (iter *dbIter) Value() []byte {
+	if iter.direction == kForward {
+		return iter.iter.InternalKey().UserValue
+	}
+	return iter.savedValue
+}
+
+func (iter *dbIter) Next() {
+	if iter.direction == kReverse {
+		// the underlying iterator is before all entries of savedKey, move into them and then skip them
+		iter.direction = kForward
+		if iter.iter.Valid() {
+			iter.iter.Next()
+		} else {
+			iter.iter.SeekToFirst()
+		}
+	} else {
+		// skip entries of current key
+		iter.savedKey = append(iter.savedKey[:0], iter.iter.InternalKey().UserKey...)
+		iter.iter.Next()
+	}
+
+	iter.findNextUserEntry(true)
+}
+
+func (iter *dbIter) Prev() {
+	if iter.unsupported() {
+		return
+	}
+
+	if iter.direction == kForward {
+		// move the underlying iterator before all entries of current key
+		iter.savedKey = append(iter.savedKey[:0], iter.iter.InternalKey().UserKey...)
+		for {
+			iter.iter.Prev()
+			if !iter.iter.Valid() {
+				iter.valid = false
+				iter.savedKey = iter.savedKey[:0]
+				iter.savedValue = iter.savedValue[:0]
+				return
+			}
+			if internal.UserKeyComparator(iter.iter.InternalKey().UserKey, iter.savedKey) < 0 {
+				break
+			}
+		}
+		iter.direction = kReverse
+	}
+
+	iter.findPrevUserEntry()
+}
+
+func (iter *dbIter) Seek(target []byte) {
+	defer iter.stats.MeasureSince(statistics.DbSeek, iter.stats.Start())
+	iter.err = nil
+	iter.direction = kForward
+	iter.savedKey = iter.savedKey[:0]
+	if iter.lowerBound != nil && internal.UserKeyComparator(target, iter.lowerBound) < 0 {
+		target = iter.lowerBound
+	}
+	iter.prefix = nil
+	if iter.prefixExtractor != nil && iter.prefixExtractor.InDomain(target) {
+		iter.prefix = append([]byte(nil), iter.prefixExtractor.Transform(target)...)
+	}
+	iter.iter.Seek(target)
+	iter.findNextUserEntry(false)
+}
+
+func (iter *dbIter) SeekToFirst() {
+	if iter.unsupported() {
+		return
+	}
+	if iter.lowerBound != nil {
+		iter.Seek(iter.lowerBound)
+		return
+	}
+
+	iter.direction = kForward
+	iter.savedKey = iter.savedKey[:0]
+	iter.iter.SeekToFirst()
+	iter.findNextUserEntry(false)
+}
+
+func (iter *dbIter) SeekToLast() {
+	if iter.unsupported() {
+		return
+	}
+
+	iter.direction = kReverse
+	iter.savedKey = iter.savedKey[:0]
+
+	// move to the last entry before upper bound
+	if iter.upperBound != nil {
+		iter.iter.Seek(iter.upperBound)
+		if iter.iter.Valid() {
+			iter.iter.Prev()
+		} else {
+			iter.iter.SeekToLast()
+		}
+	} else {
+		iter.iter.SeekToLast()
+	}
+	iter.findPrevUserEntry()
+}
+
+// @description: move forward to the newest visible entry of a user key which is not deleted
+// @param: if entries whose user key <= savedKey should be skipped
+
+func (iter *dbIter) findNextUserEntry(skipping bool) {
+	for ; iter.iter.Valid(); iter.iter.Next() {
+		if iter.cancelled() {
+			break
+		}
+		key := iter.iter.InternalKey()
+		if iter.upperBound != nil && internal.UserKeyComparator(key.UserKey, iter.upperBound) >= 0 {
+			break // the rest are out of bounds
+		}
+		if iter.prefix != nil && !iter.hasPrefix(key.UserKey) {
+			break // the rest have different prefixes
+		}
+		if key.Seq > iter.sequence {
+			continue
+		}
+
+		switch key.Type {
+		case internal.TypeDeletion:
+			// older entries of the deleted key are hidden
+			iter.savedKey = append(iter.savedKey[:0], key.UserKey...)
+			skipping = true
+		case internal.TypeValue:
+			if skipping && internal.UserKeyComparator(key.UserKey, iter.savedKey) <= 0 {
+				continue // hidden by newer entry
+			}
+			iter.valid = true
+			iter.savedKey = iter.savedKey[:0]
+			return
+		}
+	}
+
+	iter.valid = false
+	iter.savedKey = iter.savedKey[:0]
+}
+
+// @description: move backward until all entries of a user key whose newest visible entry is not deleted are passed
+//               the key and value of the newest visible entry are saved
+
+func (iter *dbIter) findPrevUserEntry() {
+	valueType := internal.TypeDeletion
+	for ; iter.iter.Valid(); iter.iter.Prev() {
+		if iter.cancelled() {
+			valueType = internal.TypeDeletion
+			break
+		}
+		key := iter.iter.InternalKey()
+		if iter.lowerBound != nil && internal.UserKeyComparator(key.UserKey, iter.lowerBound) < 0 {
+			break // the rest are out of bounds
+		}
+		if key.Seq > iter.sequence {
+			continue
+		}
+
+		// the entries of savedKey have been passed, and the newest one is a value
+		if valueType != internal.TypeDeletion && internal.UserKeyComparator(key.UserKey, iter.savedKey) < 0 {
+			break
+		}
+
+		valueType = key.Type
+		if valueType == internal.TypeDeletion {
+			iter.savedKey = iter.savedKey[:0]
+			iter.savedValue = iter.savedValue[:0]
+		} else {
+			iter.savedKey = append(iter.savedKey[:0], key.UserKey...)
+			iter.savedValue = append(iter.savedValue[:0], key.UserValue...)
+		}
+	}
+
+	if valueType == internal.TypeDeletion {
+		// no entry is found
+		iter.valid = false
+		iter.savedKey = iter.savedKey[:0]
+		iter.savedValue = iter.savedValue[:0]
+		iter.direction = kForward
+	} else {
+		iter.valid = true
+	}
+}
+
+// @description: check if the context of iterator is done
+// @return: true if it's done, and the error of context is saved
+
+func (iter *dbIter) cancelled() bool {
+	if iter.done == nil {
+		return false
+	}
+	select {
+	case <-iter.done:
+		iter.err = iter.ctx.Err()
+		return true
+	default:
+		return false
+	}
+}
+
+// @description: check if the key has the same prefix as the seek target
+
+func (iter *dbIter) hasPrefix(key []byte) bool {
+	return iter.prefixExtractor.InDomain(key) && bytes.Equal(iter.prefixExtractor.Transform(key), iter.prefix)
+}
+
+// @description: moving backward and seeking without target are not supported in prefix mode,
+//               since the underlying iterators skipped by prefix bloom filter are not positioned in total order
+// @return: true if the iterator is in prefix mode, and it's invalidated with ErrNotSupported
+
+func (iter *dbIter) unsupported() bool {
+	if iter.prefixExtractor == nil {
+		return false
+	}
+	iter.valid = false
+	iter.err = internal.ErrNotSupported
+	return true
+}
+
+func (iter *dbIter) Error() error {
+	if iter.err != nil {
+		return iter.err
+	}
+	return iter.iter.Error()
+}
+
+func (iter *dbIter) Close() {
+	iter.iter.Close()
+	iter.valid = false
+	if iter.release != nil {
+		iter.release()
+		iter.release = nil
+	}
+}
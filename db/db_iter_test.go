@@ -0,0 +1,193 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/filter"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
+	"testing"
+)
+
+func Test_Db_Iterator(t *testing.T) {
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv()})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+
+	_ = db.Put([]byte("a"), []byte("1"))
+	_ = db.Put([]byte("b"), []byte("2"))
+	_ = db.Put([]byte("c"), []byte("3"))
+	_ = db.Put([]byte("b"), []byte("22"))
+	_ = db.Delete([]byte("c"))
+	_ = db.Put([]byte("d"), []byte("4"))
+
+	iter := db.NewIterator(nil)
+
+	// writes after the iterator is created are invisible
+	_ = db.Put([]byte("e"), []byte("5"))
+	_ = db.Delete([]byte("a"))
+
+	var forward []string
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		forward = append(forward, string(iter.Key())+"="+string(iter.Value()))
+	}
+	if fmt.Sprint(forward) != "[a=1 b=22 d=4]" {
+		t.Fatal("forward iteration error", forward)
+	}
+
+	var backward []string
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		backward = append(backward, string(iter.Key())+"="+string(iter.Value()))
+	}
+	if fmt.Sprint(backward) != "[d=4 b=22 a=1]" {
+		t.Fatal("backward iteration error", backward)
+	}
+
+	// switch direction
+	iter.Seek([]byte("c"))
+	if !iter.Valid() || string(iter.Key()) != "d" {
+		t.Fatal("seek error")
+	}
+	iter.Prev()
+	if !iter.Valid() || string(iter.Key()) != "b" || string(iter.Value()) != "22" {
+		t.Fatal("prev error")
+	}
+	iter.Next()
+	if !iter.Valid() || string(iter.Key()) != "d" {
+		t.Fatal("next after prev error")
+	}
+}
+
+func Test_Db_Iterator_LevelDBCompatible(t *testing.T) {
+	fs := env.NewMemEnv()
+	copyDirToEnv(t, "testdata/leveldb", fs, "/leveldb")
+	db, err := Open("/leveldb", &opt.Options{Env: fs, LevelDBCompatible: true})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+
+	// keys are in sstable and the updates are in memtable
+	iter := db.NewIterator(nil)
+	n := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if n == 0 && (string(iter.Key()) != "key000000" || string(iter.Value()) != "updated") {
+			t.Fatal("updated key error")
+		}
+		if n == 1 && string(iter.Key()) != "key000002" {
+			t.Fatal("deleted key is found")
+		}
+		n++
+	}
+	if n != 1999 {
+		t.Fatal("forward count error", n)
+	}
+
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		n--
+	}
+	if n != 0 {
+		t.Fatal("backward count error", n)
+	}
+}
+
+func Test_Db_Iterator_Bounds(t *testing.T) {
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv()})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"a1", "a2", "b1", "b2", "c1"} {
+		_ = db.Put([]byte(key), []byte(key))
+	}
+
+	collect := func(iter Iterator, forward bool) []string {
+		var keys []string
+		if forward {
+			for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+		} else {
+			for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+				keys = append(keys, string(iter.Key()))
+			}
+		}
+		return keys
+	}
+
+	iter := db.NewIterator(&opt.ReadOptions{LowerBound: []byte("a2"), UpperBound: []byte("b2")})
+	if fmt.Sprint(collect(iter, true)) != "[a2 b1]" {
+		t.Fatal("forward iteration with bounds error")
+	}
+	if fmt.Sprint(collect(iter, false)) != "[b1 a2]" {
+		t.Fatal("backward iteration with bounds error")
+	}
+	iter.Seek([]byte("a"))
+	if !iter.Valid() || string(iter.Key()) != "a2" {
+		t.Fatal("seek before lower bound error")
+	}
+	iter.Seek([]byte("c"))
+	if iter.Valid() {
+		t.Fatal("seek after upper bound error")
+	}
+
+	if fmt.Sprint(collect(db.PrefixIterator([]byte("b")), true)) != "[b1 b2]" {
+		t.Fatal("prefix iteration error")
+	}
+	if fmt.Sprint(collect(db.PrefixIterator([]byte("c")), false)) != "[c1]" {
+		t.Fatal("backward prefix iteration error")
+	}
+	if string(prefixSuccessor([]byte("a\xff"))) != "b" || prefixSuccessor([]byte("\xff")) != nil {
+		t.Fatal("prefix successor error")
+	}
+}
+
+func Test_Db_Iterator_PrefixSameAsStart(t *testing.T) {
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv(), PrefixExtractor: filter.NewDelimiterPrefixExtractor(':')})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+
+	// fill memtable so that some prefixes are compacted into sstables
+	value := bytes.Repeat([]byte("x"), 1024)
+	for i := 0; i < 4000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("p%d:%04d", i/1000, i)), value)
+	}
+	_ = db.Put([]byte("q"), value)
+
+	iter := db.NewIterator(&opt.ReadOptions{PrefixSameAsStart: true})
+	defer iter.Close()
+	for p := 0; p < 4; p++ {
+		n := 0
+		for iter.Seek([]byte(fmt.Sprintf("p%d:", p))); iter.Valid(); iter.Next() {
+			if !bytes.HasPrefix(iter.Key(), []byte(fmt.Sprintf("p%d:", p))) {
+				t.Fatal("key with another prefix is yielded", string(iter.Key()))
+			}
+			n++
+		}
+		if iter.Error() != nil || n != 1000 {
+			t.Fatal("prefix iteration error", p, n, iter.Error())
+		}
+	}
+
+	iter.Seek([]byte("p9:"))
+	if iter.Valid() || iter.Error() != nil {
+		t.Fatal("absent prefix is found")
+	}
+
+	// target without prefix is iterated in total order
+	iter.Seek([]byte("p3"))
+	if !iter.Valid() || string(iter.Key()) != "p3:3000" {
+		t.Fatal("seek without prefix error")
+	}
+
+	iter.Prev()
+	if iter.Valid() || iter.Error() != internal.ErrNotSupported {
+		t.Fatal("prev is supported in prefix mode")
+	}
+}
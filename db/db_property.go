@@ -0,0 +1,79 @@
+// Properties of database are named with prefix "goveldb.", the supported ones are:
+//		goveldb.num-files-at-level<N>: the number of files at level N
+//		goveldb.stats: the number of files, size and compaction cost of each level
+//		goveldb.sstables: the files of each level with their key ranges
+//		goveldb.approximate-memory-usage: the number of bytes used by memtables
+//		goveldb.background-errors: the number of errors of background compactions
+
+package db
+
+import (
+	"fmt"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/version"
+	"strconv"
+	"strings"
+)
+
+const kPropertyPrefix = "goveldb."
+
+// @description: accumulate the cost of a compaction into the stats of its output level
+
+func (db *Db) addStats(stats *version.CompactionStats) {
+	db.mu.Lock()
+	db.stats[stats.Level].Add(stats)
+	db.mu.Unlock()
+}
+
+// @description: get a property of database
+// @param: the property name
+// @return: the value of property and if the property is known
+
+func (db *Db) GetProperty(name string) (string, bool) {
+	if !strings.HasPrefix(name, kPropertyPrefix) {
+		return "", false
+	}
+	name = strings.TrimPrefix(name, kPropertyPrefix)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(name, "num-files-at-level"):
+		level, err := strconv.Atoi(strings.TrimPrefix(name, "num-files-at-level"))
+		if err != nil || level < 0 || level >= internal.NumLevels {
+			return "", false
+		}
+		return strconv.Itoa(db.current.NumLevelFiles(level)), true
+	case name == "stats":
+		var b strings.Builder
+		b.WriteString("                               Compactions\n")
+		b.WriteString("Level  Files Size(MB) Time(sec) Read(MB) Write(MB)\n")
+		b.WriteString("--------------------------------------------------\n")
+		for level := 0; level < internal.NumLevels; level++ {
+			files := db.current.NumLevelFiles(level)
+			stats := db.stats[level]
+			if files == 0 && stats.Duration == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "%3d %8d %8.0f %9.0f %8.0f %9.0f\n", level, files,
+				float64(db.current.NumLevelBytes(level))/1048576.0,
+				stats.Duration.Seconds(),
+				float64(stats.BytesRead)/1048576.0,
+				float64(stats.BytesWritten)/1048576.0)
+		}
+		return b.String(), true
+	case name == "sstables":
+		return db.current.DebugString(), true
+	case name == "approximate-memory-usage":
+		usage := db.mem.ApproximateMemoryUsage()
+		if db.imm != nil {
+			usage += db.imm.ApproximateMemoryUsage()
+		}
+		return strconv.FormatUint(usage, 10), true
+	case name == "background-errors":
+		return strconv.Itoa(db.numBgErrors), true
+	}
+
+	return "", false
+}
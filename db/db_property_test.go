@@ -0,0 +1,101 @@
+package db
+
+import (
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/opt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_Db_GetProperty(t *testing.T) {
+	dbName := "/db"
+	opts := &opt.Options{Env: env.NewMemEnv()}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+
+	value := make([]byte, 1024)
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+
+	// wait for the background compaction by reopening
+	db.Close()
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	defer db.Close()
+
+	files := 0
+	for level := 0; level < 7; level++ {
+		p, ok := db.GetProperty(fmt.Sprintf("goveldb.num-files-at-level%d", level))
+		n, err := strconv.Atoi(p)
+		if !ok || err != nil {
+			t.Fatal("num files property error", level, p)
+		}
+		files += n
+	}
+	if files == 0 {
+		t.Fatal("no file is found")
+	}
+
+	if p, ok := db.GetProperty("goveldb.stats"); !ok || !strings.Contains(p, "Write(MB)") || strings.Count(p, "\n") < 4 {
+		t.Fatal("stats property error", p)
+	}
+	if p, ok := db.GetProperty("goveldb.sstables"); !ok || !strings.Contains(p, `"key000000"`) {
+		t.Fatal("sstables property error", p)
+	}
+	if p, ok := db.GetProperty("goveldb.approximate-memory-usage"); !ok || p == "0" {
+		t.Fatal("memory usage property error", p)
+	}
+	if p, ok := db.GetProperty("goveldb.background-errors"); !ok || p != "0" {
+		t.Fatal("background errors property error", p)
+	}
+
+	for _, name := range []string{"goveldb.num-files-at-level7", "goveldb.unknown", "leveldb.stats"} {
+		if _, ok := db.GetProperty(name); ok {
+			t.Fatal("unknown property is found", name)
+		}
+	}
+}
+
+func Test_Db_GetPropertyConcurrently(t *testing.T) {
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv()})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+
+	// memtable grows while properties are read, run with -race to check it
+	// large values are allocated in separate blocks of arena, so memory usage is updated by every put
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value := make([]byte, 10<<10)
+		for i := 0; i < 2000; i++ {
+			_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+		}
+	}()
+
+	var last uint64
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		p, ok := db.GetProperty("goveldb.approximate-memory-usage")
+		usage, err := strconv.ParseUint(p, 10, 64)
+		if !ok || err != nil {
+			t.Fatal("memory usage property error", p)
+		}
+		last = usage
+	}
+	if last == 0 {
+		t.Fatal("memory usage is not counted")
+	}
+}
@@ -1,8 +1,23 @@
 package db
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/event"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/logger"
+	"github.com/jo3yzhu/goveldb/opt"
+	"github.com/jo3yzhu/goveldb/statistics"
+	"io"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -23,35 +38,1016 @@ func GetRandomString(length int) []byte {
 }
 
 func Test_Db(t *testing.T) {
-	db := Open("./goveldbtest")
-	db.Put([]byte("123"), []byte("456"))
+	db, err := Open("./goveldbtest", &opt.Options{Env: env.NewMemEnv()})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
 
-	value, err := db.Get([]byte("123"))
-	fmt.Println(string(value))
+	if err := db.Put([]byte("123"), []byte("456")); err != nil {
+		t.Fatal("put fail", err)
+	}
+	if value, err := db.Get([]byte("123")); err != nil || string(value) != "456" {
+		t.Fatal("get fail", err, string(value))
+	}
 
-	db.Delete([]byte("123"))
-	value, err = db.Get([]byte("123"))
-	fmt.Println(err)
+	if err := db.Delete([]byte("123")); err != nil {
+		t.Fatal("delete fail", err)
+	}
+	if _, err := db.Get([]byte("123")); err == nil {
+		t.Fatal("deleted key is found")
+	}
 
-	db.Put([]byte("123"), []byte("789"))
-	value, _ = db.Get([]byte("123"))
-	fmt.Println(string(value))
-	db.Close()
+	if err := db.Put([]byte("123"), []byte("789")); err != nil {
+		t.Fatal("put fail", err)
+	}
+	if value, err := db.Get([]byte("123")); err != nil || string(value) != "789" {
+		t.Fatal("get fail", err, string(value))
+	}
 }
 
 func Test_Db2(t *testing.T) {
-	db := Open("./goveldbtest")
-	db.Put([]byte("123"), []byte("456"))
+	opts := &opt.Options{Env: env.NewMemEnv()}
+	db, err := Open("./goveldbtest", opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	if err := db.Put([]byte("123"), []byte("456")); err != nil {
+		t.Fatal("put fail", err)
+	}
 
+	// the key is compacted into sstables by random writes
 	for i := 0; i < 1000000; i++ {
-		db.Put(GetRandomString(10), GetRandomString(10))
+		if err := db.Put(GetRandomString(10), GetRandomString(10)); err != nil {
+			t.Fatal("put fail", err)
+		}
+	}
+	if value, err := db.Get([]byte("123")); err != nil || string(value) != "456" {
+		t.Fatal("get fail", err, string(value))
+	}
+	db.Close()
+
+	db2, err := Open("./goveldbtest", opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	defer db2.Close()
+	if value, err := db2.Get([]byte("123")); err != nil || string(value) != "456" {
+		t.Fatal("get fail after reopen", err, string(value))
+	}
+}
+
+func Test_Db_Write(t *testing.T) {
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv()})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+
+	var batch WriteBatch
+	batch.Put([]byte("123"), []byte("456"))
+	batch.Put([]byte("124"), []byte("457"))
+	batch.Delete([]byte("123"))
+	if batch.Count() != 3 {
+		t.Fatal("batch count error")
+	}
+	if err := db.Write(&opt.WriteOptions{Sync: true}, &batch); err != nil {
+		t.Fatal("write fail", err)
+	}
+
+	if _, err := db.Get([]byte("123")); err == nil {
+		t.Fatal("deleted key is found")
+	}
+	if value, err := db.Get([]byte("124")); err != nil || string(value) != "457" {
+		t.Fatal("get error")
 	}
-	value, err := db.Get([]byte("123"))
-	fmt.Println("db:", err, string(value))
 	db.Close()
+}
+
+// slowSyncEnv counts syncs of log files, each of which takes a while as it does on disk
 
-	db2 := Open("./goveldbtest")
-	value, err = db2.Get([]byte("123"))
-	fmt.Println("db reopen:", err, string(value))
-	db2.Close()
+type slowSyncEnv struct {
+	env.Env
+	syncs int64
+}
+
+type slowSyncFile struct {
+	env.WritableFile
+	env *slowSyncEnv
+}
+
+func (e *slowSyncEnv) NewWritableFile(name string) (env.WritableFile, error) {
+	file, err := e.Env.NewWritableFile(name)
+	if err != nil || !strings.HasSuffix(name, ".log") {
+		return file, err
+	}
+	return &slowSyncFile{WritableFile: file, env: e}, nil
+}
+
+func (f *slowSyncFile) Sync() error {
+	atomic.AddInt64(&f.env.syncs, 1)
+	time.Sleep(100 * time.Microsecond)
+	return f.WritableFile.Sync()
+}
+
+func Test_Db_GroupCommit(t *testing.T) {
+	dbName := "/db"
+	fs := &slowSyncEnv{Env: env.NewMemEnv()}
+	opts := &opt.Options{Env: fs}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+
+	// synchronous writes from many goroutines are committed in groups
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := []byte(strconv.Itoa(i) + "-" + strconv.Itoa(j))
+				if err := db.Write(&opt.WriteOptions{Sync: true}, batchOf(key, key)); err != nil {
+					t.Error("write fail", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if db.current.LastSequence() != 64*100 {
+		t.Fatal("sequence error", db.current.LastSequence())
+	}
+	db.Close()
+
+	// each group is synced once, so there are far fewer syncs than writes
+	if syncs := atomic.LoadInt64(&fs.syncs); syncs >= 64*100/2 {
+		t.Fatal("writes are not grouped", syncs)
+	}
+
+	// all of them can be recovered from log
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < 100; j++ {
+			key := []byte(strconv.Itoa(i) + "-" + strconv.Itoa(j))
+			if value, err := db.Get(key); err != nil || string(value) != string(key) {
+				t.Fatal("get error", string(key))
+			}
+		}
+	}
+	if db.current.LastSequence() != 64*100 {
+		t.Fatal("sequence error after recovery", db.current.LastSequence())
+	}
+	db.Close()
+}
+
+func batchOf(key, value []byte) *WriteBatch {
+	var batch WriteBatch
+	batch.Put(key, value)
+	return &batch
+}
+
+func Test_Db_GetApproximateSizes(t *testing.T) {
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv()})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+
+	// 8MB of data, the first prefixes are compacted into sstables
+	value := make([]byte, 1024)
+	for i := 0; i < 8000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("p%d:%04d", i/2000, i)), value)
+	}
+
+	sizes := db.GetApproximateSizes([]Range{
+		{Start: []byte("p0:"), Limit: []byte("p1:")},
+		{Start: []byte("p0:"), Limit: []byte("p0:0500")},
+		{Start: []byte("a"), Limit: []byte("b")},
+		{Start: []byte("p1:"), Limit: []byte("p0:")},
+		{Start: nil, Limit: nil},
+		{Start: []byte("p0:"), Limit: nil},
+		{Start: []byte("q"), Limit: nil},
+	})
+	if sizes[0] < 1800<<10 || sizes[0] > 2200<<10 {
+		t.Fatal("size of range error", sizes[0])
+	}
+	if sizes[1] < 400<<10 || sizes[1] > 600<<10 {
+		t.Fatal("size of partial file error", sizes[1])
+	}
+	if sizes[2] != 0 || sizes[3] != 0 {
+		t.Fatal("size of empty range error", sizes[2], sizes[3])
+	}
+
+	// nil limit is after all keys, so the range covers all of the files after start
+	if sizes[4] < sizes[0] || sizes[4] != sizes[5] || sizes[6] != 0 {
+		t.Fatal("size of unbounded range error", sizes[4], sizes[5], sizes[6])
+	}
+}
+
+func Test_Db_InfoLog(t *testing.T) {
+	// the default logger writes to LOG in database directory
+	fs := env.NewMemEnv()
+	dbName := "/db"
+	db, err := Open(dbName, &opt.Options{Env: fs})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	db.Close()
+
+	p, err := env.ReadFile(fs, internal.InfoLogFileName(dbName))
+	if err != nil || !strings.Contains(string(p), "INFO level0 table written") {
+		t.Fatal("flush is not logged to LOG", err)
+	}
+
+	// no LOG is created if a logger is provided
+	var buf bytes.Buffer
+	dbName = "/db2"
+	db, err = Open(dbName, &opt.Options{Env: fs, InfoLog: logger.New(&buf, logger.Info)})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	db.Close()
+
+	if fs.FileExists(internal.InfoLogFileName(dbName)) {
+		t.Fatal("LOG is created", err)
+	}
+	if !strings.Contains(buf.String(), "INFO level0 table written") {
+		t.Fatal("flush is not logged to provided logger")
+	}
+}
+
+type recordingListener struct {
+	event.BaseListener
+	mu            sync.Mutex
+	flushes       int
+	stalls        []event.WriteStallInfo
+	deletedTables []uint64
+}
+
+func (l *recordingListener) OnFlushEnd(info event.FlushInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if info.Err == nil {
+		l.flushes++
+	}
+}
+
+func (l *recordingListener) OnTableFileDeleted(info event.TableFileInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if info.Err == nil {
+		l.deletedTables = append(l.deletedTables, info.FileNumber)
+	}
+}
+
+func (l *recordingListener) OnWriteStallChange(info event.WriteStallInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stalls = append(l.stalls, info)
+}
+
+func Test_Db_EventListeners(t *testing.T) {
+	listener := &recordingListener{}
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv(), EventListeners: []event.Listener{listener}})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+
+	value := make([]byte, 1024)
+	for i := 0; i < 20000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	db.Close()
+
+	if listener.flushes == 0 {
+		t.Fatal("flush is not notified")
+	}
+
+	// stalls always end up with normal condition
+	previous := event.WriteStallNormal
+	for _, info := range listener.stalls {
+		if info.Previous != previous || info.Current == previous {
+			t.Fatal("write stall change error", listener.stalls)
+		}
+		previous = info.Current
+	}
+	if previous != event.WriteStallNormal {
+		t.Fatal("write stall doesn't end", listener.stalls)
+	}
+}
+
+func Test_Db_Statistics(t *testing.T) {
+	stats := statistics.New()
+	dbName := "/db"
+	opts := &opt.Options{Env: env.NewMemEnv(), Statistics: stats}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	db.Close()
+
+	// the flushed keys are read from sstables after reopening
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	defer db.Close()
+	if _, err := db.Get([]byte("key000000")); err != nil {
+		t.Fatal("get fail", err)
+	}
+	if _, err := db.Get([]byte("key004999")); err != nil {
+		t.Fatal("get fail", err)
+	}
+	if err := db.Delete([]byte("key004999")); err != nil {
+		t.Fatal("delete fail", err)
+	}
+
+	if stats.GetTickerCount(statistics.KeysWritten) != 5001 || stats.GetHistogram(statistics.DbPut).Count != 5000 ||
+		stats.GetHistogram(statistics.DbDelete).Count != 1 || stats.GetHistogram(statistics.DbWrite).Count != 5001 {
+		t.Fatal("writes are not recorded")
+	}
+	if stats.GetTickerCount(statistics.KeysRead) != 2 || stats.GetTickerCount(statistics.BytesRead) != 2048 {
+		t.Fatal("reads are not recorded")
+	}
+	if stats.GetTickerCount(statistics.MemtableHit) != 1 || stats.GetTickerCount(statistics.MemtableMiss) != 1 {
+		t.Fatal("memtable hits are not recorded")
+	}
+	sstableHits := stats.GetTickerCount(statistics.GetHitL0) + stats.GetTickerCount(statistics.GetHitL1) + stats.GetTickerCount(statistics.GetHitL2AndUp)
+	if sstableHits != 1 || stats.GetTickerCount(statistics.BlockRead) == 0 || stats.GetTickerCount(statistics.FlushWriteBytes) == 0 {
+		t.Fatal("sstable reads are not recorded")
+	}
+}
+
+func Test_Db_PerfContext(t *testing.T) {
+	dbName := "/db"
+	opts := &opt.Options{Env: env.NewMemEnv()}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	db.Close()
+
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	defer db.Close()
+	_ = db.Put([]byte("new"), value)
+
+	// the key in memtable
+	var perf statistics.PerfContext
+	readOpts := &opt.ReadOptions{PerfContext: &perf}
+	if _, err := db.GetWithOptions(readOpts, []byte("new")); err != nil {
+		t.Fatal("get fail", err)
+	}
+	if perf.MemtablesConsulted != 1 || perf.Level0FilesConsulted+perf.DeeperFilesConsulted != 0 || perf.BlocksRead != 0 || perf.GetTime == 0 {
+		t.Fatal("perf context of memtable hit error", perf.String())
+	}
+
+	// the key in sstable
+	perf.Reset()
+	if _, err := db.GetWithOptions(readOpts, []byte("key000000")); err != nil {
+		t.Fatal("get fail", err)
+	}
+	if perf.Level0FilesConsulted+perf.DeeperFilesConsulted != 1 || perf.BlocksRead != 1 || perf.BytesDecoded == 0 || perf.TableGetTime == 0 {
+		t.Fatal("perf context of sstable hit error", perf.String())
+	}
+
+	// the perf context attached to the context is used by the iterator as by GetContext
+	var ctxPerf statistics.PerfContext
+	ctx := statistics.WithPerfContext(context.Background(), &ctxPerf)
+	if _, err := db.GetContext(ctx, []byte("key000001")); err != nil || ctxPerf.BlocksRead != 1 {
+		t.Fatal("perf context of get context error", err, ctxPerf.String())
+	}
+	ctxPerf.Reset()
+	iter := db.NewIteratorContext(ctx, &opt.ReadOptions{LowerBound: []byte("key")})
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+	}
+	if iter.Error() != nil || ctxPerf.BlocksRead == 0 || ctxPerf.BlockReadBytes == 0 {
+		t.Fatal("perf context of iterator context error", iter.Error(), ctxPerf.String())
+	}
+	iter.Close()
+}
+
+func Test_Db_Context(t *testing.T) {
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv()})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+	_ = db.Put([]byte("key"), []byte("value"))
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.PutContext(cancelled, []byte("key"), []byte("new")); err != context.Canceled {
+		t.Fatal("put with cancelled context should fail", err)
+	}
+	if _, err := db.GetContext(cancelled, []byte("key")); err != context.Canceled {
+		t.Fatal("get with cancelled context should fail", err)
+	}
+	if value, err := db.GetContext(context.Background(), []byte("key")); err != nil || string(value) != "value" {
+		t.Fatal("cancelled put is written", err)
+	}
+
+	iter := db.NewIteratorContext(cancelled, nil)
+	iter.SeekToFirst()
+	if iter.Valid() || iter.Error() != context.Canceled {
+		t.Fatal("iterator with cancelled context should fail", iter.Error())
+	}
+	iter.Close()
+
+	// a writer stuck behind a leader gives up at the deadline and leaves the queue
+	leader := &writer{cond: sync.NewCond(&db.mu)}
+	db.mu.Lock()
+	db.writers = append(db.writers, leader)
+	db.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := db.DeleteContext(ctx, []byte("key")); err != context.DeadlineExceeded {
+		t.Fatal("delete should time out", err)
+	}
+	db.mu.Lock()
+	if len(db.writers) != 1 || db.writers[0] != leader {
+		t.Fatal("writer is not removed from queue")
+	}
+	db.writers = nil
+	db.mu.Unlock()
+}
+
+func Test_Db_CompactRange(t *testing.T) {
+	db, err := Open("/db", &opt.Options{Env: env.NewMemEnv()})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+
+	value := make([]byte, 1024)
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	for i := 0; i < 5000; i += 2 {
+		_ = db.Delete([]byte(fmt.Sprintf("key%06d", i)))
+	}
+
+	// a cancelled compaction leaves database untouched
+	db.mu.Lock()
+	before := db.current.DebugString()
+	db.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.CompactRangeContext(ctx, nil, nil); err != context.Canceled {
+		t.Fatal("compaction should be cancelled", err)
+	}
+	db.mu.Lock()
+	after := db.current.DebugString()
+	db.mu.Unlock()
+	if before != after {
+		t.Fatal("cancelled compaction modifies version")
+	}
+
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatal("compact range fail", err)
+	}
+	if n, _ := db.GetProperty("goveldb.num-files-at-level0"); n != "0" {
+		t.Fatal("level0 is not compacted", n)
+	}
+	for i := 0; i < 5000; i++ {
+		_, err := db.Get([]byte(fmt.Sprintf("key%06d", i)))
+		if i%2 == 0 && err == nil {
+			t.Fatal("deleted key is resurrected", i)
+		} else if i%2 == 1 && err != nil {
+			t.Fatal("get fail", i, err)
+		}
+	}
+}
+
+func Test_Db_CompactRangeConcurrently(t *testing.T) {
+	dbName := "/db"
+	opts := &opt.Options{Env: env.NewMemEnv()}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+
+	// memtable is flushed by manual compactions while leaders of writers are writing to it
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := make([]byte, 256)
+			for j := 0; j < 5000; j++ {
+				if err := db.Put([]byte(fmt.Sprintf("key%d-%06d", i, j)), value); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	writing := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(writing)
+	}()
+
+	compactions := 0
+	for running := true; running; compactions++ {
+		select {
+		case <-writing:
+			running = false
+		default:
+		}
+		if err := db.CompactRange(nil, nil); err != nil {
+			t.Fatal("compact range fail", err)
+		}
+	}
+	close(errs)
+	for err := range errs {
+		t.Fatal("put fail", err)
+	}
+	if compactions < 2 {
+		t.Fatal("writes are not compacted concurrently", compactions)
+	}
+	db.Close()
+
+	// all of the acknowledged writes survive
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	defer db.Close()
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 5000; j++ {
+			if _, err := db.Get([]byte(fmt.Sprintf("key%d-%06d", i, j))); err != nil {
+				t.Fatal("acknowledged write is lost", i, j, err)
+			}
+		}
+	}
+}
+
+func Test_Db_CompactRangeFlushError(t *testing.T) {
+	fs := env.NewFaultInjectionEnv(env.NewMemEnv())
+	db, err := Open("/db", &opt.Options{Env: fs})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+	_ = db.Put([]byte("key"), []byte("value"))
+
+	// the new log is created, and the table of flush fails to be created, so imm is never compacted
+	fs.FailAfter(env.FaultOpCreate, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- db.CompactRange(nil, nil)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("compact range succeeds after flush fails")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("compact range hangs after flush fails")
+	}
+}
+
+// testdata/baseline is written by goveldb before the layout of internal keys, tables and manifest was changed,
+// by calling version.WriteLevel0Table with memtables and version.Save at the baseline commit:
+// key000-key199 are in a table of level 2, key000-key049 are deleted and key100-key159 are updated to "new" in level 1
+
+func checkBaselineFixture(t *testing.T, db *Db) {
+	for i := 0; i < 200; i++ {
+		value, err := db.Get([]byte(fmt.Sprintf("key%03d", i)))
+		switch {
+		case i < 50:
+			if err == nil {
+				t.Fatal("deleted key is found", i)
+			}
+		case i >= 100 && i < 160:
+			if err != nil || string(value) != fmt.Sprintf("new%03d", i) {
+				t.Fatal("get updated key error", i, err)
+			}
+		default:
+			if err != nil || string(value) != fmt.Sprintf("value%03d", i) {
+				t.Fatal("get error", i, err)
+			}
+		}
+	}
+}
+
+func Test_Db_Baseline(t *testing.T) {
+	fs := env.NewMemEnv()
+	opts := &opt.Options{Env: fs}
+	copyDirToEnv(t, "testdata/baseline", fs, "/baseline")
+
+	db, err := Open("/baseline", opts)
+	if err != nil {
+		t.Fatal("open baseline database fail", err)
+	}
+	checkBaselineFixture(t, db)
+
+	// tables of legacy format are rewritten by compaction
+	_ = db.Put([]byte("key200"), []byte("value200"))
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatal("compact range fail", err)
+	}
+	db.Close()
+	for number := uint64(1); number <= 3; number++ {
+		if fs.FileExists(internal.TableFileName("/baseline", number)) {
+			t.Fatal("table of legacy format is left after compaction", number)
+		}
+	}
+
+	db, err = Open("/baseline", opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	defer db.Close()
+	checkBaselineFixture(t, db)
+	if v, err := db.Get([]byte("key200")); err != nil || string(v) != "value200" {
+		t.Fatal("get new key fail", err)
+	}
+}
+
+// countingEnv counts the files and locks which are opened but not closed
+
+type countingEnv struct {
+	env.Env
+	mu   sync.Mutex
+	open map[string]int
+}
+
+type countingCloser struct {
+	io.Closer
+	e    *countingEnv
+	name string
+}
+
+func (c *countingCloser) Close() error {
+	c.e.mu.Lock()
+	c.e.open[c.name]--
+	c.e.mu.Unlock()
+	return c.Closer.Close()
+}
+
+type countingSequentialFile struct {
+	env.SequentialFile
+	countingCloser
+}
+
+func (f *countingSequentialFile) Close() error {
+	return f.countingCloser.Close()
+}
+
+type countingRandomAccessFile struct {
+	env.RandomAccessFile
+	countingCloser
+}
+
+func (f *countingRandomAccessFile) Close() error {
+	return f.countingCloser.Close()
+}
+
+type countingWritableFile struct {
+	env.WritableFile
+	countingCloser
+}
+
+func (f *countingWritableFile) Close() error {
+	return f.countingCloser.Close()
+}
+
+func newCountingEnv(base env.Env) *countingEnv {
+	return &countingEnv{Env: base, open: make(map[string]int)}
+}
+
+func (e *countingEnv) opened(c io.Closer, name string) countingCloser {
+	e.mu.Lock()
+	e.open[name]++
+	e.mu.Unlock()
+	return countingCloser{Closer: c, e: e, name: name}
+}
+
+func (e *countingEnv) NewSequentialFile(name string) (env.SequentialFile, error) {
+	f, err := e.Env.NewSequentialFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingSequentialFile{SequentialFile: f, countingCloser: e.opened(f, name)}, nil
+}
+
+func (e *countingEnv) NewRandomAccessFile(name string) (env.RandomAccessFile, error) {
+	f, err := e.Env.NewRandomAccessFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingRandomAccessFile{RandomAccessFile: f, countingCloser: e.opened(f, name)}, nil
+}
+
+func (e *countingEnv) NewWritableFile(name string) (env.WritableFile, error) {
+	f, err := e.Env.NewWritableFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingWritableFile{WritableFile: f, countingCloser: e.opened(f, name)}, nil
+}
+
+func (e *countingEnv) LockFile(name string) (io.Closer, error) {
+	l, err := e.Env.LockFile(name)
+	if err != nil {
+		return nil, err
+	}
+	c := e.opened(l, name)
+	return &c, nil
+}
+
+// @description: the names of files which are opened but not closed
+
+func (e *countingEnv) openFiles() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var names []string
+	for name, n := range e.open {
+		if n != 0 {
+			names = append(names, fmt.Sprintf("%s:%d", name, n))
+		}
+	}
+	return names
+}
+
+func Test_Db_CloseReleasesFiles(t *testing.T) {
+	fs := newCountingEnv(env.NewMemEnv())
+	opts := &opt.Options{Env: fs}
+	for round := 0; round < 2; round++ {
+		db, err := Open("/db", opts)
+		if err != nil {
+			t.Fatal("open fail", err)
+		}
+
+		// tables are opened by reads, iterators and compactions
+		value := make([]byte, 1024)
+		for i := 0; i < 5000; i++ {
+			_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+		}
+		if err := db.CompactRange(nil, nil); err != nil {
+			t.Fatal("compact range fail", err)
+		}
+		for i := 0; i < 5000; i += 100 {
+			if _, err := db.Get([]byte(fmt.Sprintf("key%06d", i))); err != nil {
+				t.Fatal("get fail", i, err)
+			}
+		}
+		iter := db.NewIterator(nil)
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		}
+		iter.Close()
+		db.Close()
+
+		if names := fs.openFiles(); len(names) > 0 {
+			t.Fatal("files are left open after close", round, names)
+		}
+	}
+}
+
+func Test_Db_MemEnv(t *testing.T) {
+	t.Parallel()
+
+	// the name is a directory on disk, which is checked to be untouched by the in-memory env
+	dbName := filepath.Join(t.TempDir(), "db")
+	opts := &opt.Options{Env: env.NewMemEnv()}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	if _, err := Open(dbName, opts); err != internal.ErrLocked {
+		t.Fatal("database is opened twice", err)
+	}
+
+	// enough data to be flushed and compacted
+	value := make([]byte, 1024)
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	db.Close()
+
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	defer db.Close()
+	for i := 0; i < 5000; i++ {
+		if v, err := db.Get([]byte(fmt.Sprintf("key%06d", i))); err != nil || !bytes.Equal(v, value) {
+			t.Fatal("get error after reopen", i, err)
+		}
+	}
+	var numFiles int
+	for level := 0; level < internal.NumLevels; level++ {
+		numFiles += db.current.NumLevelFiles(level)
+	}
+	if numFiles == 0 {
+		t.Fatal("no sstable is written")
+	}
+
+	// nothing is written to disk
+	if _, err := os.Stat(dbName); !os.IsNotExist(err) {
+		t.Fatal("database is created on disk", err)
+	}
+}
+
+func Test_Db_ObsoleteFiles(t *testing.T) {
+	fs := env.NewMemEnv()
+	dbName := "/obsolete"
+	opts := &opt.Options{Env: fs}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	db.Close()
+	live := db.current.LiveFiles()
+	if len(live) == 0 {
+		t.Fatal("no sstable is written")
+	}
+
+	// only the current manifest and logs not compacted are kept
+	countFiles := func() map[internal.FileType]int {
+		files, err := (&Db{name: dbName, env: fs}).listFiles()
+		if err != nil {
+			t.Fatal("list files fail", err)
+		}
+		count := make(map[internal.FileType]int)
+		for _, f := range files {
+			count[f.fileType]++
+		}
+		return count
+	}
+	if count := countFiles(); count[internal.DescriptorFile] != 1 || count[internal.TempFile] != 0 || count[internal.LogFile] > 2 {
+		t.Fatal("obsolete files are not removed", count)
+	}
+
+	// orphans left by crash are removed on open, and files not named by database are kept
+	_ = env.WriteFile(fs, internal.TableFileName(dbName, 999999), []byte("orphan"), true)
+	_ = env.WriteFile(fs, internal.TempFileName(dbName, 999998), []byte("orphan"), true)
+	_ = env.WriteFile(fs, filepath.Join(dbName, "notes.txt"), []byte("notes"), true)
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	db.Close()
+	if fs.FileExists(internal.TableFileName(dbName, 999999)) || fs.FileExists(internal.TempFileName(dbName, 999998)) {
+		t.Fatal("orphan files are not removed")
+	}
+	if !fs.FileExists(filepath.Join(dbName, "notes.txt")) {
+		t.Fatal("unknown file is removed")
+	}
+	if count := countFiles(); count[internal.TableFile] != len(live) {
+		t.Fatal("live tables are removed", count, len(live))
+	}
+
+	// a table in manifest is missing
+	for number := range live {
+		_ = fs.RemoveFile(internal.TableFileName(dbName, number))
+		break
+	}
+	if _, err := Open(dbName, opts); err != internal.ErrCorruption {
+		t.Fatal("missing table is not detected", err)
+	}
+}
+
+func Test_Db_RemoveCompactedTables(t *testing.T) {
+	fs := env.NewMemEnv()
+	dbName := "/compacted"
+	listener := &recordingListener{}
+	db, err := Open(dbName, &opt.Options{Env: fs, EventListeners: []event.Listener{listener}})
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	defer db.Close()
+
+	countTables := func() int {
+		files, err := db.listFiles()
+		if err != nil {
+			t.Fatal("list files fail", err)
+		}
+		n := 0
+		for _, f := range files {
+			if f.fileType == internal.TableFile {
+				n++
+			}
+		}
+		return n
+	}
+	liveTables := func() int {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return len(db.current.LiveFiles())
+	}
+
+	value := make([]byte, 1024)
+	for i := 0; i < 10000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatal("compact range fail", err)
+	}
+	if countTables() != liveTables() || len(listener.deletedTables) == 0 {
+		t.Fatal("compacted tables are not removed", countTables(), liveTables(), len(listener.deletedTables))
+	}
+
+	// the tables read by iterator are kept until it's closed, even if they are compacted
+	it := db.NewIterator(nil)
+	for i := 0; i < 10000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatal("compact range fail", err)
+	}
+	if countTables() <= liveTables() {
+		t.Fatal("tables read by iterator are removed", countTables(), liveTables())
+	}
+	n := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		n++
+	}
+	if it.Error() != nil || n != 10000 {
+		t.Fatal("iterate fail", n, it.Error())
+	}
+	deleted := len(listener.deletedTables)
+	it.Close()
+	if countTables() != liveTables() || len(listener.deletedTables) <= deleted {
+		t.Fatal("tables are not removed after iterator is closed", countTables(), liveTables())
+	}
+}
+
+func Test_Db_ParanoidChecks(t *testing.T) {
+	fs := env.NewMemEnv()
+	dbName := "/paranoid"
+	opts := &opt.Options{Env: fs, ParanoidChecks: true}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 5000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), value)
+	}
+	db.Close()
+	var number uint64
+	for number = range db.current.LiveFiles() {
+		break
+	}
+
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	db.Close()
+
+	// a table is truncated, which is found only in paranoid mode
+	fileName := internal.TableFileName(dbName, number)
+	p, _ := env.ReadFile(fs, fileName)
+	_ = env.WriteFile(fs, fileName, p[:len(p)-1], true)
+	if _, err := Open(dbName, opts); err != internal.ErrCorruption {
+		t.Fatal("truncated table is not detected", err)
+	}
+	db, err = Open(dbName, &opt.Options{Env: fs})
+	if err != nil {
+		t.Fatal("open without paranoid checks fail", err)
+	}
+	db.Close()
+
+	// a bit flip in a block of native table is detected by its crc once it's read
+	p[10] ^= 1
+	_ = env.WriteFile(fs, fileName, p, true)
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("reopen fail", err)
+	}
+	defer db.Close()
+	if err := db.CompactRange(nil, nil); err != internal.ErrCorruption {
+		t.Fatal("corrupted block is not detected", err)
+	}
 }
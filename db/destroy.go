@@ -0,0 +1,46 @@
+package db
+
+import (
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
+	"os"
+	"path/filepath"
+)
+
+// @description: remove the files of a database, and the directory if nothing else is left in it
+// @param: the database name and options
+// @return: error if the database is open or any file of it can't be removed, nil if the directory doesn't exist
+// @note: only the files named by the database are removed, others such as lost/ of repairing are kept
+
+func Destroy(dbName string, opts *opt.Options) error {
+	fs := opts.GetEnv()
+	names, err := fs.GetChildren(dbName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lock, err := fs.LockFile(internal.LockFileName(dbName))
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, name := range names {
+		_, fileType, ok := internal.ParseFileName(name)
+		if !ok || fileType == internal.DBLockFile {
+			continue
+		}
+		if err := fs.RemoveFile(filepath.Join(dbName, name)); err != nil && result == nil {
+			result = err
+		}
+	}
+
+	// the lock file is removed after it's released, and the directory fails to be removed if anything is left
+	_ = lock.Close()
+	_ = fs.RemoveFile(internal.LockFileName(dbName))
+	_ = fs.RemoveDir(dbName)
+	return result
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Destroy(t *testing.T) {
+	// the default env is also run on purpose, Destroy must remove the directory on disk
+	for _, fs := range []env.Env{env.NewMemEnv(), env.Default()} {
+		opts := &opt.Options{Env: fs}
+		dbName := filepath.Join(t.TempDir(), "destroy")
+		db, err := Open(dbName, opts)
+		if err != nil {
+			t.Fatal("open fail", err)
+		}
+		for i := 0; i < 100; i++ {
+			_ = db.Put([]byte{byte(i)}, []byte("value"))
+		}
+		if err := Destroy(dbName, opts); err != internal.ErrLocked {
+			t.Fatal("open database is destroyed", err)
+		}
+		if v, err := db.Get([]byte{1}); err != nil || string(v) != "value" {
+			t.Fatal("get fail after destroy is refused", err)
+		}
+		db.Close()
+
+		// files not named by database are kept along with the directory
+		_ = env.WriteFile(fs, filepath.Join(dbName, "notes.txt"), []byte("notes"), false)
+		if err := Destroy(dbName, opts); err != nil {
+			t.Fatal("destroy fail", err)
+		}
+		names, err := fs.GetChildren(dbName)
+		if err != nil || len(names) != 1 || names[0] != "notes.txt" {
+			t.Fatal("files left after destroy error", names, err)
+		}
+
+		_ = fs.RemoveFile(filepath.Join(dbName, "notes.txt"))
+		if err := Destroy(dbName, opts); err != nil {
+			t.Fatal("destroy fail", err)
+		}
+		if fs.FileExists(dbName) {
+			t.Fatal("empty directory is not removed")
+		}
+		if err := Destroy(dbName, opts); err != nil {
+			t.Fatal("destroy missing database fail", err)
+		}
+	}
+}
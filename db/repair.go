@@ -0,0 +1,208 @@
+// Repair rebuilds a database whose CURRENT file or manifest is lost or corrupted from the files which survive:
+//		log files are replayed into new tables, and then they are moved into lost/
+//		every table is scanned for its key range and max sequence, the unreadable ones are moved into lost/
+//		a new manifest is written with all tables in level0, and the old manifests are moved into lost/
+//		level0 is compacted once at last, since tables from deeper levels may have greater numbers than newer ones,
+//		while files in level0 are searched from the greatest number to the least
+// Data in unreadable tables and records is lost, and deleted keys may come back if their deletions are lost
+
+package db
+
+import (
+	"context"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/logger"
+	"github.com/jo3yzhu/goveldb/memtable"
+	"github.com/jo3yzhu/goveldb/opt"
+	"github.com/jo3yzhu/goveldb/sstable"
+	"github.com/jo3yzhu/goveldb/version"
+	"path/filepath"
+)
+
+const kLostDirName = "lost"
+
+// @description: rebuild a database from the log files and tables in its directory
+// @param: the database name and options, which must be the ones the database is opened with
+// @return: error if the database is open or the new manifest can't be written
+
+func Repair(dbName string, opts *opt.Options) error {
+	fs := opts.GetEnv()
+	lock, err := fs.LockFile(internal.LockFileName(dbName))
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	// the database is not opened, it only provides log replaying and version saving for repairing
+	db := &Db{
+		name:    dbName,
+		opts:    opts,
+		env:     fs,
+		current: version.New(dbName, opts),
+	}
+	infoLog := opts.GetInfoLog()
+
+	files, err := db.listFiles()
+	if err != nil {
+		return err
+	}
+
+	var logs, tables []uint64
+	var manifests []string
+	for _, f := range files {
+		switch f.fileType {
+		case internal.DescriptorFile:
+			manifests = append(manifests, f.name)
+			db.current.MarkFileNumberUsed(f.number)
+		case internal.LogFile:
+			logs = append(logs, f.number)
+			db.current.MarkFileNumberUsed(f.number)
+		case internal.TableFile:
+			db.current.MarkFileNumberUsed(f.number)
+
+			// tables of legacy leveldb are named .sst, they are renamed so that they can be found by table cache
+			fileName := internal.TableFileName(dbName, f.number)
+			if f.name != filepath.Base(fileName) {
+				if err := fs.RenameFile(filepath.Join(dbName, f.name), fileName); err != nil {
+					infoLog.Log(logger.Warn, "repair: rename table fail", "file", f.name, "error", err)
+					continue
+				}
+			}
+			tables = append(tables, f.number)
+		}
+	}
+
+	// 1. replay log files into new tables
+	for _, number := range logs {
+		table, err := db.convertLogToTable(number)
+		if err != nil {
+			infoLog.Log(logger.Warn, "repair: log dropped", "file", number, "error", err)
+			continue
+		}
+		if table != 0 {
+			tables = append(tables, table)
+		}
+	}
+	db.current.SetLogNumber(db.current.NewFileNumber()) // the replayed logs are never replayed again by Open
+
+	// 2. recover key ranges and max sequence of tables, the unreadable ones are moved away
+	for _, number := range tables {
+		if err := db.scanTable(number); err != nil {
+			infoLog.Log(logger.Warn, "repair: table lost", "file", number, "error", err)
+			db.archiveFile(filepath.Base(internal.TableFileName(dbName, number)))
+		}
+	}
+
+	// 3. write the new manifest and switch to it, the old files are moved away after that
+	if _, err := db.saveVersion(db.current); err != nil {
+		return err
+	}
+	for _, name := range manifests {
+		db.archiveFile(name)
+	}
+	for _, number := range logs {
+		db.archiveFile(filepath.Base(internal.LogFileName(dbName, number)))
+	}
+	infoLog.Log(logger.Info, "repair: database rebuilt", "logs", logs, "tables", db.current.NumLevelFiles(0),
+		"sequence", db.current.LastSequence())
+
+	// 4. merge level0, so that the newer entry of a key is found first no matter which table it's in
+	stats, err := db.current.CompactRange(context.Background(), 0, nil, nil)
+	if err == nil && stats != nil {
+		_, err = db.saveVersion(db.current)
+	}
+	return err
+}
+
+// @description: replay a log file and write the entries into a new table
+// @param: the log file number
+// @return: the table file number, 0 if the log is empty
+
+func (db *Db) convertLogToTable(number uint64) (uint64, error) {
+	db.mem = memtable.New()
+	if err := db.recoverLogFile(number); err != nil {
+		return 0, err
+	}
+
+	iter := db.mem.NewIterator()
+	defer iter.Close()
+	iter.SeekToFirst()
+	if !iter.Valid() {
+		return 0, nil
+	}
+
+	table := db.current.NewFileNumber()
+	builder, err := sstable.NewTableBuilder(internal.TableFileName(db.name, table), db.opts)
+	if err != nil {
+		return 0, err
+	}
+	for ; iter.Valid(); iter.Next() {
+		builder.Add(iter.InternalKey())
+	}
+	if err := builder.Finish(); err != nil {
+		_ = db.env.RemoveFile(internal.TableFileName(db.name, table))
+		return 0, err
+	}
+	return table, nil
+}
+
+// @description: read all entries of a table and add it to level0 of version with its key range
+// @param: the table file number
+// @return: error if the table can't be read or it's empty
+
+func (db *Db) scanTable(number uint64) error {
+	fileName := internal.TableFileName(db.name, number)
+	size, err := db.env.GetFileSize(fileName)
+	if err != nil {
+		return err
+	}
+	table, err := sstable.Open(fileName, db.opts)
+	if err != nil {
+		return err
+	}
+	defer table.Close()
+
+	iter := table.NewIterator(nil)
+	defer iter.Close()
+
+	var smallest, largest *internal.InternalKey
+	var largestKey []byte // keys of iterator refer to the block, which is released when moving to the next one
+	maxSequence := uint64(0)
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		key := iter.InternalKey()
+		if smallest == nil {
+			smallest = internal.NewInternalKey(key.Seq, key.Type, key.UserKey, nil)
+			largest = &internal.InternalKey{}
+		}
+		largestKey = append(largestKey[:0], key.UserKey...)
+		largest.Seq, largest.Type = key.Seq, key.Type
+		if key.Seq > maxSequence {
+			maxSequence = key.Seq
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if smallest == nil {
+		return internal.ErrCorruption
+	}
+	largest.UserKey = largestKey
+
+	db.current.AddFile(0, number, uint64(size), smallest, largest)
+	if maxSequence > db.current.LastSequence() {
+		db.current.SetLastSequence(maxSequence)
+	}
+	return nil
+}
+
+// @description: move a file of database into lost/, it's kept there for manual recovery
+// @param: the file name in database directory
+
+func (db *Db) archiveFile(name string) {
+	lost := filepath.Join(db.name, kLostDirName)
+	err := db.env.CreateDir(lost)
+	if err == nil {
+		err = db.env.RenameFile(filepath.Join(db.name, name), filepath.Join(lost, name))
+	}
+	db.opts.GetInfoLog().Log(logger.Info, "repair: archive file", "file", name, "error", err)
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Repair(t *testing.T) {
+	for _, compatible := range []bool{false, true} {
+		fs := env.NewMemEnv()
+		opts := &opt.Options{Env: fs, LevelDBCompatible: compatible}
+		dbName := "/repair"
+		db, err := Open(dbName, opts)
+		if err != nil {
+			t.Fatal("open fail", err)
+		}
+		if err := Repair(dbName, opts); err != internal.ErrLocked {
+			t.Fatal("open database is repaired", err)
+		}
+
+		// older values are compacted into deeper levels, the newer ones and deletions are in level0 and log
+		value := make([]byte, 1024)
+		for round := 0; round < 3; round++ {
+			for i := 0; i < 5000; i++ {
+				_ = db.Put([]byte(fmt.Sprintf("key%06d", i)), append(value, byte(round)))
+			}
+		}
+		for i := 0; i < 5000; i += 2 {
+			_ = db.Delete([]byte(fmt.Sprintf("key%06d", i)))
+		}
+		db.Close()
+
+		// manifest is lost, and a broken table is left
+		_ = fs.RemoveFile(internal.CurrentFileName(dbName))
+		_ = env.WriteFile(fs, internal.TableFileName(dbName, 999999), []byte("broken"), true)
+		if err := Repair(dbName, opts); err != nil {
+			t.Fatal("repair fail", err)
+		}
+
+		names, _ := fs.GetChildren(filepath.Join(dbName, kLostDirName))
+		lost := strings.Join(names, " ")
+		if !strings.Contains(lost, "999999.ldb") || !strings.Contains(lost, "MANIFEST-") || !strings.Contains(lost, ".log") {
+			t.Fatal("broken table, old manifests and logs are not moved into lost", lost)
+		}
+
+		db, err = Open(dbName, opts)
+		if err != nil {
+			t.Fatal("open repaired database fail", err)
+		}
+		for i := 0; i < 5000; i++ {
+			v, err := db.Get([]byte(fmt.Sprintf("key%06d", i)))
+			if i%2 == 0 && err == nil {
+				t.Fatal("deleted key is found", i)
+			} else if i%2 == 1 && (err != nil || !bytes.Equal(v, append(value, 2))) {
+				t.Fatal("get newest value fail", i, err)
+			}
+		}
+
+		// new writes don't overwrite the repaired files
+		_ = db.Put([]byte("new"), []byte("value"))
+		db.Close()
+		db, err = Open(dbName, opts)
+		if err != nil {
+			t.Fatal("reopen fail", err)
+		}
+		if v, err := db.Get([]byte("new")); err != nil || string(v) != "value" {
+			t.Fatal("get new key fail", err)
+		}
+		if v, err := db.Get([]byte("key000001")); err != nil || !bytes.Equal(v, append(value, 2)) {
+			t.Fatal("get repaired key fail", err)
+		}
+		db.Close()
+	}
+}
+
+func Test_Repair_OpenWithoutManifest(t *testing.T) {
+	fs := env.NewMemEnv()
+	opts := &opt.Options{Env: fs}
+	dbName := "/repair"
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open fail", err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 6000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("old%06d", i)), value)
+	}
+
+	// compaction outputs are numbered after the log, so they are the first to be overwritten
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatal("compact range fail", err)
+	}
+	db.Close()
+
+	// the database is opened without manifest, the tables left are kept for Repair and must not be overwritten
+	_ = fs.RemoveFile(internal.CurrentFileName(dbName))
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open without manifest fail", err)
+	}
+	for i := 0; i < 6000; i++ {
+		_ = db.Put([]byte(fmt.Sprintf("new%06d", i)), value)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatal("compact range fail", err)
+	}
+	db.Close()
+
+	_ = fs.RemoveFile(internal.CurrentFileName(dbName))
+	if err := Repair(dbName, opts); err != nil {
+		t.Fatal("repair fail", err)
+	}
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal("open repaired database fail", err)
+	}
+	defer db.Close()
+	for _, prefix := range []string{"old", "new"} {
+		for i := 0; i < 6000; i++ {
+			if v, err := db.Get([]byte(fmt.Sprintf("%s%06d", prefix, i))); err != nil || !bytes.Equal(v, value) {
+				t.Fatal("get key fail after repair", prefix, i, err)
+			}
+		}
+	}
+}
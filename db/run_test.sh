@@ -1,2 +1 @@
-mkdir goveldbtest
 go test -v
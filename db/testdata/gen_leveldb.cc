@@ -0,0 +1,46 @@
+// Writes testdata/leveldb with the reference C++ LevelDB:
+// 2000 keys from "key000000" to "key001999" are compacted into sstable,
+// and then "key000000" is updated to "updated" and "key000001" is deleted, which are left in log.
+//
+//	g++ -std=c++11 -o gen_leveldb gen_leveldb.cc -lleveldb
+//	rm -rf leveldb && ./gen_leveldb leveldb && rm -f leveldb/LOCK leveldb/LOG*
+
+#include <cstdio>
+#include <cstdlib>
+#include <string>
+
+#include "leveldb/db.h"
+
+static void check(const leveldb::Status& s) {
+  if (!s.ok()) {
+    std::fprintf(stderr, "%s\n", s.ToString().c_str());
+    std::exit(1);
+  }
+}
+
+int main(int argc, char** argv) {
+  if (argc != 2) {
+    std::fprintf(stderr, "usage: %s <dbname>\n", argv[0]);
+    return 1;
+  }
+
+  leveldb::Options options;
+  options.create_if_missing = true;
+  options.compression = leveldb::kNoCompression;
+  leveldb::DB* db;
+  check(leveldb::DB::Open(options, argv[1], &db));
+
+  char key[16], value[16];
+  for (int i = 0; i < 2000; i++) {
+    std::snprintf(key, sizeof(key), "key%06d", i);
+    std::snprintf(value, sizeof(value), "value%06d", i);
+    check(db->Put(leveldb::WriteOptions(), key, value));
+  }
+  db->CompactRange(nullptr, nullptr);
+
+  // the memtable isn't flushed on close, so these are only in log
+  check(db->Put(leveldb::WriteOptions(), "key000000", "updated"));
+  check(db->Delete(leveldb::WriteOptions(), "key000001"));
+  delete db;
+  return 0;
+}
@@ -0,0 +1,183 @@
+// WriteBatch holds a collection of updates to apply atomically to a database
+// The layout of a write batch is the same as leveldb's, and it's written to log as a record:
+//		sequence: fixed64, sequence number of the first update
+//		count: fixed32, number of updates
+//		data: record[count]
+// record:
+//		TypeValue varint32-length-prefixed key varint32-length-prefixed value
+//		TypeDeletion varint32-length-prefixed key
+
+package db
+
+import (
+	"encoding/binary"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/memtable"
+)
+
+const (
+	kBatchHeaderSize = 8 + 4
+)
+
+type WriteBatch struct {
+	rep []byte
+}
+
+func (batch *WriteBatch) init() {
+	if len(batch.rep) < kBatchHeaderSize {
+		batch.rep = make([]byte, kBatchHeaderSize)
+	}
+}
+
+// @description: store the mapping key->value in database
+
+func (batch *WriteBatch) Put(key, value []byte) {
+	batch.init()
+	batch.setCount(batch.Count() + 1)
+	batch.rep = append(batch.rep, byte(internal.TypeValue))
+	batch.rep = appendLengthPrefixed(batch.rep, key)
+	batch.rep = appendLengthPrefixed(batch.rep, value)
+}
+
+// @description: erase the mapping of key from database if any
+
+func (batch *WriteBatch) Delete(key []byte) {
+	batch.init()
+	batch.setCount(batch.Count() + 1)
+	batch.rep = append(batch.rep, byte(internal.TypeDeletion))
+	batch.rep = appendLengthPrefixed(batch.rep, key)
+}
+
+// @description: clear all updates buffered in this batch
+
+func (batch *WriteBatch) Clear() {
+	batch.rep = batch.rep[:0]
+	batch.init()
+}
+
+// @description: return the number of updates in this batch
+
+func (batch *WriteBatch) Count() int {
+	if len(batch.rep) < kBatchHeaderSize {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(batch.rep[8:]))
+}
+
+// @description: the size of database changes caused by this batch
+
+func (batch *WriteBatch) ApproximateSize() int {
+	if len(batch.rep) < kBatchHeaderSize {
+		return kBatchHeaderSize
+	}
+	return len(batch.rep)
+}
+
+// @description: copy the updates in src to this batch
+
+func (batch *WriteBatch) Append(src *WriteBatch) {
+	batch.init()
+	if src.Count() == 0 {
+		return
+	}
+	batch.setCount(batch.Count() + src.Count())
+	batch.rep = append(batch.rep, src.rep[kBatchHeaderSize:]...)
+}
+
+func (batch *WriteBatch) setCount(n int) {
+	binary.LittleEndian.PutUint32(batch.rep[8:], uint32(n))
+}
+
+func (batch *WriteBatch) sequence() uint64 {
+	return binary.LittleEndian.Uint64(batch.rep)
+}
+
+func (batch *WriteBatch) setSequence(seq uint64) {
+	batch.init()
+	binary.LittleEndian.PutUint64(batch.rep, seq)
+}
+
+func (batch *WriteBatch) contents() []byte {
+	batch.init()
+	return batch.rep
+}
+
+// @description: replace the updates by a record read from log
+// @return: error if the record is too short to be a write batch
+
+func (batch *WriteBatch) setContents(p []byte) error {
+	if len(p) < kBatchHeaderSize {
+		return internal.ErrCorruption
+	}
+	batch.rep = append(batch.rep[:0], p...)
+	return nil
+}
+
+// @description: call fn on every update in this batch in order
+// @return: error if the batch is malformed
+
+func (batch *WriteBatch) iterate(fn func(valueType internal.ValueType, key, value []byte)) error {
+	if len(batch.rep) < kBatchHeaderSize {
+		return nil
+	}
+
+	p := batch.rep[kBatchHeaderSize:]
+	found := 0
+	for len(p) > 0 {
+		var key, value []byte
+		var ok bool
+
+		valueType := internal.ValueType(p[0])
+		p = p[1:]
+		switch valueType {
+		case internal.TypeValue:
+			if key, p, ok = getLengthPrefixed(p); !ok {
+				return internal.ErrCorruption
+			}
+			if value, p, ok = getLengthPrefixed(p); !ok {
+				return internal.ErrCorruption
+			}
+		case internal.TypeDeletion:
+			if key, p, ok = getLengthPrefixed(p); !ok {
+				return internal.ErrCorruption
+			}
+		default:
+			return internal.ErrCorruption
+		}
+
+		fn(valueType, key, value)
+		found++
+	}
+
+	if found != batch.Count() {
+		return internal.ErrCorruption
+	}
+	return nil
+}
+
+// @description: apply the updates to memtable, the sequence numbers are allocated from batch.sequence() in order
+// @return: error if the batch is malformed
+
+func (batch *WriteBatch) insertInto(mem *memtable.MemTable) error {
+	seq := batch.sequence()
+	return batch.iterate(func(valueType internal.ValueType, key, value []byte) {
+		mem.Add(seq, valueType, key, value)
+		seq++
+	})
+}
+
+func appendLengthPrefixed(dst []byte, p []byte) []byte {
+	var buf [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(buf[:], uint64(len(p)))
+	dst = append(dst, buf[:n]...)
+	return append(dst, p...)
+}
+
+func getLengthPrefixed(p []byte) ([]byte, []byte, bool) {
+	length, n := binary.Uvarint(p)
+	if n <= 0 || uint64(len(p)-n) < length {
+		return nil, nil, false
+	}
+	p = p[n:]
+	return p[:length], p[length:], true
+}
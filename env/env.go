@@ -0,0 +1,101 @@
+// Env abstracts the file system used by database, all files of database are accessed through it:
+//		log and manifest files are written sequentially by WritableFile and replayed by SequentialFile
+//		sstable files are written by WritableFile and read by RandomAccessFile
+//		CURRENT file is switched by writing a temp file and renaming it
+// Default() is backed by the os file system, and NewMemEnv() keeps everything in memory for hermetic tests
+
+package env
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// SequentialFile is read from the beginning to the end, such as a log file being recovered
+
+type SequentialFile interface {
+	io.Reader
+	io.Closer
+}
+
+// RandomAccessFile is read at arbitrary offsets, such as a sstable file, it's safe for concurrent use
+
+type RandomAccessFile interface {
+	io.ReaderAt
+	io.Closer
+
+	// Returns the size of file
+	Size() (int64, error)
+}
+
+// WritableFile is written sequentially, the data written is durable only after Sync
+
+type WritableFile interface {
+	io.Writer
+	io.Closer
+	Sync() error
+}
+
+type Env interface {
+	// Open a file for reading from the beginning
+	NewSequentialFile(name string) (SequentialFile, error)
+
+	// Open a file for reading at arbitrary offsets
+	NewRandomAccessFile(name string) (RandomAccessFile, error)
+
+	// Create a file for writing, the existing one with the same name is truncated
+	NewWritableFile(name string) (WritableFile, error)
+
+	RemoveFile(name string) error
+
+	// Rename a file, the existing one with the new name is replaced atomically
+	RenameFile(src, dst string) error
+
+	// Returns the names of files in the directory, which are not prefixed by the directory
+	GetChildren(dir string) ([]string, error)
+
+	GetFileSize(name string) (int64, error)
+
+	FileExists(name string) bool
+
+	// Create a directory along with its parents, nothing is done if it exists
+	CreateDir(dir string) error
+
+	// Remove an empty directory, it fails if there's anything in it
+	RemoveDir(dir string) error
+
+	// Lock a file so that the database can't be opened by others, internal.ErrLocked is returned if it's locked
+	// The file is created if it doesn't exist, and the lock is released by closing the returned io.Closer
+	LockFile(name string) (io.Closer, error)
+}
+
+// @description: read the whole file
+// @param: the env and the file name
+// @return: the contents of file and error if any
+
+func ReadFile(e Env, name string) ([]byte, error) {
+	file, err := e.NewSequentialFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// @description: create a file with the contents
+// @param: the env, the file name, the contents and if the file should be synced before it's closed
+// @return: error if any
+
+func WriteFile(e Env, name string, p []byte, sync bool) error {
+	file, err := e.NewWritableFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(p); err == nil && sync {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package env
+
+import (
+	"github.com/jo3yzhu/goveldb/internal"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testEnv(t *testing.T, e Env, dir string) {
+	if err := e.CreateDir(filepath.Join(dir, "db")); err != nil {
+		t.Fatal("create dir fail", err)
+	}
+	dir = filepath.Join(dir, "db")
+	name := filepath.Join(dir, "000001.log")
+
+	file, err := e.NewWritableFile(name)
+	if err != nil {
+		t.Fatal("create file fail", err)
+	}
+	_, _ = file.Write([]byte("hello "))
+	_, _ = file.Write([]byte("world"))
+	if err := file.Sync(); err != nil {
+		t.Fatal("sync fail", err)
+	}
+	_ = file.Close()
+
+	if p, err := ReadFile(e, name); err != nil || string(p) != "hello world" {
+		t.Fatal("read file fail", err, string(p))
+	}
+	if size, err := e.GetFileSize(name); err != nil || size != 11 {
+		t.Fatal("file size error", size, err)
+	}
+
+	random, err := e.NewRandomAccessFile(name)
+	if err != nil {
+		t.Fatal("open file fail", err)
+	}
+	p := make([]byte, 5)
+	if _, err := random.ReadAt(p, 6); err != nil || string(p) != "world" {
+		t.Fatal("read at fail", err, string(p))
+	}
+	if _, err := random.ReadAt(p, 8); err != io.EOF {
+		t.Fatal("expect EOF", err)
+	}
+	if size, err := random.Size(); err != nil || size != 11 {
+		t.Fatal("size error", size, err)
+	}
+	_ = random.Close()
+
+	// rename replaces the existing file
+	current := filepath.Join(dir, "CURRENT")
+	_ = WriteFile(e, current, []byte("old"), false)
+	if err := e.RenameFile(name, current); err != nil {
+		t.Fatal("rename fail", err)
+	}
+	if e.FileExists(name) || !e.FileExists(current) {
+		t.Fatal("file is not renamed")
+	}
+	if p, _ := ReadFile(e, current); string(p) != "hello world" {
+		t.Fatal("file is not replaced", string(p))
+	}
+
+	lock, err := e.LockFile(filepath.Join(dir, "LOCK"))
+	if err != nil {
+		t.Fatal("lock fail", err)
+	}
+	if _, err := e.LockFile(filepath.Join(dir, "LOCK")); err != internal.ErrLocked {
+		t.Fatal("file is locked twice", err)
+	}
+	_ = lock.Close()
+	lock, err = e.LockFile(filepath.Join(dir, "LOCK"))
+	if err != nil {
+		t.Fatal("lock is not released", err)
+	}
+	_ = lock.Close()
+
+	if names, err := e.GetChildren(dir); err != nil || len(names) != 2 || names[0] != "CURRENT" || names[1] != "LOCK" {
+		t.Fatal("get children error", names, err)
+	}
+	if err := e.RemoveFile(current); err != nil || e.FileExists(current) {
+		t.Fatal("remove fail", err)
+	}
+	if _, err := e.NewSequentialFile(current); !os.IsNotExist(err) {
+		t.Fatal("expect not exist", err)
+	}
+	if _, err := e.GetChildren(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatal("expect not exist", err)
+	}
+}
+
+func Test_Env(t *testing.T) {
+	// the only test of the disk env, others run on the in-memory env
+	testEnv(t, Default(), t.TempDir())
+}
+
+func Test_MemEnv(t *testing.T) {
+	testEnv(t, NewMemEnv(), "/tmp/goveldb")
+
+	// envs in memory are independent of each other
+	if NewMemEnv().FileExists("/tmp/goveldb/db") {
+		t.Fatal("env is shared")
+	}
+}
+
+func Test_FaultInjectionEnv(t *testing.T) {
+	e := NewFaultInjectionEnv(NewMemEnv())
+	_ = e.CreateDir("/db")
+
+	file, _ := e.NewWritableFile("/db/000001.log")
+	_, _ = file.Write([]byte("synced"))
+	_ = file.Sync()
+	_, _ = file.Write([]byte(" lost"))
+	_ = WriteFile(e, "/db/000002.dbtmp", []byte("renamed"), true)
+	_ = e.RenameFile("/db/000002.dbtmp", "/db/CURRENT")
+	_ = WriteFile(e, "/db/000003.ldb", []byte("never synced"), false)
+
+	if err := e.DropUnsyncedData(); err != nil {
+		t.Fatal("drop unsynced data fail", err)
+	}
+	for name, expected := range map[string]string{"/db/000001.log": "synced", "/db/CURRENT": "renamed", "/db/000003.ldb": ""} {
+		if p, err := ReadFile(e, name); err != nil || string(p) != expected {
+			t.Fatal("unsynced data is not dropped", name, string(p), err)
+		}
+	}
+
+	// the write fails after 2 more succeed
+	e.FailAfter(FaultOpWrite, 2)
+	for i := 0; i < 3; i++ {
+		_, err := file.Write([]byte("x"))
+		if (i < 2) != (err == nil) {
+			t.Fatal("fault is not injected at the point", i, err)
+		}
+	}
+	if _, err := file.Write([]byte("x")); err != nil {
+		t.Fatal("fault is injected more than once", err)
+	}
+
+	// all modifications fail when it's inactive, but reading is fine
+	e.SetActive(false)
+	if err := e.RenameFile("/db/CURRENT", "/db/OLD"); err != internal.ErrFaultInjected {
+		t.Fatal("rename should fail", err)
+	}
+	if _, err := e.NewWritableFile("/db/000004.log"); err != internal.ErrFaultInjected {
+		t.Fatal("create should fail", err)
+	}
+	if p, err := ReadFile(e, "/db/CURRENT"); err != nil || string(p) != "renamed" {
+		t.Fatal("read fail", err)
+	}
+	e.SetActive(true)
+	if err := e.RemoveFile("/db/CURRENT"); err != nil {
+		t.Fatal("remove fail", err)
+	}
+}
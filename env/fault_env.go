@@ -0,0 +1,225 @@
+// FaultInjectionEnv wraps an env to test how database survives failures and crashes:
+//		data written to a file is durable only after it's synced, DropUnsyncedData throws away the rest as power loss does
+//		renaming and removing files are durable once they're done, as if the directory were synced
+//		SetActive(false) makes all modifications fail, so that a crashed database can't modify files any more before it's closed
+//		FailAfter makes a certain operation fail at a chosen point
+// A crash is simulated by SetActive(false), closing the database, DropUnsyncedData and SetActive(true)
+
+package env
+
+import (
+	"github.com/jo3yzhu/goveldb/internal"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// FaultOp is an operation of file system which can be made to fail
+
+type FaultOp int
+
+const (
+	FaultOpWrite FaultOp = iota // writing a WritableFile, nothing is written if it fails
+	FaultOpSync
+	FaultOpCreate // creating a WritableFile
+	FaultOpRename
+	FaultOpRemove
+	numFaultOps
+)
+
+type FaultInjectionEnv struct {
+	Env // the underlying env which files are really in
+
+	mu        sync.Mutex
+	active    bool
+	synced    map[string]int64 // number of bytes synced of files written since they are created, the rest are lost on crash
+	countdown [numFaultOps]int // the operation fails when it reaches 0, negative means never
+}
+
+// @description: wrap an env with fault injection, all operations succeed until faults are injected
+// @param: the underlying env
+
+func NewFaultInjectionEnv(base Env) *FaultInjectionEnv {
+	e := &FaultInjectionEnv{
+		Env:    base,
+		active: true,
+		synced: make(map[string]int64),
+	}
+	for op := range e.countdown {
+		e.countdown[op] = -1
+	}
+	return e
+}
+
+// @description: turn on or off the file system, all modifications fail with internal.ErrFaultInjected if it's off
+//               locks can still be released when it's off, so that the crashed database can be closed
+
+func (e *FaultInjectionEnv) SetActive(active bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.active = active
+}
+
+// @description: make the operation fail once after n more calls of it succeed
+// @param: the operation and n, negative n cancels the pending failure
+
+func (e *FaultInjectionEnv) FailAfter(op FaultOp, n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.countdown[op] = n
+}
+
+// @description: check if the operation should fail, the countdown of it is decreased
+// @return: internal.ErrFaultInjected if it fails
+// @note: REQUIRES: e.mu is held
+
+func (e *FaultInjectionEnv) check(op FaultOp) error {
+	if !e.active {
+		return internal.ErrFaultInjected
+	}
+	if e.countdown[op] < 0 {
+		return nil
+	}
+	if e.countdown[op] == 0 {
+		e.countdown[op] = -1
+		return internal.ErrFaultInjected
+	}
+	e.countdown[op]--
+	return nil
+}
+
+// @description: throw away the data not synced of files, as if power is lost
+//               files which are created but never synced become empty
+// @return: error if any file can't be truncated
+
+func (e *FaultInjectionEnv) DropUnsyncedData() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for name, synced := range e.synced {
+		p, err := ReadFile(e.Env, name)
+		if err != nil {
+			return err
+		}
+		if int64(len(p)) <= synced {
+			continue
+		}
+		if err := WriteFile(e.Env, name, p[:synced], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type faultWritableFile struct {
+	env  *FaultInjectionEnv
+	name string
+	file WritableFile
+	pos  int64 // number of bytes written
+}
+
+func (f *faultWritableFile) Write(p []byte) (int, error) {
+	f.env.mu.Lock()
+	defer f.env.mu.Unlock()
+	if err := f.env.check(FaultOpWrite); err != nil {
+		return 0, err
+	}
+	n, err := f.file.Write(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *faultWritableFile) Sync() error {
+	f.env.mu.Lock()
+	defer f.env.mu.Unlock()
+	if err := f.env.check(FaultOpSync); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	// the file may have been renamed or removed, which doesn't affect the synced data of others
+	if synced, ok := f.env.synced[f.name]; ok && synced < f.pos {
+		f.env.synced[f.name] = f.pos
+	}
+	return nil
+}
+
+func (f *faultWritableFile) Close() error {
+	return f.file.Close()
+}
+
+func (e *FaultInjectionEnv) NewWritableFile(name string) (WritableFile, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.check(FaultOpCreate); err != nil {
+		return nil, err
+	}
+	file, err := e.Env.NewWritableFile(name)
+	if err != nil {
+		return nil, err
+	}
+	name = filepath.Clean(name)
+	e.synced[name] = 0
+	return &faultWritableFile{env: e, name: name, file: file}, nil
+}
+
+func (e *FaultInjectionEnv) RemoveFile(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.check(FaultOpRemove); err != nil {
+		return err
+	}
+	if err := e.Env.RemoveFile(name); err != nil {
+		return err
+	}
+	delete(e.synced, filepath.Clean(name))
+	return nil
+}
+
+func (e *FaultInjectionEnv) RenameFile(src, dst string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.check(FaultOpRename); err != nil {
+		return err
+	}
+	if err := e.Env.RenameFile(src, dst); err != nil {
+		return err
+	}
+
+	src, dst = filepath.Clean(src), filepath.Clean(dst)
+	delete(e.synced, dst)
+	if synced, ok := e.synced[src]; ok {
+		e.synced[dst] = synced
+		delete(e.synced, src)
+	}
+	return nil
+}
+
+func (e *FaultInjectionEnv) CreateDir(dir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.active {
+		return internal.ErrFaultInjected
+	}
+	return e.Env.CreateDir(dir)
+}
+
+func (e *FaultInjectionEnv) RemoveDir(dir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.check(FaultOpRemove); err != nil {
+		return err
+	}
+	return e.Env.RemoveDir(dir)
+}
+
+func (e *FaultInjectionEnv) LockFile(name string) (io.Closer, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.active {
+		return nil, internal.ErrFaultInjected
+	}
+	return e.Env.LockFile(name)
+}
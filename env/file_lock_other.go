@@ -0,0 +1,11 @@
+//go:build !unix
+
+package env
+
+import "os"
+
+// @description: file locks of os are not supported on this platform, databases are only locked within process
+
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package env
+
+import (
+	"github.com/jo3yzhu/goveldb/internal"
+	"os"
+	"syscall"
+)
+
+// @description: take an exclusive advisory lock of file without blocking
+// @return: internal.ErrLocked if it's locked by another process
+
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return internal.ErrLocked
+	}
+	return err
+}
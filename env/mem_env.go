@@ -0,0 +1,275 @@
+package env
+
+import (
+	"github.com/jo3yzhu/goveldb/internal"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// memEnv keeps files in memory, data written is visible to readers immediately and never lost until the env is dropped
+// A file removed or replaced is still readable and writable by the ones which have opened it, as it's in posix
+
+type memEnv struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+	dirs  map[string]bool
+	locks map[string]bool
+}
+
+type memFile struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// @description: create an empty env in memory, envs created by it are independent of each other
+// @return: the env
+
+func NewMemEnv() Env {
+	return &memEnv{
+		files: make(map[string]*memFile),
+		dirs:  map[string]bool{".": true, "/": true},
+		locks: make(map[string]bool),
+	}
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// @return: the file and if it exists
+// @note: REQUIRES: e.mu is held
+
+func (e *memEnv) lookup(name string) (*memFile, bool) {
+	file, ok := e.files[filepath.Clean(name)]
+	return file, ok
+}
+
+type memSequentialFile struct {
+	file   *memFile
+	offset int64
+}
+
+func (f *memSequentialFile) Read(p []byte) (int, error) {
+	n, err := f.file.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memSequentialFile) Close() error {
+	return nil
+}
+
+type memRandomAccessFile struct {
+	file *memFile
+}
+
+func (f *memRandomAccessFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.file.ReadAt(p, off)
+}
+
+func (f *memRandomAccessFile) Size() (int64, error) {
+	return f.file.Size(), nil
+}
+
+func (f *memRandomAccessFile) Close() error {
+	return nil
+}
+
+type memWritableFile struct {
+	file *memFile
+}
+
+func (f *memWritableFile) Write(p []byte) (int, error) {
+	f.file.mu.Lock()
+	f.file.data = append(f.file.data, p...)
+	f.file.mu.Unlock()
+	return len(p), nil
+}
+
+func (f *memWritableFile) Sync() error {
+	return nil
+}
+
+func (f *memWritableFile) Close() error {
+	return nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Size() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return int64(len(f.data))
+}
+
+func (e *memEnv) NewSequentialFile(name string) (SequentialFile, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	file, ok := e.lookup(name)
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return &memSequentialFile{file: file}, nil
+}
+
+func (e *memEnv) NewRandomAccessFile(name string) (RandomAccessFile, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	file, ok := e.lookup(name)
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return &memRandomAccessFile{file: file}, nil
+}
+
+func (e *memEnv) NewWritableFile(name string) (WritableFile, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.dirs[filepath.Dir(filepath.Clean(name))] {
+		return nil, notExist("open", name)
+	}
+
+	// the file opened by others is not truncated, it's replaced by a new one
+	file := &memFile{}
+	e.files[filepath.Clean(name)] = file
+	return &memWritableFile{file: file}, nil
+}
+
+func (e *memEnv) RemoveFile(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.lookup(name); !ok {
+		return notExist("remove", name)
+	}
+	delete(e.files, filepath.Clean(name))
+	return nil
+}
+
+func (e *memEnv) RenameFile(src, dst string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	file, ok := e.lookup(src)
+	if !ok {
+		return notExist("rename", src)
+	}
+	delete(e.files, filepath.Clean(src))
+	e.files[filepath.Clean(dst)] = file
+	return nil
+}
+
+func (e *memEnv) GetChildren(dir string) ([]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	dir = filepath.Clean(dir)
+	if !e.dirs[dir] {
+		return nil, notExist("open", dir)
+	}
+
+	var names []string
+	for name := range e.files {
+		if filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	for name := range e.dirs {
+		if name != dir && filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (e *memEnv) GetFileSize(name string) (int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	file, ok := e.lookup(name)
+	if !ok {
+		return 0, notExist("stat", name)
+	}
+	return file.Size(), nil
+}
+
+func (e *memEnv) FileExists(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.lookup(name)
+	return ok || e.dirs[filepath.Clean(name)]
+}
+
+func (e *memEnv) CreateDir(dir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for dir = filepath.Clean(dir); !e.dirs[dir]; dir = filepath.Dir(dir) {
+		e.dirs[dir] = true
+	}
+	return nil
+}
+
+func (e *memEnv) RemoveDir(dir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	dir = filepath.Clean(dir)
+	if !e.dirs[dir] {
+		return notExist("remove", dir)
+	}
+	for name := range e.files {
+		if filepath.Dir(name) == dir {
+			return &os.PathError{Op: "remove", Path: dir, Err: syscall.ENOTEMPTY}
+		}
+	}
+	for name := range e.dirs {
+		if name != dir && filepath.Dir(name) == dir {
+			return &os.PathError{Op: "remove", Path: dir, Err: syscall.ENOTEMPTY}
+		}
+	}
+	delete(e.dirs, dir)
+	return nil
+}
+
+type memFileLock struct {
+	env  *memEnv
+	name string
+}
+
+func (e *memEnv) LockFile(name string) (io.Closer, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	name = filepath.Clean(name)
+	if !e.dirs[filepath.Dir(name)] {
+		return nil, notExist("open", name)
+	}
+	if e.locks[name] {
+		return nil, internal.ErrLocked
+	}
+	if _, ok := e.files[name]; !ok {
+		e.files[name] = &memFile{}
+	}
+	e.locks[name] = true
+	return &memFileLock{env: e, name: name}, nil
+}
+
+func (l *memFileLock) Close() error {
+	l.env.mu.Lock()
+	defer l.env.mu.Unlock()
+	delete(l.env.locks, l.name)
+	return nil
+}
@@ -0,0 +1,133 @@
+package env
+
+import (
+	"github.com/jo3yzhu/goveldb/internal"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// osEnv is backed by the os file system
+
+type osEnv struct {
+	mu    sync.Mutex
+	locks map[string]bool // files locked by this process, file locks of os may not exclude the same process
+}
+
+var defaultEnv = &osEnv{locks: make(map[string]bool)}
+
+// @return: the env backed by the os file system, which is shared by all databases in process
+
+func Default() Env {
+	return defaultEnv
+}
+
+type osRandomAccessFile struct {
+	*os.File
+}
+
+func (f osRandomAccessFile) Size() (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+func (e *osEnv) NewSequentialFile(name string) (SequentialFile, error) {
+	return os.Open(name)
+}
+
+func (e *osEnv) NewRandomAccessFile(name string) (RandomAccessFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return osRandomAccessFile{file}, nil
+}
+
+func (e *osEnv) NewWritableFile(name string) (WritableFile, error) {
+	return os.Create(name)
+}
+
+func (e *osEnv) RemoveFile(name string) error {
+	return os.Remove(name)
+}
+
+func (e *osEnv) RenameFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
+
+func (e *osEnv) GetChildren(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+func (e *osEnv) GetFileSize(name string) (int64, error) {
+	stat, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+func (e *osEnv) FileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func (e *osEnv) CreateDir(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func (e *osEnv) RemoveDir(dir string) error {
+	return os.Remove(dir)
+}
+
+type osFileLock struct {
+	env  *osEnv
+	name string
+	file *os.File
+}
+
+func (e *osEnv) LockFile(name string) (io.Closer, error) {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.locks[name] {
+		return nil, internal.ErrLocked
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	e.locks[name] = true
+	return &osFileLock{env: e, name: name, file: file}, nil
+}
+
+func (l *osFileLock) Close() error {
+	l.env.mu.Lock()
+	delete(l.env.locks, l.name)
+	l.env.mu.Unlock()
+
+	// closing the file releases the lock of os
+	return l.file.Close()
+}
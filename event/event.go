@@ -0,0 +1,153 @@
+// Listener is notified of background events of database, such as flush, compaction and lifecycle of sstable files
+// Callbacks are called synchronously in the goroutine where events happen, some of them with internal locks held,
+// so they should return quickly and must not call methods of database
+// Embed BaseListener to implement only the callbacks of interest
+
+package event
+
+import "time"
+
+// FlushInfo describes a flush of immutable memtable into a level0 table, which is known as minor compaction
+
+type FlushInfo struct {
+	DbName     string
+	FileNumber uint64 // number of the table file, which is removed if the memtable is empty
+	Level      int    // the level which the table is placed in, it's pushed to deeper levels if it doesn't overlap
+	Size       uint64
+	Duration   time.Duration
+	Err        error // not nil if the flush fails, then the table is removed
+}
+
+// CompactionInfo describes a compaction from Level to OutputLevel, which is known as major compaction
+
+type CompactionInfo struct {
+	DbName       string
+	Level        int
+	OutputLevel  int
+	InputFiles   [2][]uint64 // numbers of input files in Level and OutputLevel
+	OutputFiles  []uint64
+	BytesRead    uint64
+	BytesWritten uint64
+	Duration     time.Duration
+	Err          error // not nil if the compaction is aborted, then the output files are removed
+}
+
+// TableFileInfo describes a table file created or deleted
+
+type TableFileInfo struct {
+	DbName     string
+	FileNumber uint64
+	FileName   string
+	Size       uint64
+	Err        error // not nil if the file can't be created or deleted
+}
+
+type WriteStallCondition int
+
+const (
+	WriteStallNormal  WriteStallCondition = iota
+	WriteStallDelayed                     // writes are slowed down because there are too many files in level0
+	WriteStallStopped                     // writes wait until the immutable memtable is flushed
+)
+
+func (c WriteStallCondition) String() string {
+	switch c {
+	case WriteStallNormal:
+		return "normal"
+	case WriteStallDelayed:
+		return "delayed"
+	case WriteStallStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+type WriteStallInfo struct {
+	DbName   string
+	Previous WriteStallCondition
+	Current  WriteStallCondition
+}
+
+// BackgroundErrorInfo describes an error of flush or compaction in background
+
+type BackgroundErrorInfo struct {
+	DbName string
+	Reason string // "flush" or "compaction"
+	Err    error
+}
+
+type Listener interface {
+	OnFlushBegin(info FlushInfo)
+	OnFlushEnd(info FlushInfo)
+	OnCompactionBegin(info CompactionInfo)
+	OnCompactionEnd(info CompactionInfo)
+	OnTableFileCreated(info TableFileInfo)
+	OnTableFileDeleted(info TableFileInfo)
+	OnWriteStallChange(info WriteStallInfo)
+	OnBackgroundError(info BackgroundErrorInfo)
+}
+
+// BaseListener ignores all events
+
+type BaseListener struct{}
+
+func (BaseListener) OnFlushBegin(FlushInfo)                {}
+func (BaseListener) OnFlushEnd(FlushInfo)                  {}
+func (BaseListener) OnCompactionBegin(CompactionInfo)      {}
+func (BaseListener) OnCompactionEnd(CompactionInfo)        {}
+func (BaseListener) OnTableFileCreated(TableFileInfo)      {}
+func (BaseListener) OnTableFileDeleted(TableFileInfo)      {}
+func (BaseListener) OnWriteStallChange(WriteStallInfo)     {}
+func (BaseListener) OnBackgroundError(BackgroundErrorInfo) {}
+
+// Listeners notifies each listener in order
+
+type Listeners []Listener
+
+func (listeners Listeners) OnFlushBegin(info FlushInfo) {
+	for _, l := range listeners {
+		l.OnFlushBegin(info)
+	}
+}
+
+func (listeners Listeners) OnFlushEnd(info FlushInfo) {
+	for _, l := range listeners {
+		l.OnFlushEnd(info)
+	}
+}
+
+func (listeners Listeners) OnCompactionBegin(info CompactionInfo) {
+	for _, l := range listeners {
+		l.OnCompactionBegin(info)
+	}
+}
+
+func (listeners Listeners) OnCompactionEnd(info CompactionInfo) {
+	for _, l := range listeners {
+		l.OnCompactionEnd(info)
+	}
+}
+
+func (listeners Listeners) OnTableFileCreated(info TableFileInfo) {
+	for _, l := range listeners {
+		l.OnTableFileCreated(info)
+	}
+}
+
+func (listeners Listeners) OnTableFileDeleted(info TableFileInfo) {
+	for _, l := range listeners {
+		l.OnTableFileDeleted(info)
+	}
+}
+
+func (listeners Listeners) OnWriteStallChange(info WriteStallInfo) {
+	for _, l := range listeners {
+		l.OnWriteStallChange(info)
+	}
+}
+
+func (listeners Listeners) OnBackgroundError(info BackgroundErrorInfo) {
+	for _, l := range listeners {
+		l.OnBackgroundError(info)
+	}
+}
@@ -0,0 +1,95 @@
+// Bloom filter in the same format as leveldb's builtin bloom filter:
+//		bit array: bits of keys, whose length is a multiple of 8
+//		k: number of probes, 1 byte
+// double hashing is used to generate the probes of a key
+
+package filter
+
+import "github.com/jo3yzhu/goveldb/utils"
+
+const kBloomHashSeed = 0xbc9f1d34
+
+func bloomHash(key []byte) uint32 {
+	return utils.Hash(key, kBloomHashSeed)
+}
+
+// BloomBuilder collects keys and generates a bloom filter of them
+
+type BloomBuilder struct {
+	bitsPerKey int
+	hashes     []uint32
+}
+
+func NewBloomBuilder(bitsPerKey int) *BloomBuilder {
+	return &BloomBuilder{
+		bitsPerKey: bitsPerKey,
+	}
+}
+
+func (builder *BloomBuilder) Add(key []byte) {
+	builder.hashes = append(builder.hashes, bloomHash(key))
+}
+
+func (builder *BloomBuilder) Empty() bool {
+	return len(builder.hashes) == 0
+}
+
+// @description: generate the filter of keys added
+// @return: the encoded filter
+
+func (builder *BloomBuilder) Finish() []byte {
+	// round down to reduce probing cost a little bit, ln(2) is the best
+	k := builder.bitsPerKey * 69 / 100
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+
+	// for small n, we can see a very high false positive rate, fix it by enforcing a minimum bloom filter length
+	bits := len(builder.hashes) * builder.bitsPerKey
+	if bits < 64 {
+		bits = 64
+	}
+	bytes := (bits + 7) / 8
+	bits = bytes * 8
+
+	filter := make([]byte, bytes+1)
+	filter[bytes] = byte(k)
+	for _, h := range builder.hashes {
+		delta := h>>17 | h<<15 // rotate right 17 bits
+		for j := 0; j < k; j++ {
+			bitPos := h % uint32(bits)
+			filter[bitPos/8] |= 1 << (bitPos % 8)
+			h += delta
+		}
+	}
+	return filter
+}
+
+// @description: test if a key may be in the set of the filter
+// @return: false if the key is definitely not in the set
+
+func BloomMayContain(filter, key []byte) bool {
+	if len(filter) < 2 {
+		return false
+	}
+
+	// reserved for potentially new encodings for short bloom filters, consider it a match
+	k := int(filter[len(filter)-1])
+	if k > 30 {
+		return true
+	}
+
+	bits := uint32(len(filter)-1) * 8
+	h := bloomHash(key)
+	delta := h>>17 | h<<15
+	for j := 0; j < k; j++ {
+		bitPos := h % bits
+		if filter[bitPos/8]&(1<<(bitPos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package filter
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_Bloom(t *testing.T) {
+	builder := NewBloomBuilder(10)
+	for i := 0; i < 10000; i++ {
+		builder.Add([]byte(fmt.Sprintf("key%06d", i)))
+	}
+	p := builder.Finish()
+
+	// no false negative
+	for i := 0; i < 10000; i++ {
+		if !BloomMayContain(p, []byte(fmt.Sprintf("key%06d", i))) {
+			t.Fatal("added key is absent", i)
+		}
+	}
+
+	// about 1% false positive with 10 bits per key
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if BloomMayContain(p, []byte(fmt.Sprintf("absent%06d", i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200 {
+		t.Fatal("too many false positives", falsePositives)
+	}
+
+	if BloomMayContain(NewBloomBuilder(10).Finish(), []byte("key")) {
+		t.Fatal("empty filter contains key")
+	}
+}
+
+func Test_PrefixExtractor(t *testing.T) {
+	fixed := NewFixedPrefixExtractor(3)
+	if !fixed.InDomain([]byte("abcd")) || string(fixed.Transform([]byte("abcd"))) != "abc" {
+		t.Fatal("fixed prefix error")
+	}
+	if fixed.InDomain([]byte("ab")) {
+		t.Fatal("short key has prefix")
+	}
+
+	delimiter := NewDelimiterPrefixExtractor(':')
+	if !delimiter.InDomain([]byte("user:1")) || string(delimiter.Transform([]byte("user:1"))) != "user:" {
+		t.Fatal("delimiter prefix error")
+	}
+	if delimiter.InDomain([]byte("user")) {
+		t.Fatal("key without delimiter has prefix")
+	}
+
+	if fixed.Name() == NewFixedPrefixExtractor(4).Name() {
+		t.Fatal("extractors with different length have the same name")
+	}
+}
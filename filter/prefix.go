@@ -0,0 +1,66 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// PrefixExtractor extracts the prefix of a user key, keys with the same prefix should be adjacent in order
+// Prefixes of keys in a sstable are added to its prefix bloom filter, so that the table can be skipped when seeking a prefix it doesn't contain
+
+type PrefixExtractor interface {
+	// The name of extractor, the filter is ignored if the name it's built with mismatches
+	Name() string
+
+	// Returns the prefix of key.
+	// REQUIRES: InDomain(key)
+	Transform(key []byte) []byte
+
+	// Returns true if the key has a prefix
+	InDomain(key []byte) bool
+}
+
+type fixedPrefixExtractor struct {
+	length int
+}
+
+// @description: create an extractor whose prefix is the first length bytes of key, keys shorter than it have no prefix
+
+func NewFixedPrefixExtractor(length int) PrefixExtractor {
+	return &fixedPrefixExtractor{length: length}
+}
+
+func (extractor *fixedPrefixExtractor) Name() string {
+	return fmt.Sprintf("goveldb.FixedPrefix.%d", extractor.length)
+}
+
+func (extractor *fixedPrefixExtractor) Transform(key []byte) []byte {
+	return key[:extractor.length]
+}
+
+func (extractor *fixedPrefixExtractor) InDomain(key []byte) bool {
+	return len(key) >= extractor.length
+}
+
+type delimiterPrefixExtractor struct {
+	delimiter byte
+}
+
+// @description: create an extractor whose prefix is the part of key until the first delimiter inclusively,
+//				 such as "tenant/" of "tenant/key", keys without delimiter have no prefix
+
+func NewDelimiterPrefixExtractor(delimiter byte) PrefixExtractor {
+	return &delimiterPrefixExtractor{delimiter: delimiter}
+}
+
+func (extractor *delimiterPrefixExtractor) Name() string {
+	return fmt.Sprintf("goveldb.DelimiterPrefix.%d", extractor.delimiter)
+}
+
+func (extractor *delimiterPrefixExtractor) Transform(key []byte) []byte {
+	return key[:bytes.IndexByte(key, extractor.delimiter)+1]
+}
+
+func (extractor *delimiterPrefixExtractor) InDomain(key []byte) bool {
+	return bytes.IndexByte(key, extractor.delimiter) >= 0
+}
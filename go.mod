@@ -1,5 +1,7 @@
 module github.com/jo3yzhu/goveldb
 
-go 1.16
+go 1.19
 
 require github.com/hashicorp/golang-lru v0.5.4
+
+require github.com/golang/snappy v0.0.4
@@ -1,48 +1,81 @@
 package goveldb
 
-import "github.com/jo3yzhu/goveldb/db"
+import (
+	"context"
+	"github.com/jo3yzhu/goveldb/db"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/opt"
+)
+
+type WriteBatch = db.WriteBatch
+type Options = opt.Options
+type WriteOptions = opt.WriteOptions
+type ReadOptions = opt.ReadOptions
+type Range = db.Range
+type Env = env.Env
 
 type LevelDb interface {
 	Put(key, value []byte) error
 	Get(key []byte) ([]byte, error)
+
+	// Get with read options, the perf context in it traces how the key is found
+	GetWithOptions(opts *ReadOptions, key []byte) ([]byte, error)
 	Delete(key []byte) error
-}
 
-type Iterator interface {
-	// Returns true if the iterator is positioned at a valid node.
-	Valid() bool
+	// Apply the updates in batch atomically, concurrent writes are committed to log in group
+	Write(opts *WriteOptions, batch *WriteBatch) error
 
-	// Returns the key at the current position.
-	// REQUIRES: Valid()
-	Key() []byte
+	// Returns an iterator over the contents of the database, which sees a snapshot of it at the time it's created
+	// Keys out of the bounds in read options are invisible to it
+	NewIterator(opts *ReadOptions) Iterator
 
-	// Return the value for the current entry.  The underlying storage for
-	// the returned slice is valid only until the next modification of
-	// the iterator.
-	// REQUIRES: Valid()
-	Value() []byte
+	// Returns an iterator over the keys with prefix, which stops at the end of the prefix
+	PrefixIterator(prefix []byte) Iterator
 
-	// Advances to the next position.
-	// REQUIRES: Valid()
-	Next()
+	// Returns the approximate number of bytes in file system used by data in each key range, nil limit means after all keys
+	GetApproximateSizes(ranges []Range) []uint64
 
-	// Advances to the previous position.
-	// REQUIRES: Valid()
-	Prev()
+	// Context-aware variants, which return ctx.Err() if a write stall or a long scan outlives the context
+	PutContext(ctx context.Context, key, value []byte) error
+	GetContext(ctx context.Context, key []byte) ([]byte, error)
+	DeleteContext(ctx context.Context, key []byte) error
+	WriteContext(ctx context.Context, opts *WriteOptions, batch *WriteBatch) error
+	NewIteratorContext(ctx context.Context, opts *ReadOptions) Iterator
 
-	// Advance to the first entry with a key >= target
-	Seek(target []byte)
+	// Compact the key range [start, limit] in all levels, nil means unbounded
+	// The context variant can be cancelled, and the levels which are not finished are left untouched
+	CompactRange(start, limit []byte) error
+	CompactRangeContext(ctx context.Context, start, limit []byte) error
+
+	// Returns the value of a property of database and if the property is known, such as "goveldb.stats"
+	GetProperty(name string) (string, bool)
+	Close()
+}
 
-	// Position at the first entry in list.
-	// Final state of iterator is Valid() iff list is not empty.
-	SeekToFirst()
+type Iterator = db.Iterator
 
-	// Position at the last entry in list.
-	// Final state of iterator is Valid() iff list is not empty.
-	SeekToLast()
+// @description: open a database, which is created if it doesn't exist
+// @param: the database name and options, nil options mean the default ones
+
+func Open(dbName string, opts *Options) (LevelDb, error) {
+	d, err := db.Open(dbName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
-func Open(dbName string) LevelDb {
-	return db.Open(dbName)
+// @description: rebuild a database whose CURRENT file or manifest is lost or corrupted from the log files and tables left
+// @param: the database name and options, the database must not be open
+// @note: the files which can't be read are moved into lost/ of the database directory
+
+func Repair(dbName string, opts *Options) error {
+	return db.Repair(dbName, opts)
 }
 
+// @description: remove a database, only the files named by it are removed, and the directory if it's empty then
+// @param: the database name and options, the database must not be open
+
+func Destroy(dbName string, opts *Options) error {
+	return db.Destroy(dbName, opts)
+}
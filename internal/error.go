@@ -7,4 +7,9 @@ var (
 	ErrDeletion = errors.New("TypeDeletion")
 	ErrTableFileMagic = errors.New("ErrTableFileMagic")
 	ErrTableTooShort = errors.New("ErrTableTooShort")
+	ErrCorruption = errors.New("Corruption")
+	ErrNotSupported = errors.New("NotSupported")
+	ErrLocked = errors.New("Locked")
+	ErrFaultInjected = errors.New("FaultInjected")
+	ErrUnsupportedFormat = errors.New("UnsupportedFormat")
 )
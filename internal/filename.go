@@ -1,6 +1,24 @@
 package internal
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FileType tells what a file in database directory is, which is told by its name
+
+type FileType int
+
+const (
+	LogFile FileType = iota
+	DBLockFile
+	TableFile
+	DescriptorFile
+	CurrentFile
+	TempFile
+	InfoLogFile // LOG or LOG.old
+)
 
 func makeFileName(dbname string, number uint64, suffix string) string {
 	return fmt.Sprintf("%s/%06d.%s", dbname, number, suffix)
@@ -21,4 +39,68 @@ func CurrentFileName(dbname string) string {
 }
 func TempFileName(dbname string, number uint64) string {
 	return makeFileName(dbname, number, "dbtmp")
-}
\ No newline at end of file
+}
+// log file contains the write batches which have not been compacted into sstable yet
+
+func LogFileName(dbname string, number uint64) string {
+	return makeFileName(dbname, number, "log")
+}
+
+// info log file contains the messages of database written by the default logger, the previous one is kept as LOG.old
+
+func InfoLogFileName(dbname string) string {
+	return dbname + "/LOG"
+}
+
+func OldInfoLogFileName(dbname string) string {
+	return dbname + "/LOG.old"
+}
+
+// lock file prevents a database from being opened by several processes at the same time
+
+func LockFileName(dbname string) string {
+	return dbname + "/LOCK"
+}
+
+// @description: parse the name of a file in database directory, the names are:
+//		CURRENT, LOCK, LOG, LOG.old, MANIFEST-<number>, <number>.log, <number>.ldb, <number>.sst and <number>.dbtmp
+//		.sst is the table suffix of legacy leveldb
+// @param: the file name without directory
+// @return: the file number, 0 if there's no number in it, file type, and if it's a file of database
+
+func ParseFileName(name string) (uint64, FileType, bool) {
+	switch name {
+	case "CURRENT":
+		return 0, CurrentFile, true
+	case "LOCK":
+		return 0, DBLockFile, true
+	case "LOG", "LOG.old":
+		return 0, InfoLogFile, true
+	}
+
+	if strings.HasPrefix(name, "MANIFEST-") {
+		number, err := strconv.ParseUint(strings.TrimPrefix(name, "MANIFEST-"), 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		return number, DescriptorFile, true
+	}
+
+	dot := strings.IndexByte(name, '.')
+	if dot < 0 {
+		return 0, 0, false
+	}
+	number, err := strconv.ParseUint(name[:dot], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	switch name[dot+1:] {
+	case "log":
+		return number, LogFile, true
+	case "ldb", "sst":
+		return number, TableFile, true
+	case "dbtmp":
+		return number, TempFile, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseFileName(t *testing.T) {
+	cases := []struct {
+		name     string
+		number   uint64
+		fileType FileType
+	}{
+		{"CURRENT", 0, CurrentFile},
+		{"LOCK", 0, DBLockFile},
+		{"LOG", 0, InfoLogFile},
+		{"LOG.old", 0, InfoLogFile},
+		{"MANIFEST-000002", 2, DescriptorFile},
+		{"000123.log", 123, LogFile},
+		{"000123.ldb", 123, TableFile},
+		{"18446744073709551615.sst", 18446744073709551615, TableFile},
+		{"000007.dbtmp", 7, TempFile},
+	}
+	for _, c := range cases {
+		number, fileType, ok := ParseFileName(c.name)
+		if !ok || number != c.number || fileType != c.fileType {
+			t.Fatal("parse file name error", c.name, number, fileType, ok)
+		}
+	}
+
+	// the names built are parsed back
+	for _, name := range []string{TableFileName("db", 5), LogFileName("db", 5), DescriptorFileName("db", 5), TempFileName("db", 5)} {
+		if number, _, ok := ParseFileName(filepath.Base(name)); !ok || number != 5 {
+			t.Fatal("parse built file name error", name)
+		}
+	}
+
+	for _, name := range []string{"", "foo", "LOCK.old", "MANIFEST-", "MANIFEST-x", "MANIFEST-+1", "100", "100.", "100.txt",
+		"-1.log", "+1.log", "x.ldb", "18446744073709551616.log", "lost", "CURRENT.bak"} {
+		if _, _, ok := ParseFileName(name); ok {
+			t.Fatal("unknown file name is parsed", name)
+		}
+	}
+}
@@ -5,14 +5,19 @@
 //
 // InternalKey:
 //		the actual content in memtable which include UserKey and UserValue, Type and increasing Seq per key
+//
+// Encoding of InternalKey, which is the same as memtable entry of leveldb:
+//		internal key size: varint32 of len(UserKey) + 8
+//		UserKey: byte[len(UserKey)]
+//		tag: fixed64 of Seq << 8 | Type
+//		value size: varint32 of len(UserValue)
+//		UserValue: byte[len(UserValue)]
 
 package internal
 
 import (
 	"bytes"
 	"encoding/binary"
-	"io"
-	"math"
 )
 
 type ValueType int8
@@ -22,6 +27,22 @@ const (
 	TypeValue    ValueType = 1
 )
 
+// sequence number is packed with value type into 64 bits, so only 56 bits are available for it
+
+const (
+	MaxSequenceNumber uint64 = (1 << 56) - 1
+)
+
+// @description: pack seq and type into a tag, the tag is greater if seq is greater
+
+func PackSequenceAndType(seq uint64, valueType ValueType) uint64 {
+	return seq<<8 | uint64(valueType)
+}
+
+func UnpackSequenceAndType(tag uint64) (uint64, ValueType) {
+	return tag >> 8, ValueType(tag & 0xff)
+}
+
 type InternalKey struct {
 	Seq       uint64
 	Type      ValueType
@@ -45,68 +66,120 @@ func NewInternalKey(seq uint64, valueType ValueType, key, value []byte) *Interna
 	return &internalKey
 }
 
-// cannot use do while(false) trick in golang, awful
-// @description: encode a internal key in little endian binary to a writable object which has a Write(p []byte) (n int, err error) function
-// 				 the write sequence is seq -> type -> length of key -> key without '/0' -> length of data -> data without '/0'
-// @return the error
+// @description: the length of encoded internal key
+//				 the encoding sequence is length of key with tag -> key -> tag of seq and type -> length of value -> value
 
-func (key *InternalKey) EncodeTo(w io.Writer) error {
-	var err error = nil
-
-	// the binary.Write function write the data in the variable of interface{} into the writer in liitle endian
-	write := func(w io.Writer, data interface{}) {
-		if err != nil {
-			return
-		}
-		err = binary.Write(w, binary.LittleEndian, data)
-	}
-
-	// the internal implementation is based on type switch
-	write(w, key.Seq)
-	write(w, key.Type)
+func (key *InternalKey) EncodedLen() int {
+	internalKeySize := len(key.UserKey) + 8
+	return uvarintLen(uint64(internalKeySize)) + internalKeySize + uvarintLen(uint64(len(key.UserValue))) + len(key.UserValue)
+}
 
-	// the length of UserKey must be serialized in internal key
-	write(w, int32(len(key.UserKey)))
-	write(w, key.UserKey)
+// @description: encode a internal key into a byte slice without allocation
+// @param: the byte slice which must be at least EncodedLen() bytes
+// @return: the number of bytes written
+
+func (key *InternalKey) EncodeTo(p []byte) int {
+	n := binary.PutUvarint(p, uint64(len(key.UserKey)+8))
+	n += copy(p[n:], key.UserKey)
+	binary.LittleEndian.PutUint64(p[n:], PackSequenceAndType(key.Seq, key.Type))
+	n += 8
+	n += binary.PutUvarint(p[n:], uint64(len(key.UserValue)))
+	n += copy(p[n:], key.UserValue)
+	return n
+}
 
-	// the length of UserValue must be serialized in internal key
-	write(w, int32(len(key.UserValue)))
-	write(w, key.UserValue)
+// @description: append encoded internal key to dst, no allocation if dst has enough capacity
+// @return: the extended byte slice
 
-	return err
+func (key *InternalKey) AppendTo(dst []byte) []byte {
+	n := len(dst)
+	length := key.EncodedLen()
+	if cap(dst)-n < length {
+		tmp := make([]byte, n, 2*cap(dst)+length)
+		copy(tmp, dst)
+		dst = tmp
+	}
+	dst = dst[:n+length]
+	key.EncodeTo(dst[n:])
+	return dst
 }
 
-// cannot use do while(false) trick in golang, awful
-// description: decode an internal key from a readable object
-// 				the read sequence is seq -> type -> length of key -> key without '/0' -> length of data -> data without '/0'
-// return: the error
+// @description: decode an internal key from a byte slice without allocation
+// @param: the byte slice, UserKey and UserValue of the key refer to it after decoding
+// @return: the number of bytes read and error if the byte slice is malformed
+
+func (key *InternalKey) DecodeFrom(p []byte) (int, error) {
+	internalKey, n, ok := getLengthPrefixed(p)
+	if !ok || len(internalKey) < 8 {
+		return 0, ErrCorruption
+	}
+	value, m, ok := getLengthPrefixed(p[n:])
+	if !ok {
+		return 0, ErrCorruption
+	}
 
-func (key *InternalKey) DecodeFrom(r io.Reader) error {
-	var err error
+	userKeySize := len(internalKey) - 8
+	key.Seq, key.Type = UnpackSequenceAndType(binary.LittleEndian.Uint64(internalKey[userKeySize:]))
+	key.UserKey = internalKey[:userKeySize:userKeySize]
+	key.UserValue = value
+	return n + m, nil
+}
 
-	// the binary.Read function read the data from reader in little endian and put it into a interface{} variable
-	read := func(r io.Reader, data interface{}) {
-		if err != nil {
-			return
-		}
+// @description: decode an internal key in legacy encoding without allocation, which is written by goveldb before keys are varint encoded
+//				 the encoding sequence is fixed64 seq -> int8 type -> fixed32 length of key -> key -> fixed32 length of value -> value
+// @param: the byte slice, UserKey and UserValue of the key refer to it after decoding
+// @return: the number of bytes read and error if the byte slice is malformed
 
-		err = binary.Read(r, binary.LittleEndian, data)
+func (key *InternalKey) DecodeLegacyFrom(p []byte) (int, error) {
+	if len(p) < 9 {
+		return 0, ErrCorruption
+	}
+	userKey, n, ok := getFixed32Prefixed(p[9:])
+	if !ok {
+		return 0, ErrCorruption
+	}
+	value, m, ok := getFixed32Prefixed(p[9+n:])
+	if !ok {
+		return 0, ErrCorruption
 	}
 
-	read(r, &key.Seq)
-	read(r, &key.Type)
+	key.Seq = binary.LittleEndian.Uint64(p)
+	key.Type = ValueType(p[8])
+	key.UserKey = userKey
+	key.UserValue = value
+	return 9 + n + m, nil
+}
 
-	var tmpLen int32
-	read(r, &tmpLen)
+// @description: parse a byte slice prefixed by fixed32 length
+// @return: the byte slice whose capacity is limited to its length, bytes consumed and if it's well-formed
 
-	key.UserKey = make([]byte, tmpLen)
-	read(r, &key.UserKey)
+func getFixed32Prefixed(p []byte) ([]byte, int, bool) {
+	if len(p) < 4 || uint64(len(p)-4) < uint64(binary.LittleEndian.Uint32(p)) {
+		return nil, 0, false
+	}
+	end := 4 + int(binary.LittleEndian.Uint32(p))
+	return p[4:end:end], end, true
+}
 
-	read(r, &tmpLen)
-	key.UserValue = make([]byte, tmpLen)
-	read(r, &key.UserValue)
+// @description: parse a length prefixed byte slice
+// @return: the byte slice whose capacity is limited to its length, bytes consumed and if it's well-formed
 
-	return err
+func getLengthPrefixed(p []byte) ([]byte, int, bool) {
+	length, n := binary.Uvarint(p)
+	if n <= 0 || uint64(len(p)-n) < length {
+		return nil, 0, false
+	}
+	end := n + int(length)
+	return p[n:end:end], end, true
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
 }
 
 // @description: k-v pairs of leveldb are stored in skip list by InternalKey, when user need to index certain key, a temporary key need to be constructed,
@@ -114,32 +187,104 @@ func (key *InternalKey) DecodeFrom(r io.Reader) error {
 // @return: A InternalKey to lookup in skip list
 
 func LookupKey(key []byte) *InternalKey {
-	return NewInternalKey(math.MaxUint64, TypeValue, key, nil)
+	return &InternalKey{
+		Seq:     MaxSequenceNumber,
+		Type:    TypeValue,
+		UserKey: key,
+	}
 }
 
 // @description: this function is to provide the rule of compare between two UserKey,
 // @return: +1 if a > b, -1 if a < b, 0 if a == b
 // @note: a, b would be compared lexicographically
 
-func UserKeyComparator(a, b interface{}) int {
-	return bytes.Compare(a.([]byte), b.([]byte))
+func UserKeyComparator(a, b []byte) int {
+	return bytes.Compare(a, b)
 }
 
 // @description: this function is to provide the rule of compare between two InternalKey,
 // @return: +1 if a > b, -1 if a < b
 // 			if a == b, the node with greater seq is lesser
 
-func InternalKeyComparator(a, b interface{}) int {
-	aKey := a.(*InternalKey)
-	bKey := b.(*InternalKey)
-	r := UserKeyComparator(aKey.UserKey, bKey.UserKey)
+func InternalKeyComparator(a, b *InternalKey) int {
+	r := UserKeyComparator(a.UserKey, b.UserKey)
 	if r == 0 {
-		if aKey.Seq > bKey.Seq {
+		if a.Seq > b.Seq {
 			r = -1
-		} else if aKey.Seq < bKey.Seq {
+		} else if a.Seq < b.Seq {
 			r = 1
 		}
 	}
 
 	return r
 }
+
+// @description: compare two encoded internal keys without decoding values
+// @return: +1 if a > b, -1 if a < b, 0 if a == b
+// 			user key is in ascending order and tag is in descending order
+
+func EncodedKeyComparator(a, b []byte) int {
+	aKey, _, _ := getLengthPrefixed(a)
+	bKey, _, _ := getLengthPrefixed(b)
+
+	r := bytes.Compare(aKey[:len(aKey)-8], bKey[:len(bKey)-8])
+	if r == 0 {
+		aTag := binary.LittleEndian.Uint64(aKey[len(aKey)-8:])
+		bTag := binary.LittleEndian.Uint64(bKey[len(bKey)-8:])
+		if aTag > bTag {
+			r = -1
+		} else if aTag < bTag {
+			r = +1
+		}
+	}
+
+	return r
+}
+
+// @description: find a short key in [start, limit), it's used as the key of index block instead of the last key of a data block
+// @param: the last key of a data block and the first key of the next data block
+// @return: the shorter key with max seq if there's one, start otherwise
+// @note: the same as InternalKeyComparator::FindShortestSeparator of leveldb with bytewise comparator
+
+func FindShortestSeparator(start, limit *InternalKey) *InternalKey {
+	minLength := len(start.UserKey)
+	if len(limit.UserKey) < minLength {
+		minLength = len(limit.UserKey)
+	}
+	diffIndex := 0
+	for diffIndex < minLength && start.UserKey[diffIndex] == limit.UserKey[diffIndex] {
+		diffIndex++
+	}
+
+	// one key is the prefix of the other, or the different bytes are adjacent
+	if diffIndex >= minLength || start.UserKey[diffIndex] == 0xff || start.UserKey[diffIndex]+1 >= limit.UserKey[diffIndex] {
+		return start
+	}
+	separator := append([]byte(nil), start.UserKey[:diffIndex+1]...)
+	separator[diffIndex]++
+	return shorterKey(start, separator)
+}
+
+// @description: find a short key not less than key, it's used as the key of index block for the last data block
+// @return: the shorter key with max seq if there's one, key otherwise
+// @note: the same as InternalKeyComparator::FindShortSuccessor of leveldb with bytewise comparator
+
+func FindShortSuccessor(key *InternalKey) *InternalKey {
+	for i, c := range key.UserKey {
+		if c != 0xff {
+			successor := append([]byte(nil), key.UserKey[:i+1]...)
+			successor[i]++
+			return shorterKey(key, successor)
+		}
+	}
+	return key
+}
+
+// the user key is replaced only if it's physically shorter and logically larger, and it comes before all of the entries with the same user key by max seq
+
+func shorterKey(key *InternalKey, userKey []byte) *InternalKey {
+	if len(userKey) < len(key.UserKey) && UserKeyComparator(key.UserKey, userKey) < 0 {
+		return NewInternalKey(MaxSequenceNumber, TypeValue, userKey, nil)
+	}
+	return key
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_InternalKey_Encoding(t *testing.T) {
+	key1 := NewInternalKey(MaxSequenceNumber, TypeValue, []byte("123"), []byte("456"))
+	p := key1.AppendTo(nil)
+	if len(p) != key1.EncodedLen() {
+		t.Fatal("encoded length error")
+	}
+
+	var key2 InternalKey
+	n, err := key2.DecodeFrom(p)
+	if err != nil || n != len(p) {
+		t.Fatal("decode fail")
+	}
+	if reflect.DeepEqual(*key1, key2) == false {
+		t.Fatal("encode decode fail")
+	}
+
+	if _, err := key2.DecodeFrom(p[:len(p)-1]); err != ErrCorruption {
+		t.Fatal("truncated key is decoded")
+	}
+
+	// encoding and decoding into caller's buffer don't allocate
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = key1.AppendTo(buf[:0])
+		_, _ = key2.DecodeFrom(buf)
+	})
+	if allocs != 0 {
+		t.Fatal("allocation in encoding", allocs)
+	}
+}
+
+func Test_EncodedKeyComparator(t *testing.T) {
+	a := NewInternalKey(2, TypeValue, []byte("123"), []byte("x")).AppendTo(nil)
+	b := NewInternalKey(1, TypeDeletion, []byte("123"), nil).AppendTo(nil)
+	c := NewInternalKey(3, TypeValue, []byte("124"), nil).AppendTo(nil)
+
+	// newer one of the same user key is smaller
+	if EncodedKeyComparator(a, b) >= 0 || EncodedKeyComparator(b, c) >= 0 || EncodedKeyComparator(c, a) <= 0 {
+		t.Fatal("compare error")
+	}
+	if EncodedKeyComparator(a, a) != 0 {
+		t.Fatal("compare error")
+	}
+}
+
+func Test_InternalKey_Separator(t *testing.T) {
+	key := func(userKey string, seq uint64) *InternalKey {
+		return NewInternalKey(seq, TypeValue, []byte(userKey), nil)
+	}
+
+	// the different byte is increased and the rest is dropped
+	if sep := FindShortestSeparator(key("apple", 1), key("cherry", 2)); string(sep.UserKey) != "b" || sep.Seq != MaxSequenceNumber {
+		t.Fatal("separator error", sep)
+	}
+	if sep := FindShortestSeparator(key("foo1000", 1), key("foo3000", 2)); string(sep.UserKey) != "foo2" {
+		t.Fatal("separator error", sep)
+	}
+
+	// no shorter key between them
+	for _, limit := range []*InternalKey{key("apple", 1), key("applf", 1), key("apples", 1), key("b", 1)} {
+		start := key("apple", 2)
+		if sep := FindShortestSeparator(start, limit); sep != start {
+			t.Fatal("separator error", string(limit.UserKey), sep)
+		}
+	}
+	start := key("a\xffz", 2)
+	if sep := FindShortestSeparator(start, key("b", 1)); sep != start {
+		t.Fatal("separator error", sep)
+	}
+
+	if succ := FindShortSuccessor(key("cherry", 1)); string(succ.UserKey) != "d" || succ.Seq != MaxSequenceNumber {
+		t.Fatal("successor error", succ)
+	}
+	if succ := FindShortSuccessor(key("\xff\xffab", 1)); string(succ.UserKey) != "\xff\xffb" {
+		t.Fatal("successor error", succ)
+	}
+
+	// the successor isn't shorter
+	for _, last := range []*InternalKey{key("\xff\xffa", 1), key("\xff\xff", 1)} {
+		if succ := FindShortSuccessor(last); succ != last {
+			t.Fatal("successor error", succ)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package internal
+
+// InternalIterator iterates internal keys in the order of InternalKeyComparator
+// it's implemented by iterators of memtable, block, sstable, level and merging iterator,
+// so that they can be merged and stacked on each other
+
+type InternalIterator interface {
+	// Returns true if the iterator is positioned at a valid entry.
+	Valid() bool
+
+	// Returns the internal key with value at the current position.
+	// REQUIRES: Valid()
+	InternalKey() *InternalKey
+
+	// Advances to the next position.
+	// REQUIRES: Valid()
+	Next()
+
+	// Advances to the previous position.
+	// REQUIRES: Valid()
+	Prev()
+
+	// Position at the newest entry whose user key >= target
+	Seek(target []byte)
+
+	// Position at the first entry.
+	// Final state of iterator is Valid() iff it's not empty.
+	SeekToFirst()
+
+	// Position at the last entry.
+	// Final state of iterator is Valid() iff it's not empty.
+	SeekToLast()
+
+	// Returns the error encountered, such as read failure or corruption, nil if there's none.
+	// An iterator with error may be invalid before reaching its end.
+	Error() error
+
+	// Release the resources held by iterator, it must not be used after that.
+	Close()
+}
+
+// emptyIterator contains nothing, which reports an error if any
+
+type emptyIterator struct {
+	err error
+}
+
+// @description: create an iterator over nothing, such as the iterator of a table which fails to open
+// @param: the error reported by the iterator, nil if there's none
+
+func NewEmptyIterator(err error) InternalIterator {
+	return &emptyIterator{err: err}
+}
+
+func (iter *emptyIterator) Valid() bool {
+	return false
+}
+
+func (iter *emptyIterator) InternalKey() *InternalKey {
+	return nil
+}
+
+func (iter *emptyIterator) Next() {}
+
+func (iter *emptyIterator) Prev() {}
+
+func (iter *emptyIterator) Seek(target []byte) {}
+
+func (iter *emptyIterator) SeekToFirst() {}
+
+func (iter *emptyIterator) SeekToLast() {}
+
+func (iter *emptyIterator) Error() error {
+	return iter.err
+}
+
+func (iter *emptyIterator) Close() {}
@@ -0,0 +1,144 @@
+// Logger reports internal events of database such as flush and compaction, each message has a level and key/value fields
+// The default logger of database writes to the LOG file in database directory, and the previous LOG is renamed to LOG.old on open like leveldb
+// A line of the text logger is like:
+//		2006/01/02-15:04:05.000000 INFO compaction begin level=0 inputs=[5 6]
+
+package logger
+
+import (
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/internal"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (level Level) String() string {
+	switch level {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warn:
+		return "WARN"
+	case Error:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(level))
+}
+
+type Logger interface {
+	// Log a message with fields, keyvals are alternating keys and values, such as "level", 1, "file", 5
+	// It may be called concurrently
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+type discard struct{}
+
+func (discard) Log(Level, string, ...interface{}) {}
+
+// Discard drops all messages
+
+var Discard Logger = discard{}
+
+type textLogger struct {
+	mu       sync.Mutex // lines of concurrent calls are not interleaved
+	w        io.Writer
+	minLevel Level // messages below it are dropped
+}
+
+// @description: create a logger writing a line of text for each message
+// @param: the writer and the lowest level of messages to write
+
+func New(w io.Writer, minLevel Level) Logger {
+	return &textLogger{
+		w:        w,
+		minLevel: minLevel,
+	}
+}
+
+func (l *textLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.minLevel {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(time.Now().Format("2006/01/02-15:04:05.000000"))
+	b.WriteByte(' ')
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(formatValue(keyvals[i]))
+		b.WriteByte('=')
+		if i+1 < len(keyvals) {
+			b.WriteString(formatValue(keyvals[i+1]))
+		} else {
+			b.WriteString("MISSING")
+		}
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	_, _ = io.WriteString(l.w, b.String())
+	l.mu.Unlock()
+}
+
+// @description: format a field value, keys of database and strings with spaces are quoted
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return fmt.Sprintf("%q", v)
+	case string:
+		if strings.ContainsAny(v, " =\"") || v == "" {
+			return fmt.Sprintf("%q", v)
+		}
+		return v
+	case error:
+		return fmt.Sprintf("%q", v.Error())
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// FileLogger is the default logger of database, which writes to the LOG file in database directory
+
+type FileLogger struct {
+	Logger
+	file env.WritableFile
+}
+
+// @description: create a logger writing to the LOG file in database directory, the existing one is renamed to LOG.old
+// @param: the env which the LOG file is created in, the database name and the lowest level of messages to write
+// @return: the logger and error if the LOG file can't be created
+
+func NewFileLogger(e env.Env, dbName string, minLevel Level) (*FileLogger, error) {
+	fileName := internal.InfoLogFileName(dbName)
+	_ = e.RenameFile(fileName, internal.OldInfoLogFileName(dbName))
+
+	file, err := e.NewWritableFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLogger{
+		Logger: New(file, minLevel),
+		file:   file,
+	}, nil
+}
+
+func (l *FileLogger) Close() error {
+	return l.file.Close()
+}
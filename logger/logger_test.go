@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/internal"
+	"strings"
+	"testing"
+)
+
+func Test_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, Info)
+	l.Log(Debug, "dropped")
+	l.Log(Info, "compaction begin", "level", 0, "inputs", []uint64{5, 6}, "key", []byte("a b"))
+	l.Log(Error, "compaction aborted", "error", errors.New("Corruption"), "odd")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatal("debug message is not dropped", lines)
+	}
+	if !strings.HasSuffix(lines[0], ` INFO compaction begin level=0 inputs=[5 6] key="a b"`) {
+		t.Fatal("info message format error", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], ` ERROR compaction aborted error="Corruption" odd=MISSING`) {
+		t.Fatal("error message format error", lines[1])
+	}
+}
+
+func Test_FileLogger(t *testing.T) {
+	fs := env.NewMemEnv()
+	dbName := "db"
+	_ = fs.CreateDir(dbName)
+	for _, msg := range []string{"first", "second"} {
+		l, err := NewFileLogger(fs, dbName, Info)
+		if err != nil {
+			t.Fatal("create file logger fail", err)
+		}
+		l.Log(Info, msg)
+		_ = l.Close()
+	}
+
+	// the previous LOG is renamed to LOG.old
+	p, _ := env.ReadFile(fs, internal.InfoLogFileName(dbName))
+	old, _ := env.ReadFile(fs, internal.OldInfoLogFileName(dbName))
+	if !strings.Contains(string(p), "INFO second") || !strings.Contains(string(old), "INFO first") {
+		t.Fatal("LOG is not rotated", string(p), string(old))
+	}
+}
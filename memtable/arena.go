@@ -0,0 +1,47 @@
+package memtable
+
+// Arena allocates memory for memtable entries in large blocks instead of many tiny objects, which keeps gc from scanning them one by one
+// Memory allocated from arena is never freed until the whole arena is dropped with memtable
+
+import (
+	"sync/atomic"
+)
+
+const (
+	kArenaBlockSize = 32 << 10
+)
+
+type Arena struct {
+	block       []byte // the unused part of current block
+	memoryUsage uint64 // total size of blocks allocated, it's read without lock while the writer allocates
+}
+
+// @description: allocate a byte slice of n bytes from arena
+// @return: the byte slice whose capacity is limited to n, so appending to it never overwrites other allocation
+
+func (arena *Arena) Allocate(n int) []byte {
+	if n > len(arena.block) {
+		// object is more than a quarter of block size, allocate it separately to avoid wasting too much space in leftover
+		if n > kArenaBlockSize/4 {
+			return arena.allocateNewBlock(n)
+		}
+
+		// waste the remaining space in current block
+		arena.block = arena.allocateNewBlock(kArenaBlockSize)
+	}
+
+	p := arena.block[:n:n]
+	arena.block = arena.block[n:]
+	return p
+}
+
+func (arena *Arena) allocateNewBlock(size int) []byte {
+	atomic.AddUint64(&arena.memoryUsage, uint64(size))
+	return make([]byte, size)
+}
+
+// @description: return the total memory allocated by arena, including the unused space of blocks
+
+func (arena *Arena) MemoryUsage() uint64 {
+	return atomic.LoadUint64(&arena.memoryUsage)
+}
@@ -5,16 +5,23 @@ import (
 	"github.com/jo3yzhu/goveldb/skiplist"
 )
 
-type Iterator struct  {
-	listIterator *skiplist.Iterator
+var _ internal.InternalIterator = (*Iterator)(nil)
+
+type Iterator struct {
+	listIterator *skiplist.Iterator[[]byte]
 }
 
 func (iter *Iterator) Valid() bool {
 	return iter.listIterator.Valid()
 }
 
+// @description: decode the current entry into an internal key
+// @note: UserKey and UserValue of the result refer to memory in arena, they must not be modified
+
 func (iter *Iterator) InternalKey() *internal.InternalKey {
-	return iter.listIterator.Key().(*internal.InternalKey)
+	var entry internal.InternalKey
+	_, _ = entry.DecodeFrom(iter.listIterator.Key())
+	return &entry
 }
 
 func (iter *Iterator) Next() {
@@ -25,8 +32,10 @@ func (iter *Iterator) Prev() {
 	iter.listIterator.Prev()
 }
 
-func (iter *Iterator) Seek(target interface{}) {
-	iter.listIterator.Seek(target)
+// @description: seek to the newest entry whose user key >= target
+
+func (iter *Iterator) Seek(target []byte) {
+	iter.listIterator.Seek(lookupEntry(target))
 }
 
 func (iter *Iterator) SeekToFirst() {
@@ -35,4 +44,10 @@ func (iter *Iterator) SeekToFirst() {
 
 func (iter *Iterator) SeekToLast() {
 	iter.listIterator.SeekToLast()
-}
\ No newline at end of file
+}
+
+func (iter *Iterator) Error() error {
+	return nil
+}
+
+func (iter *Iterator) Close() {}
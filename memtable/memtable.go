@@ -1,40 +1,58 @@
+// Entries of memtable are internal keys encoded in place in arena, see encoding of InternalKey
+// skip list only stores the byte slices of entries, so there's no need to allocate InternalKey and copied slices per entry
+
 package memtable
 
 import (
+	"bytes"
 	"github.com/jo3yzhu/goveldb/internal"
 	"github.com/jo3yzhu/goveldb/skiplist"
 )
 
 type MemTable struct {
-	table       *skiplist.SkipList
-	memoryUsage uint64
+	table *skiplist.SkipList[[]byte]
+	arena Arena
 }
 
 func New() *MemTable {
 	memtable := MemTable{
-		table: skiplist.New(internal.InternalKeyComparator),
+		table: skiplist.New(internal.EncodedKeyComparator),
 	}
 	return &memtable
 }
 
+// @description: make an entry to seek the newest entry of key, which is the smallest one among entries with the same key
+
+func lookupEntry(key []byte) []byte {
+	return internal.LookupKey(key).AppendTo(nil)
+}
+
+// @description: add an entry to memtable
+// @note: REQUIRES: writers are synchronized externally
+
 func (memTable *MemTable) Add(seq uint64, valueType internal.ValueType, key, value []byte) {
-	internalKey := internal.NewInternalKey(seq, valueType, key, value)
-	memTable.memoryUsage += uint64(16 + len(key) + len(value))
-	memTable.table.Insert(internalKey)
+	entry := internal.InternalKey{
+		Seq:       seq,
+		Type:      valueType,
+		UserKey:   key,
+		UserValue: value,
+	}
+	p := memTable.arena.Allocate(entry.EncodedLen())
+	entry.EncodeTo(p)
+	memTable.table.Insert(p)
 }
 
 func (memTable *MemTable) Get(key []byte) ([]byte, error) {
-	// lookupKey is a key with max sequential number which means it's the smallest one in nodes with the same UserKey
-	lookupKey := internal.LookupKey(key)
 	iter := memTable.table.NewIterator()
 
-	// Seek by lookupKey is to find the newest key with smallest sequential number
-	iter.Seek(lookupKey)
+	// Seek by lookup entry is to find the newest key with biggest sequential number
+	iter.Seek(lookupEntry(key))
 	if iter.Valid() {
-		internalKey := iter.Key().(*internal.InternalKey)
-		if internal.UserKeyComparator(key, internalKey.UserKey) == 0 {
-			if internalKey.Type == internal.TypeValue {
-				return internalKey.UserValue, nil
+		var entry internal.InternalKey
+		_, _ = entry.DecodeFrom(iter.Key())
+		if bytes.Equal(key, entry.UserKey) {
+			if entry.Type == internal.TypeValue {
+				return entry.UserValue, nil
 			} else {
 				return nil, internal.ErrDeletion // key doesn't exist
 			}
@@ -44,8 +62,10 @@ func (memTable *MemTable) Get(key []byte) ([]byte, error) {
 	return nil, internal.ErrNotFound
 }
 
+// @description: return the memory allocated by arena and skip list nodes
+
 func (memTable *MemTable) ApproximateMemoryUsage() uint64 {
-	return memTable.memoryUsage
+	return memTable.arena.MemoryUsage() + memTable.table.MemoryUsage()
 }
 
 func (memTable *MemTable) NewIterator() *Iterator {
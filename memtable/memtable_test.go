@@ -1,6 +1,7 @@
 package memtable
 
 import (
+	"fmt"
 	"github.com/jo3yzhu/goveldb/internal"
 	"testing"
 )
@@ -17,4 +18,45 @@ func TestMemTable(t *testing.T) {
 	}
 
 	t.Log("memory usage:", memTable.ApproximateMemoryUsage())
-}
\ No newline at end of file
+}
+func TestMemTable_Iterator(t *testing.T) {
+	memTable := New()
+	memTable.Add(1, internal.TypeValue, []byte("124"), []byte("v1"))
+	memTable.Add(2, internal.TypeValue, []byte("123"), []byte("v2"))
+	memTable.Add(3, internal.TypeDeletion, []byte("124"), nil)
+
+	// user key in ascending order and seq in descending order
+	expected := []struct {
+		key string
+		seq uint64
+	}{{"123", 2}, {"124", 3}, {"124", 1}}
+
+	iter := memTable.NewIterator()
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if string(iter.InternalKey().UserKey) != expected[i].key || iter.InternalKey().Seq != expected[i].seq {
+			t.Fatal("traverse error")
+		}
+		i++
+	}
+
+	if _, err := memTable.Get([]byte("124")); err != internal.ErrDeletion {
+		t.Fatal("deleted key is found")
+	}
+}
+
+func TestMemTable_MemoryUsage(t *testing.T) {
+	memTable := New()
+	var size uint64
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("%08d", i))
+		memTable.Add(uint64(i), internal.TypeValue, key, key)
+		size += uint64(2 * len(key))
+	}
+
+	// arena and nodes are accounted, node overhead is large compared with such tiny entries
+	usage := memTable.ApproximateMemoryUsage()
+	if usage < size || usage > 8*size {
+		t.Fatal("memory usage error", usage, size)
+	}
+}
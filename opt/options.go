@@ -0,0 +1,162 @@
+// Options to control the behavior of database, they are shared by db, version and sstable
+// nil options mean the default ones, so getters of options are nil-safe
+
+package opt
+
+import (
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/event"
+	"github.com/jo3yzhu/goveldb/filter"
+	"github.com/jo3yzhu/goveldb/logger"
+	"github.com/jo3yzhu/goveldb/statistics"
+)
+
+type Options struct {
+	// If true, sstable, manifest and CURRENT file are read and written in the on-disk format of leveldb cpp version,
+	// so that a leveldb database can be opened by goveldb without export/import and vice versa
+	// Log files are always in leveldb format
+	LevelDBCompatible bool
+
+	// If not nil, prefixes of keys extracted by it are added to the bloom filter of sstable,
+	// so that sstables without certain prefix can be skipped when iterating with ReadOptions.PrefixSameAsStart
+	PrefixExtractor filter.PrefixExtractor
+
+	// Internal events such as flush and compaction are logged to it
+	// If nil, they are logged to the LOG file in database directory, messages below logger.Info are dropped
+	InfoLog logger.Logger
+
+	// Listeners notified of flush, compaction, creation and deletion of table files, write stalls and background errors
+	EventListeners []event.Listener
+
+	// If not nil, tickers and histograms of database are collected into it, it may be shared by several databases
+	Statistics *statistics.Statistics
+
+	// All files of database are accessed through it, such as env.NewMemEnv() for tests which don't touch disk
+	// If nil, env.Default() backed by the os file system is used
+	Env env.Env
+
+	// If true, database is checked aggressively and fails with internal.ErrCorruption once anything is wrong:
+	// Open verifies that every table in manifest has the recorded size and files in each level >= 1 are sorted without overlap,
+	// and the outputs of each compaction are reopened and fully read before they're installed
+	// Blocks are always checked by crc when they're read, except the ones of native tables written before blocks have checksum
+	ParanoidChecks bool
+}
+
+func (o *Options) GetLevelDBCompatible() bool {
+	if o == nil {
+		return false
+	}
+	return o.LevelDBCompatible
+}
+
+func (o *Options) GetPrefixExtractor() filter.PrefixExtractor {
+	if o == nil {
+		return nil
+	}
+	return o.PrefixExtractor
+}
+
+// @return: the info logger, logger.Discard if it's not set
+
+func (o *Options) GetInfoLog() logger.Logger {
+	if o == nil || o.InfoLog == nil {
+		return logger.Discard
+	}
+	return o.InfoLog
+}
+
+func (o *Options) GetEventListeners() event.Listeners {
+	if o == nil {
+		return nil
+	}
+	return o.EventListeners
+}
+
+// @return: the statistics, nil if it's not set, which records nothing
+
+func (o *Options) GetStatistics() *statistics.Statistics {
+	if o == nil {
+		return nil
+	}
+	return o.Statistics
+}
+
+func (o *Options) GetParanoidChecks() bool {
+	if o == nil {
+		return false
+	}
+	return o.ParanoidChecks
+}
+
+// @return: the env, env.Default() if it's not set
+
+func (o *Options) GetEnv() env.Env {
+	if o == nil || o.Env == nil {
+		return env.Default()
+	}
+	return o.Env
+}
+
+// WriteOptions control the behavior of a write operation
+
+type WriteOptions struct {
+	// If true, the write will be flushed from the operating system buffer cache before the write is considered complete
+	// If this flag is false, and the machine crashes, some recent writes may be lost
+	Sync bool
+}
+
+func (o *WriteOptions) GetSync() bool {
+	if o == nil {
+		return false
+	}
+	return o.Sync
+}
+
+// ReadOptions control the behavior of a read operation
+
+type ReadOptions struct {
+	// If not nil, iteration starts from the first key >= LowerBound, keys before it are invisible
+	LowerBound []byte
+
+	// If not nil, iteration stops before the first key >= UpperBound, keys after it are invisible
+	// sstable files and blocks entirely out of the bounds are not read
+	UpperBound []byte
+
+	// If true, an iterator positioned by Seek only yields keys with the same prefix as the target,
+	// and sstables whose prefix bloom filter says the prefix is absent are skipped
+	// It takes effect only if Options.PrefixExtractor is set, and the target without prefix is iterated in total order
+	// The iterator only moves forward in this mode, Prev, SeekToFirst and SeekToLast fail with ErrNotSupported
+	PrefixSameAsStart bool
+
+	// If not nil, the reads of operation are traced into it, such as files consulted and blocks read
+	// It's not safe for concurrent use, so the read options with it should be used by one goroutine at a time
+	PerfContext *statistics.PerfContext
+}
+
+func (o *ReadOptions) GetLowerBound() []byte {
+	if o == nil {
+		return nil
+	}
+	return o.LowerBound
+}
+
+func (o *ReadOptions) GetUpperBound() []byte {
+	if o == nil {
+		return nil
+	}
+	return o.UpperBound
+}
+
+func (o *ReadOptions) GetPrefixSameAsStart() bool {
+	if o == nil {
+		return false
+	}
+	return o.PrefixSameAsStart
+}
+
+func (o *ReadOptions) GetPerfContext() *statistics.PerfContext {
+	if o == nil {
+		return nil
+	}
+	return o.PerfContext
+}
@@ -1,34 +1,27 @@
 package skiplist
 
-type Iterator struct {
-	list *SkipList
-	node *Node
+type Iterator[K any] struct {
+	list *SkipList[K]
+	node *Node[K]
 }
 
-func (iter *Iterator) Valid() bool {
+func (iter *Iterator[K]) Valid() bool {
 	return iter.node != nil
 }
 
-func (iter *Iterator) Key() interface{} {
+func (iter *Iterator[K]) Key() K {
 	return iter.node.key
 }
 
-
 // @description: make the iterator step to next in level 0
 
-func (iter *Iterator) Next() {
-	iter.list.mu.RLock()
-	defer iter.list.mu.RUnlock()
-
-	iter.node = iter.node.getNext(0);
+func (iter *Iterator[K]) Next() {
+	iter.node = iter.node.getNext(0)
 }
 
 // @description: make the iterator step to previous in level 0
 
-func (iter *Iterator) Prev() {
-	iter.list.mu.RLock()
-	defer iter.list.mu.RUnlock()
-
+func (iter *Iterator[K]) Prev() {
 	iter.node = iter.list.findLessThan(iter.node.key)
 	if iter.node == iter.list.head {
 		iter.node = nil
@@ -38,30 +31,21 @@ func (iter *Iterator) Prev() {
 // @description: make the iterator step to the lower bound in level 0
 // @notice: caller should ensure that if find the corresponding node
 
-func (iter *Iterator) Seek(key interface{}) {
-	iter.list.mu.RLock()
-	defer iter.list.mu.RUnlock()
-
+func (iter *Iterator[K]) Seek(key K) {
 	iter.node, _ = iter.list.findGreaterOrEqual(key)
 }
 
 // @description: make the iterator seek to first in level 0
 
-func (iter *Iterator) SeekToFirst() {
-	iter.list.mu.RLock()
-	defer iter.list.mu.RUnlock()
-
+func (iter *Iterator[K]) SeekToFirst() {
 	iter.node = iter.list.head.getNext(0)
 }
 
 // @description: make the iterator seek to last in level 0
 
-func (iter *Iterator) SeekToLast() {
-	iter.list.mu.RLock()
-	defer iter.list.mu.RUnlock()
-
+func (iter *Iterator[K]) SeekToLast() {
 	iter.node = iter.list.findLast()
 	if iter.node == iter.list.head {
 		iter.node = nil
 	}
-}
\ No newline at end of file
+}
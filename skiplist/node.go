@@ -1,33 +1,42 @@
 package skiplist
 
+import (
+	"sync/atomic"
+)
+
 // this is the real-world implementation of skip list based on singly link list which has no need to store a node twice
 // notice: each node has pointers of other nodes whose num equals level of skip list
 // the 1st pointer links to next node in level 0
 // the 2nd pointer links to next node in level 1
 // the 3th pointer links to next node in level 2
 // ...
+// pointers are loaded and stored atomically, so a node is published to readers only after it's fully initialized
 
-type Node struct {
-	key  interface{} // key can be any type and stored only once
-	next []*Node     // slice of *Node, next[i] means next node in level i
+type Node[K any] struct {
+	key  K                         // key is stored only once
+	next []atomic.Pointer[Node[K]] // next[i] means next node in level i
 }
 
-func newNode(key interface{}, height int) *Node {
-	x := Node{
+func newNode[K any](key K, height int) *Node[K] {
+	x := Node[K]{
 		key:  key,
-		next: make([]*Node, height),
+		next: make([]atomic.Pointer[Node[K]], height),
 	}
 
 	return &x
 }
 
 // get next node in level n
-func (node *Node) getNext(level int) *Node {
-	return node.next[level]
+func (node *Node[K]) getNext(level int) *Node[K] {
+	return node.next[level].Load()
 }
 
-
 // set next node in level n
-func (node *Node) setNext(level int, n *Node) {
-	node.next[level] = n
+func (node *Node[K]) setNext(level int, n *Node[K]) {
+	node.next[level].Store(n)
+}
+
+// set next node in level n only if it's still old
+func (node *Node[K]) casNext(level int, old, n *Node[K]) bool {
+	return node.next[level].CompareAndSwap(old, n)
 }
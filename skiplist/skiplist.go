@@ -1,13 +1,17 @@
-// This package provide a insert/iteration only implementation of thread-safe skip list by RWMutex.
-// The function of this skip list is limited because original implementation in leveldb cpp version is lock-free, and lock-free skip list algorithm leaves many problems such as memory management unsolved in cpp
-// And in my first version, I use RWMutex to keep it thread-safe
+// This package provide a insert/iteration only implementation of lock-free skip list like the one in leveldb cpp version.
+// Nodes are never deleted, so memory management of lock-free algorithm is left to gc, and a node is published by atomic pointer store after it's initialized.
+// Keys can be of any type sorted by a typed comparator, so it can be used as an ordered in-memory map besides memtable.
+// Thread safety:
+//		Insert requires external synchronization among writers, but readers never lock and can run concurrently with the writer
+//		InsertConcurrently can be called by multiple writers at the same time, it links nodes by CAS
 
 package skiplist
 
 import (
 	"github.com/jo3yzhu/goveldb/utils"
 	"math/rand"
-	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
 const (
@@ -15,58 +19,118 @@ const (
 	kBranching = 4
 )
 
-type SkipList struct {
-	maxHeight  int
-	head       *Node
-	comparator utils.Comparator // duck type comparator
-	mu         sync.RWMutex
+type SkipList[K any] struct {
+	maxHeight   int32 // modified by writers and read by readers atomically
+	head        *Node[K]
+	comparator  utils.Comparator[K]
+	memoryUsage int64 // memory allocated for nodes
 }
 
-func New(comp utils.Comparator) *SkipList {
-	skipList := SkipList{
+func New[K any](comp utils.Comparator[K]) *SkipList[K] {
+	var zero K
+	skipList := SkipList[K]{
 		maxHeight:  1,
-		head:       newNode(0, kMaxHeight),
+		head:       newNode(zero, kMaxHeight),
 		comparator: comp,
 	}
 	return &skipList
 }
 
+func (list *SkipList[K]) newNode(key K, height int) *Node[K] {
+	atomic.AddInt64(&list.memoryUsage, int64(unsafe.Sizeof(Node[K]{})+uintptr(height)*unsafe.Sizeof(unsafe.Pointer(nil))))
+	return newNode(key, height)
+}
+
+// @description: return the memory allocated for nodes, which doesn't include keys
+
+func (list *SkipList[K]) MemoryUsage() uint64 {
+	return uint64(atomic.LoadInt64(&list.memoryUsage))
+}
+
+func (list *SkipList[K]) getMaxHeight() int {
+	return int(atomic.LoadInt32(&list.maxHeight))
+}
+
 // @description: insert a new key to skip list
 // @param: the key to be inserted in skip list
-// @notice: user cannot insert a key already exists
-
-func (list *SkipList) Insert(key interface{}) {
-	list.mu.Lock() // write lock
-	defer list.mu.Unlock()
+// @notice: user cannot insert a key already exists, and writers must be synchronized externally
 
+func (list *SkipList[K]) Insert(key K) {
 	// the result node is ignored, so DON'T insert a key already exists
 	_, prev := list.findGreaterOrEqual(key)
 	height := list.randomHeight()
 
 	// new node maxHeight is greater than list maxHeight, then link the head and new node in exceed level
-	if height > list.maxHeight {
-		for i := list.maxHeight; i < height; i++ {
+	// it's ok for readers to see the new maxHeight before the new node is linked, they will see nil in head
+	if maxHeight := list.getMaxHeight(); height > maxHeight {
+		for i := maxHeight; i < height; i++ {
 			prev[i] = list.head // for extra random height, insert it after head node
 		}
-		list.maxHeight = height // update new maxHeight
+		atomic.StoreInt32(&list.maxHeight, int32(height)) // update new maxHeight
 	}
 
-	// link new node in each level
-	x := newNode(key, height)
+	// link new node in each level from bottom to top, so readers see it in level 0 once it's visible in any level
+	x := list.newNode(key, height)
 	for i := 0; i < height; i++ {
 		x.setNext(i, prev[i].getNext(i))
 		prev[i].setNext(i, x)
 	}
+}
 
+// @description: insert a new key to skip list, it can be called by multiple writers at the same time
+// @param: the key to be inserted in skip list
+// @notice: user cannot insert a key already exists
+
+func (list *SkipList[K]) InsertConcurrently(key K) {
+	height := list.randomHeight()
+	for {
+		maxHeight := list.getMaxHeight()
+		if height <= maxHeight || atomic.CompareAndSwapInt32(&list.maxHeight, int32(maxHeight), int32(height)) {
+			break
+		}
+	}
+
+	// find the splice in each level from top to bottom
+	var prev, next [kMaxHeight]*Node[K]
+	before := list.head
+	for level := height - 1; level >= 0; level-- {
+		prev[level], next[level] = list.findSpliceForLevel(key, before, level)
+		before = prev[level]
+	}
+
+	// link new node from bottom to top, if another writer has changed the splice, find it again from prev
+	// the prev node is still before the key because nodes are never deleted
+	x := list.newNode(key, height)
+	for level := 0; level < height; level++ {
+		for {
+			x.setNext(level, next[level])
+			if prev[level].casNext(level, next[level], x) {
+				break
+			}
+			prev[level], next[level] = list.findSpliceForLevel(key, prev[level], level)
+		}
+	}
+}
+
+// @description: find the adjacent nodes between which the key should be inserted in certain level
+// @param: the key, a node before the key to start from, and the level
+// @return: the last node whose key less than param key and its next node in the level
+
+func (list *SkipList[K]) findSpliceForLevel(key K, before *Node[K], level int) (*Node[K], *Node[K]) {
+	for {
+		next := before.getNext(level)
+		if !list.keyIsAfterNode(key, next) {
+			return before, next
+		}
+		before = next
+	}
 }
 
 // @description: find out if a key exists in skip list
 // @param: the key
 // @return: the result
 
-func (list *SkipList) Contains(key interface{}) bool {
-	list.mu.RLock()
-	defer list.mu.RUnlock()
+func (list *SkipList[K]) Contains(key K) bool {
 	n, _ := list.findGreaterOrEqual(key)
 	return n != nil && list.comparator(n.key, key) == 0
 }
@@ -76,7 +140,7 @@ func (list *SkipList) Contains(key interface{}) bool {
 // @notice: param kBranching 4 indicates that the  indexing performance of this skip list is close to quad-tree
 //          by generating 2 height tower every 4 nodes, 3 height towers every 16 nodes, 4 height tower every 64 nodes and so on
 
-func (list *SkipList) randomHeight() int {
+func (list *SkipList[K]) randomHeight() int {
 	height := 1
 	for height < kMaxHeight && (rand.Intn(kBranching) == 0) {
 		height++
@@ -90,10 +154,10 @@ func (list *SkipList) randomHeight() int {
 // @return1: the first node whose key greater or equal than param key in level 0
 // @return2: inserting position in each level if a node with param key need to be inserted
 
-func (list *SkipList) findGreaterOrEqual(key interface{}) (*Node, [kMaxHeight]*Node) {
-	var prev [kMaxHeight]*Node
+func (list *SkipList[K]) findGreaterOrEqual(key K) (*Node[K], [kMaxHeight]*Node[K]) {
+	var prev [kMaxHeight]*Node[K]
 	x := list.head
-	level := list.maxHeight - 1
+	level := list.getMaxHeight() - 1
 
 	for true {
 		next := x.getNext(level)
@@ -115,7 +179,7 @@ func (list *SkipList) findGreaterOrEqual(key interface{}) (*Node, [kMaxHeight]*N
 // @description: find out if the key less than the key of node n
 // @return: if less, return true, if equal or greater, true false
 
-func (list *SkipList) keyIsAfterNode(key interface{}, n *Node) bool {
+func (list *SkipList[K]) keyIsAfterNode(key K, n *Node[K]) bool {
 	return (n != nil) && (list.comparator(n.key, key) < 0)
 }
 
@@ -123,9 +187,9 @@ func (list *SkipList) keyIsAfterNode(key interface{}, n *Node) bool {
 // @param: the key to be compared
 // @return: the last node whose key less than param key, if key is the smallest in skip list, return head
 
-func (list *SkipList) findLessThan(key interface{}) *Node {
+func (list *SkipList[K]) findLessThan(key K) *Node[K] {
 	x := list.head
-	level := list.maxHeight - 1
+	level := list.getMaxHeight() - 1
 
 	for true {
 		// find first greater than key in top level, and then sink down util level0
@@ -147,9 +211,9 @@ func (list *SkipList) findLessThan(key interface{}) *Node {
 // @description: find last node in level 0 in O(logN) instead of O(N)
 // @return: the last node, if skip list is empty, return head
 
-func (list *SkipList) findLast() *Node {
+func (list *SkipList[K]) findLast() *Node[K] {
 	x := list.head
-	level := list.maxHeight - 1
+	level := list.getMaxHeight() - 1
 
 	for true {
 		next := x.getNext(level)
@@ -170,8 +234,8 @@ func (list *SkipList) findLast() *Node {
 // @description: get a iterator of this skip list, note that the iterator is invalid, which need to seek
 // @return: the iterator
 
-func (list *SkipList) NewIterator() *Iterator {
-	iter := Iterator{
+func (list *SkipList[K]) NewIterator() *Iterator[K] {
+	iter := Iterator[K]{
 		list: list,
 	}
 
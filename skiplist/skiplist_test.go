@@ -2,12 +2,13 @@ package skiplist
 
 import (
 	"github.com/jo3yzhu/goveldb/utils"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestSkipList_Contains(t *testing.T) {
-	list := New(utils.IntComparator)
+	list := New(utils.OrderedComparator[int])
 	for i := 0; i < 100; i++ {
 		list.Insert(i)
 	}
@@ -22,7 +23,7 @@ func TestSkipList_Contains(t *testing.T) {
 }
 
 func TestIterator_SeekToFirst(t *testing.T) {
-	list := New(utils.IntComparator)
+	list := New(utils.OrderedComparator[int])
 	for i := 0; i < 100; i++ {
 		list.Insert(i)
 	}
@@ -42,7 +43,7 @@ func TestIterator_SeekToFirst(t *testing.T) {
 }
 
 func TestIterator_SeekToLast(t *testing.T) {
-	list := New(utils.IntComparator)
+	list := New(utils.OrderedComparator[int])
 	for i := 0; i < 100; i++ {
 		list.Insert(i)
 	}
@@ -77,7 +78,7 @@ func TestIterator_Seek(t *testing.T) {
 	sliceAfter := time.Now().Nanosecond()
 	t.Log("slice cost: ", sliceAfter-sliceBefore)
 
-	list := New(utils.IntComparator)
+	list := New(utils.OrderedComparator[int])
 	for i := 0; i < length; i++ {
 		list.Insert(i)
 	}
@@ -90,3 +91,94 @@ func TestIterator_Seek(t *testing.T) {
 	skipListAfter := time.Now().Nanosecond();
 	t.Log("skip list cost: ", skipListAfter-skipListBefore)
 }
+
+func TestSkipList_ConcurrentRead(t *testing.T) {
+	list := New(utils.OrderedComparator[int])
+	length := 10000
+
+	// one writer inserts while readers iterate without lock
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < 100; round++ {
+				last := -1
+				iter := list.NewIterator()
+				for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+					if iter.Key() <= last {
+						t.Error("traverse error")
+						return
+					}
+					last = iter.Key()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < length; i++ {
+		list.Insert(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < length; i++ {
+		if !list.Contains(i) {
+			t.Fatal("contains test fail")
+		}
+	}
+}
+
+func TestSkipList_InsertConcurrently(t *testing.T) {
+	list := New(utils.OrderedComparator[int])
+	writers := 8
+	length := 10000
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < length; i += writers {
+				list.InsertConcurrently(i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	i := 0
+	iter := list.NewIterator()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if iter.Key() != i {
+			t.Fatal("traverse error")
+		}
+		i++
+	}
+	if i != length {
+		t.Fatal("missing keys", i)
+	}
+}
+
+func TestSkipList_OrderedMap(t *testing.T) {
+	type entry struct {
+		key   string
+		value int
+	}
+
+	// skip list of any type can be used as an ordered map by comparing part of it
+	list := New(func(a, b entry) int {
+		return utils.OrderedComparator(a.key, b.key)
+	})
+	list.Insert(entry{"b", 2})
+	list.Insert(entry{"c", 3})
+	list.Insert(entry{"a", 1})
+
+	iter := list.NewIterator()
+	iter.Seek(entry{key: "b"})
+	if !iter.Valid() || iter.Key().value != 2 {
+		t.Fatal("seek error")
+	}
+	iter.Next()
+	if !iter.Valid() || iter.Key().value != 3 {
+		t.Fatal("traverse error")
+	}
+}
@@ -1,7 +1,6 @@
 package block
 
 import (
-	"bytes"
 	"encoding/binary"
 	"github.com/jo3yzhu/goveldb/internal"
 )
@@ -19,19 +18,23 @@ func New(p []byte) *Block {
 
 	// the last 4 bytes in block represent the key num of the block
 	// instead of restart point in leveldb, I keep it simple here
+	if len(p) < 4 {
+		return nil
+	}
 	keyNum := binary.LittleEndian.Uint32(p[len(p)-4:])
+	data := p[:len(p)-4]
+	if uint64(keyNum) > uint64(len(data)) {
+		return nil
+	}
 
-	// bytes.Buffer has implemented the Reader interface
-	data := bytes.NewBuffer(p)
-
+	// keys are decoded without copy, they refer to p
+	block.items = make([]internal.InternalKey, keyNum)
 	for i := uint32(0); i < keyNum; i++ {
-		var internalKey internal.InternalKey
-		err := internalKey.DecodeFrom(data)
+		n, err := block.items[i].DecodeFrom(data)
 		if err != nil {
 			return nil
 		}
-
-		block.items = append(block.items, internalKey)
+		data = data[n:]
 	}
 
 	return &block
@@ -1,7 +1,6 @@
 package block
 
 import (
-	"bytes"
 	"encoding/binary"
 	"github.com/jo3yzhu/goveldb/internal"
 )
@@ -9,30 +8,42 @@ import (
 // a block contains several internal keys, which are in order by key
 // A block builder in leveldb use restart pointer to compress
 type BlockBuilder struct {
-	buf bytes.Buffer // maintains a buffer can be added while Add
+	buf     []byte // maintains a buffer can be added while Add
 	counter uint32 // keep it simple, no restart point
 }
 
 func (blockbuilder *BlockBuilder) Reset() {
 	blockbuilder.counter = 0
-	blockbuilder.buf.Reset() // resets the buffer to be empty
+	blockbuilder.buf = blockbuilder.buf[:0] // resets the buffer to be empty
 }
 
 func (blockbuilder *BlockBuilder) Add(item *internal.InternalKey) {
 	blockbuilder.counter++
-	item.EncodeTo(&blockbuilder.buf) // the Writer implementation in bytes.Buffer use pointer receiver
+	blockbuilder.buf = item.AppendTo(blockbuilder.buf)
 }
 
 func (blockbuilder *BlockBuilder) Finish() []byte {
 	// write the counter to buf and return it
-	binary.Write(&blockbuilder.buf, binary.LittleEndian, blockbuilder.counter) // the Writer implementation in bytes.Buffer use pointer receiver
-	return blockbuilder.buf.Bytes()
+	var counter [4]byte
+	binary.LittleEndian.PutUint32(counter[:], blockbuilder.counter)
+	blockbuilder.buf = append(blockbuilder.buf, counter[:]...)
+	return blockbuilder.buf
 }
 
 func (blockbuilder *BlockBuilder) CurrentSizeEstimate() int {
-	return blockbuilder.buf.Len()
+	return len(blockbuilder.buf)
 }
 
 func (blockbuilder *BlockBuilder) Empty() bool {
-	return blockbuilder.buf.Len() == 0
+	return len(blockbuilder.buf) == 0
+}
+
+// Builder is implemented by builders of blocks in different format
+
+type Builder interface {
+	Add(item *internal.InternalKey)
+	Finish() []byte
+	Reset()
+	CurrentSizeEstimate() int
+	Empty() bool
 }
@@ -2,6 +2,8 @@ package block
 
 import "github.com/jo3yzhu/goveldb/internal"
 
+var _ internal.InternalIterator = (*Iterator)(nil)
+
 type Iterator struct {
 	block *Block
 	index int
@@ -27,7 +29,7 @@ func (iter *Iterator) Prev() {
 // 				 if such element doesn't exist, the iterator will be set at len(iter.block.items), which makes this iterator invalid
 // @params: UserKey need to be indexed
 
-func (iter *Iterator) Seek(target interface{}) {
+func (iter *Iterator) Seek(target []byte) {
 	left := 0
 	right := len(iter.block.items) - 1
 
@@ -59,4 +61,15 @@ func (iter *Iterator) SeekToLast() {
 	if len(iter.block.items) > 0 {
 		iter.index = len(iter.block.items) - 1
 	}
-}
\ No newline at end of file
+}
+
+// @description: block is decoded when it's read, so there's no error while iterating
+
+func (iter *Iterator) Error() error {
+	return nil
+}
+
+func (iter *Iterator) Close() {
+	iter.block = nil
+	iter.index = -1
+}
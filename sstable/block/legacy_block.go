@@ -0,0 +1,40 @@
+// Block in legacy format, which is written by goveldb before keys are varint encoded:
+//		entry: internal key in legacy encoding, see internal.InternalKey.DecodeLegacyFrom
+//		num_entries: fixed32
+// blocks of legacy format are only read, they are never written
+
+package block
+
+import (
+	"encoding/binary"
+	"github.com/jo3yzhu/goveldb/internal"
+)
+
+// @description: initial a block by bytes in legacy format
+// @param: the bytes of block contents
+// @return: the block, nil if the bytes are malformed
+
+func NewLegacy(p []byte) *Block {
+	var block Block
+
+	if len(p) < 4 {
+		return nil
+	}
+	keyNum := binary.LittleEndian.Uint32(p[len(p)-4:])
+	data := p[:len(p)-4]
+	if uint64(keyNum) > uint64(len(data)) {
+		return nil
+	}
+
+	// keys are decoded without copy, they refer to p
+	block.items = make([]internal.InternalKey, keyNum)
+	for i := uint32(0); i < keyNum; i++ {
+		n, err := block.items[i].DecodeLegacyFrom(data)
+		if err != nil {
+			return nil
+		}
+		data = data[n:]
+	}
+
+	return &block
+}
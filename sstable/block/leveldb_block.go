@@ -0,0 +1,166 @@
+// Block in leveldb format, in which keys are prefix compressed and the full key is stored at every restart point:
+//		entry: varint32 shared key length -> varint32 unshared key length -> varint32 value length -> unshared key -> value
+//		restarts: fixed32[num_restarts], offsets of restart points
+//		num_restarts: fixed32
+// the key of entry is an internal key of user key followed by fixed64 tag of seq and type, except the raw keys of meta index block
+
+package block
+
+import (
+	"encoding/binary"
+	"github.com/jo3yzhu/goveldb/internal"
+)
+
+type LevelDBBlockBuilder struct {
+	buf             []byte
+	restarts        []uint32 // offsets of restart points
+	counter         int      // number of entries since last restart point
+	restartInterval int
+	lastKey         []byte
+	key             []byte // scratch of current key
+	rawKeys         bool   // keys are written without tag
+}
+
+func NewLevelDBBlockBuilder(restartInterval int) *LevelDBBlockBuilder {
+	return &LevelDBBlockBuilder{
+		restarts:        []uint32{0},
+		restartInterval: restartInterval,
+	}
+}
+
+// @description: create a builder whose keys are user keys without tag, such as the names of meta blocks in meta index block
+
+func NewLevelDBRawBlockBuilder(restartInterval int) *LevelDBBlockBuilder {
+	builder := NewLevelDBBlockBuilder(restartInterval)
+	builder.rawKeys = true
+	return builder
+}
+
+func (builder *LevelDBBlockBuilder) Reset() {
+	builder.buf = builder.buf[:0]
+	builder.restarts = append(builder.restarts[:0], 0)
+	builder.counter = 0
+	builder.lastKey = builder.lastKey[:0]
+}
+
+// @description: add an internal key to block, keys must be added in order
+
+func (builder *LevelDBBlockBuilder) Add(item *internal.InternalKey) {
+	builder.key = append(builder.key[:0], item.UserKey...)
+	if !builder.rawKeys {
+		builder.key = binary.LittleEndian.AppendUint64(builder.key, internal.PackSequenceAndType(item.Seq, item.Type))
+	}
+
+	// share prefix with last key until restart point
+	shared := 0
+	if builder.counter < builder.restartInterval {
+		for shared < len(builder.lastKey) && shared < len(builder.key) && builder.lastKey[shared] == builder.key[shared] {
+			shared++
+		}
+	} else {
+		builder.restarts = append(builder.restarts, uint32(len(builder.buf)))
+		builder.counter = 0
+	}
+
+	builder.buf = binary.AppendUvarint(builder.buf, uint64(shared))
+	builder.buf = binary.AppendUvarint(builder.buf, uint64(len(builder.key)-shared))
+	builder.buf = binary.AppendUvarint(builder.buf, uint64(len(item.UserValue)))
+	builder.buf = append(builder.buf, builder.key[shared:]...)
+	builder.buf = append(builder.buf, item.UserValue...)
+
+	builder.lastKey = append(builder.lastKey[:0], builder.key...)
+	builder.counter++
+}
+
+func (builder *LevelDBBlockBuilder) Finish() []byte {
+	for _, restart := range builder.restarts {
+		builder.buf = binary.LittleEndian.AppendUint32(builder.buf, restart)
+	}
+	builder.buf = binary.LittleEndian.AppendUint32(builder.buf, uint32(len(builder.restarts)))
+	return builder.buf
+}
+
+func (builder *LevelDBBlockBuilder) CurrentSizeEstimate() int {
+	return len(builder.buf) + 4*len(builder.restarts) + 4
+}
+
+func (builder *LevelDBBlockBuilder) Empty() bool {
+	return len(builder.buf) == 0
+}
+
+// @description: initial a block by bytes in leveldb format
+// @param: the bytes of block contents without trailer
+// @return: the block, nil if the bytes are malformed
+
+func NewLevelDB(p []byte) *Block {
+	return newLevelDB(p, false)
+}
+
+// @description: initial a block whose keys are user keys without tag by bytes in leveldb format
+// @param: the bytes of block contents without trailer
+// @return: the block with items of the keys as user keys, nil if the bytes are malformed
+
+func NewLevelDBRaw(p []byte) *Block {
+	return newLevelDB(p, true)
+}
+
+func newLevelDB(p []byte, rawKeys bool) *Block {
+	var block Block
+
+	if len(p) < 4 {
+		return nil
+	}
+	numRestarts := binary.LittleEndian.Uint32(p[len(p)-4:])
+	if uint64(numRestarts) > uint64(len(p)-4)/4 {
+		return nil
+	}
+	data := p[:len(p)-4-4*int(numRestarts)]
+
+	var key []byte
+	for len(data) > 0 {
+		var header [3]uint64
+		for i := range header {
+			x, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil
+			}
+			header[i] = x
+			data = data[n:]
+		}
+
+		shared, unshared, valueLength := header[0], header[1], header[2]
+		if shared > uint64(len(key)) || unshared+valueLength > uint64(len(data)) {
+			return nil
+		}
+
+		// keys are restored from shared prefix, so they can't refer to p
+		next := make([]byte, shared+unshared)
+		copy(next, key[:shared])
+		copy(next[shared:], data[:unshared])
+		key = next
+		if rawKeys {
+			block.items = append(block.items, internal.InternalKey{
+				Type:      internal.TypeValue,
+				UserKey:   key,
+				UserValue: data[unshared : unshared+valueLength : unshared+valueLength],
+			})
+			data = data[unshared+valueLength:]
+			continue
+		}
+		if len(key) < 8 {
+			return nil
+		}
+
+		userKeySize := len(key) - 8
+		seq, valueType := internal.UnpackSequenceAndType(binary.LittleEndian.Uint64(key[userKeySize:]))
+		block.items = append(block.items, internal.InternalKey{
+			Seq:       seq,
+			Type:      valueType,
+			UserKey:   key[:userKeySize:userKeySize],
+			UserValue: data[unshared : unshared+valueLength : unshared+valueLength],
+		})
+		data = data[unshared+valueLength:]
+	}
+
+	return &block
+}
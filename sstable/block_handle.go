@@ -4,35 +4,100 @@ import (
 	"encoding/binary"
 	"github.com/jo3yzhu/goveldb/internal"
 	"io"
+	"io/ioutil"
 )
 
+// BlockHandle is a pointer to the extent of a file that stores a data block or a meta block
+// offset and size are encoded in varint64, so a table is not limited to 4GB
+
 type BlockHandle struct {
-	Offset uint32
-	Size   uint32
+	Offset uint64
+	Size   uint64
 }
 
+// format version of table, which is told by magic number in footer
+// tables written by goveldb before the footer is versioned carry leveldb's magic number, which is told by compatible mode
+
+const (
+	kFormatVarint   = iota // varint64 block handles and 48 bytes footer, blocks have no checksum
+	kFormatLevelDB         // on-disk format of leveldb, blocks are prefix compressed and followed by trailer of compression type and crc
+	kFormatChecksum        // kFormatVarint whose blocks are followed by trailer of compression type and crc like leveldb format
+	kFormatLegacy          // fixed32 block handles and 24 bytes footer, keys of blocks are fixed length encoded, which is only read
+)
+
 const (
-	kTableMagicNumber uint64 = 0xdb4775248b80fb57
+	kTableMagicNumber   uint64 = 0x676f76656c646233 // "goveldb3", tables of kFormatChecksum
+	kTableMagicNumberV2 uint64 = 0x676f76656c646232 // "goveldb2", tables of kFormatVarint, which are still readable
+
+	// leveldb's magic number, which is also used by legacy format of goveldb
+	kLevelDBTableMagicNumber uint64 = 0xdb4775248b80fb57
+
+	// max encoded length of a block handle
+	kBlockHandleMaxEncodedLength = 2 * binary.MaxVarintLen64
+
+	// footer is the fixed length, two block handles padded to their max length and 8 bytes magic number
+	kFooterEncodedLength       = 2*kBlockHandleMaxEncodedLength + 8
+	kLegacyFooterEncodedLength = 4*4 + 8
+
+	// trailer of block in leveldb format and kFormatChecksum
+	kBlockTrailerSize  = 1 + 4
+	kNoCompression     = 0
+	kSnappyCompression = 1
 )
 
 // @description: encode blockHandle into a byte slice
 // @return: the byte slice
 
 func (blockHandle *BlockHandle) EncodeToBytes() []byte {
-	p := make([]byte, 8)
-	binary.LittleEndian.PutUint32(p, blockHandle.Offset)
-	binary.LittleEndian.PutUint32(p[4:], blockHandle.Size)
-	return p
+	return blockHandle.AppendTo(nil)
+}
+
+// @description: append encoded blockHandle to a byte slice
+// @return: the extended byte slice
+
+func (blockHandle *BlockHandle) AppendTo(dst []byte) []byte {
+	dst = binary.AppendUvarint(dst, blockHandle.Offset)
+	dst = binary.AppendUvarint(dst, blockHandle.Size)
+	return dst
 }
 
 // @description: decode blockHandle itself from a byte slice
 // @param: the byte slice
+// @return: the number of bytes read and error if any
+
+func (blockHandle *BlockHandle) DecodeFromBytes(p []byte) (int, error) {
+	offset, n := binary.Uvarint(p)
+	if n <= 0 {
+		return 0, internal.ErrCorruption
+	}
+	size, m := binary.Uvarint(p[n:])
+	if m <= 0 {
+		return 0, internal.ErrCorruption
+	}
 
-func (blockHandle *BlockHandle) DecodeFromBytes(p []byte) {
-	if len(p) == 8 {
-		blockHandle.Offset = binary.LittleEndian.Uint32(p)
-		blockHandle.Size = binary.LittleEndian.Uint32(p[4:])
+	blockHandle.Offset = offset
+	blockHandle.Size = size
+	return n + m, nil
+}
+
+// @description: decode blockHandle in legacy format, offset and size are fixed32
+
+func (blockHandle *BlockHandle) decodeLegacy(p []byte) (int, error) {
+	if len(p) < 8 {
+		return 0, internal.ErrCorruption
 	}
+	blockHandle.Offset = uint64(binary.LittleEndian.Uint32(p))
+	blockHandle.Size = uint64(binary.LittleEndian.Uint32(p[4:]))
+	return 8, nil
+}
+
+// @description: decode blockHandle in certain format version
+
+func (blockHandle *BlockHandle) decode(p []byte, version int) (int, error) {
+	if version == kFormatLegacy {
+		return blockHandle.decodeLegacy(p)
+	}
+	return blockHandle.DecodeFromBytes(p)
 }
 
 // IndexBlockHandle is a simple pack of internalKey
@@ -46,8 +111,8 @@ func (index *IndexBlockHandle) SetBlockHandle(blockHandle BlockHandle) {
 	index.UserValue = blockHandle.EncodeToBytes()
 }
 
-func (index *IndexBlockHandle) GetBlockHandle() (blockHandle BlockHandle) {
-	blockHandle.DecodeFromBytes(index.UserValue)
+func (index *IndexBlockHandle) GetBlockHandle(version int) (blockHandle BlockHandle, err error) {
+	_, err = blockHandle.decode(index.UserValue, version)
 	return
 }
 
@@ -56,46 +121,88 @@ func (index *IndexBlockHandle) GetBlockHandle() (blockHandle BlockHandle) {
 type Footer struct {
 	MetaIndexHandle BlockHandle
 	IndexHandle     BlockHandle
-}
-
-func (footer *Footer) Size() int {
-	return binary.Size(footer) + 8 // 8 bytes for magic, golang read size of user defined by reflect
+	Version         int // format version of the table
 }
 
 // @description: encode the footer itself into a writer
 // @param: the writable object
 
 func (footer *Footer) EncodeTo(w io.Writer) error {
-	// 1. write meta and data handle to file
-	err := binary.Write(w, binary.LittleEndian, footer) // user defined type can be written to binary buffer by reflect of golang
-	if err != nil {
-		return err
+	// 1. write meta and data handle to buffer, padding to max length
+	p := make([]byte, 0, kFooterEncodedLength)
+	p = footer.MetaIndexHandle.AppendTo(p)
+	p = footer.IndexHandle.AppendTo(p)
+	p = p[:kFooterEncodedLength-8]
+
+	// 2. write magic number to buffer
+	switch footer.Version {
+	case kFormatLevelDB:
+		p = binary.LittleEndian.AppendUint64(p, kLevelDBTableMagicNumber)
+	case kFormatChecksum:
+		p = binary.LittleEndian.AppendUint64(p, kTableMagicNumber)
+	default:
+		p = binary.LittleEndian.AppendUint64(p, kTableMagicNumberV2)
 	}
 
-	// 2. write magic number to file
-	err = binary.Write(w, binary.LittleEndian, kTableMagicNumber)
+	_, err := w.Write(p)
 	return err
 }
 
 // @description: decode the footer itself from a reader
-// @param: the readable object
+// @param: the readable object which contains the tail of table, the footer is at the end of it
 
 func (footer *Footer) DecodeFrom(r io.Reader) error {
-	err := binary.Read(r, binary.LittleEndian, footer) // user defined type can be read from binary buffer by reflect of golang
+	p, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
+	return footer.DecodeFromBytes(p)
+}
 
-	var magic uint64
-	err = binary.Read(r, binary.LittleEndian, &magic)
+// @description: decode the footer itself from the tail of table
+// @param: the byte slice whose last bytes are footer
 
-	if err != nil {
-		return err
+func (footer *Footer) DecodeFromBytes(p []byte) error {
+	return footer.decode(p, false)
+}
+
+// @description: decode the footer itself from the tail of table
+// @param: the byte slice whose last bytes are footer, and if leveldb's magic number means leveldb format,
+//		   otherwise it means legacy format of goveldb
+
+func (footer *Footer) decode(p []byte, levelDBCompatible bool) error {
+	if len(p) < 8 {
+		return internal.ErrTableTooShort
 	}
 
-	if magic != kTableMagicNumber {
+	switch binary.LittleEndian.Uint64(p[len(p)-8:]) {
+	case kTableMagicNumber:
+		footer.Version = kFormatChecksum
+	case kTableMagicNumberV2:
+		footer.Version = kFormatVarint
+	case kLevelDBTableMagicNumber:
+		if levelDBCompatible {
+			footer.Version = kFormatLevelDB
+		} else {
+			footer.Version = kFormatLegacy
+		}
+	default:
 		return internal.ErrTableFileMagic
 	}
 
-	return nil
+	footerLength := kFooterEncodedLength
+	if footer.Version == kFormatLegacy {
+		footerLength = kLegacyFooterEncodedLength
+	}
+	if len(p) < footerLength {
+		return internal.ErrTableTooShort
+	}
+	p = p[len(p)-footerLength:]
+
+	n, err := footer.MetaIndexHandle.decode(p, footer.Version)
+	if err != nil {
+		return err
+	}
+	_, err = footer.IndexHandle.decode(p[n:], footer.Version)
+	return err
 }
@@ -1,7 +1,14 @@
 package sstable
 
 import (
-	"os"
+	"bytes"
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/filter"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
+	"github.com/jo3yzhu/goveldb/sstable/block"
+	"io/ioutil"
 	"reflect"
 	"testing"
 )
@@ -22,10 +29,7 @@ func Test_BlockHandle(t *testing.T) {
 	}
 }
 func Test_Footer(t *testing.T) {
-	file, err := os.Create("footer_test")
-	if err != nil {
-		t.Fatal("file create fail")
-	}
+	var file bytes.Buffer
 	footer1 := Footer{
 		MetaIndexHandle: BlockHandle{
 			Offset: 0,
@@ -37,15 +41,13 @@ func Test_Footer(t *testing.T) {
 		},
 	}
 
-	err = footer1.EncodeTo(file)
+	err := footer1.EncodeTo(&file)
 	if err != nil {
 		t.Fatal("encode footer fail")
 	}
 
-	file.Seek(0,0)
-
 	var footer2 Footer
-	err = footer2.DecodeFrom(file)
+	err = footer2.DecodeFrom(&file)
 	if err != nil {
 		t.Fatal("decode footer fail")
 	}
@@ -54,3 +56,319 @@ func Test_Footer(t *testing.T) {
 		t.Fatal("encode decode fail")
 	}
 }
+
+func Test_BlockHandle_Large(t *testing.T) {
+	// offset beyond 4GB
+	handle1 := BlockHandle{
+		Offset: 5 << 30,
+		Size:   1 << 20,
+	}
+
+	p := handle1.EncodeToBytes()
+	if len(p) > kBlockHandleMaxEncodedLength {
+		t.Fatal("encoded length error")
+	}
+
+	var handle2 BlockHandle
+	if _, err := handle2.DecodeFromBytes(p); err != nil || handle1 != handle2 {
+		t.Fatal("encode decode fail")
+	}
+}
+
+func Test_Footer_Large(t *testing.T) {
+	footer1 := Footer{
+		MetaIndexHandle: BlockHandle{
+			Offset: 1<<63 + 1,
+			Size:   1<<63 + 2,
+		},
+		IndexHandle: BlockHandle{
+			Offset: 1<<63 + 3,
+			Size:   1<<63 + 4,
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := footer1.EncodeTo(&buf); err != nil || buf.Len() != kFooterEncodedLength {
+		t.Fatal("encode footer fail")
+	}
+
+	var footer2 Footer
+	if err := footer2.DecodeFromBytes(buf.Bytes()); err != nil {
+		t.Fatal("decode footer fail")
+	}
+	if reflect.DeepEqual(footer1, footer2) == false {
+		t.Fatal("encode decode fail")
+	}
+}
+
+// the tables in testdata are opened on the disk env, they are only read, other tables are written in an in-memory env
+
+func Test_SsTable_Baseline(t *testing.T) {
+	// the table is written by goveldb before the footer is versioned, with leveldb's magic number and fixed32 block handles
+	table, err := Open("testdata/baseline.ldb", nil)
+	if err != nil {
+		t.Fatal("open baseline table fail", err)
+	}
+	defer table.Close()
+	if table.footer.Version != kFormatLegacy {
+		t.Fatal("format version error", table.footer.Version)
+	}
+
+	expected := []*internal.InternalKey{
+		internal.NewInternalKey(1, internal.TypeValue, []byte("123"), []byte("1234")),
+		internal.NewInternalKey(2, internal.TypeValue, []byte("124"), []byte("1245")),
+		internal.NewInternalKey(3, internal.TypeValue, []byte("125"), []byte("0245")),
+	}
+	iter := table.NewIterator(nil)
+	var items []*internal.InternalKey
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		key := iter.InternalKey()
+		items = append(items, internal.NewInternalKey(key.Seq, key.Type, key.UserKey, key.UserValue))
+	}
+	if iter.Error() != nil || !reflect.DeepEqual(items, expected) {
+		t.Fatal("iterate baseline table error", items, iter.Error())
+	}
+	iter.Close()
+
+	if value, err := table.Get([]byte("124"), nil); err != nil || string(value) != "1245" {
+		t.Fatal("get from baseline table fail", err)
+	}
+	if _, err := table.Get([]byte("126"), nil); err != internal.ErrNotFound {
+		t.Fatal("get missing key from baseline table error", err)
+	}
+
+	// leveldb's magic number means leveldb format in compatible mode
+	if _, err := Open("testdata/baseline.ldb", &opt.Options{LevelDBCompatible: true}); err == nil {
+		t.Fatal("baseline table is opened as leveldb table")
+	}
+
+	// a table of leveldb isn't mistaken for the legacy format
+	if _, err := Open("testdata/leveldb_table.ldb", nil); err != internal.ErrUnsupportedFormat {
+		t.Fatal("leveldb table is not rejected explicitly", err)
+	}
+}
+
+func Test_SsTable_LevelDB(t *testing.T) {
+	opts := &opt.Options{Env: env.NewMemEnv(), LevelDBCompatible: true}
+	fileName := "000123.ldb"
+
+	builder, err := NewTableBuilder(fileName, opts)
+	if err != nil {
+		t.Fatal("create table fail", err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%06d", i))
+		builder.Add(internal.NewInternalKey(uint64(i+1), internal.TypeValue, key, key))
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal("finish fail", err)
+	}
+
+	table, err := Open(fileName, opts)
+	if err != nil {
+		t.Fatal("open leveldb table fail", err)
+	}
+	if table.footer.Version != kFormatLevelDB {
+		t.Fatal("format version error")
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%06d", i))
+		if value, err := table.Get(key, nil); err != nil || !bytes.Equal(value, key) {
+			t.Fatal("get from leveldb table fail", i)
+		}
+	}
+}
+
+func Test_SsTable_Varint(t *testing.T) {
+	// the table is written by goveldb before blocks have checksum, with magic number "goveldb2"
+	table, err := Open("testdata/varint.ldb", nil)
+	if err != nil {
+		t.Fatal("open table fail", err)
+	}
+	if table.footer.Version != kFormatVarint {
+		t.Fatal("format version error", table.footer.Version)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%06d", i))
+		if value, err := table.Get(key, nil); err != nil || !bytes.Equal(value, key) {
+			t.Fatal("get from table fail", i, err)
+		}
+	}
+}
+
+func Test_SsTable_BlockChecksum(t *testing.T) {
+	fs := env.NewMemEnv()
+	opts := &opt.Options{Env: fs}
+	fileName := "000123.ldb"
+	builder, _ := NewTableBuilder(fileName, opts)
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%06d", i))
+		builder.Add(internal.NewInternalKey(uint64(i+1), internal.TypeValue, key, key))
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal("finish fail", err)
+	}
+
+	// a bit flip in the first block is detected by its crc
+	p, _ := env.ReadFile(fs, fileName)
+	p[10] ^= 1
+	_ = env.WriteFile(fs, fileName, p, false)
+	table, err := Open(fileName, opts)
+	if err != nil {
+		t.Fatal("open table fail", err)
+	}
+	if table.footer.Version != kFormatChecksum {
+		t.Fatal("format version error", table.footer.Version)
+	}
+	if _, err := table.Get([]byte("000000"), nil); err != internal.ErrCorruption {
+		t.Fatal("corrupted block is read", err)
+	}
+	if value, err := table.Get([]byte("000999"), nil); err != nil || string(value) != "000999" {
+		t.Fatal("get from intact block fail", err)
+	}
+}
+
+// the golden tables are laid out as leveldb's TableBuilder (table_builder.cc, block_builder.cc and format.cc) writes them
+// with default options except kNoCompression: 4KB blocks, restart interval 16, shortened index keys and an empty meta index block
+
+var goldenTables = []struct {
+	fileName string
+	items    []*internal.InternalKey
+}{
+	{
+		fileName: "testdata/leveldb_separator.ldb",
+		items: []*internal.InternalKey{
+			internal.NewInternalKey(1, internal.TypeValue, []byte("apple"), bytes.Repeat([]byte("a"), 4096)),
+			internal.NewInternalKey(2, internal.TypeValue, []byte("cherry"), bytes.Repeat([]byte("c"), 4096)),
+		},
+	},
+	{
+		fileName: "testdata/leveldb_table.ldb",
+		items: func() []*internal.InternalKey {
+			var items []*internal.InternalKey
+			for i := 0; i < 3000; i += 3 {
+				key := []byte(fmt.Sprintf("k%05d.suffix", i))
+				if i%10 == 0 {
+					items = append(items, internal.NewInternalKey(uint64(i+1), internal.TypeDeletion, key, nil))
+				} else {
+					items = append(items, internal.NewInternalKey(uint64(i+1), internal.TypeValue, key, bytes.Repeat([]byte(fmt.Sprintf("v%05d", i)), i%7+1)))
+				}
+			}
+			return items
+		}(),
+	},
+}
+
+func Test_SsTable_LevelDBGolden(t *testing.T) {
+	opts := &opt.Options{LevelDBCompatible: true}
+	for _, golden := range goldenTables {
+		expected, err := ioutil.ReadFile(golden.fileName)
+		if err != nil {
+			t.Fatal("read golden table fail", err)
+		}
+
+		// goveldb writes the same bytes as leveldb
+		fs := env.NewMemEnv()
+		fileName := "000123.ldb"
+		builder, _ := NewTableBuilder(fileName, &opt.Options{Env: fs, LevelDBCompatible: true})
+		for _, item := range golden.items {
+			builder.Add(item)
+		}
+		if err := builder.Finish(); err != nil {
+			t.Fatal("finish fail", err)
+		}
+		if p, _ := env.ReadFile(fs, fileName); !bytes.Equal(p, expected) {
+			t.Fatal("table is different from golden table", golden.fileName)
+		}
+
+		// goveldb reads all of the entries written by leveldb
+		table, err := Open(golden.fileName, opts)
+		if err != nil {
+			t.Fatal("open golden table fail", err)
+		}
+		it := table.NewIterator(nil)
+		i := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			item := it.InternalKey()
+			if i >= len(golden.items) || !reflect.DeepEqual(item.UserKey, golden.items[i].UserKey) || item.Seq != golden.items[i].Seq ||
+				item.Type != golden.items[i].Type || !bytes.Equal(item.UserValue, golden.items[i].UserValue) {
+				t.Fatal("entry error", golden.fileName, i)
+			}
+			i++
+		}
+		if it.Error() != nil || i != len(golden.items) {
+			t.Fatal("iterate golden table fail", golden.fileName, i, it.Error())
+		}
+		it.Close()
+	}
+}
+
+func Test_SsTable_SeekSeparator(t *testing.T) {
+	// blocks are [apple] and [cherry], whose index keys are the separators "b" and "d"
+	table, err := Open("testdata/leveldb_separator.ldb", &opt.Options{LevelDBCompatible: true})
+	if err != nil {
+		t.Fatal("open golden table fail", err)
+	}
+	indexIter := table.index.NewIterator()
+	var indexKeys []string
+	for indexIter.SeekToFirst(); indexIter.Valid(); indexIter.Next() {
+		indexKeys = append(indexKeys, string(indexIter.InternalKey().UserKey))
+	}
+	if !reflect.DeepEqual(indexKeys, []string{"b", "d"}) {
+		t.Fatal("index keys error", indexKeys)
+	}
+
+	it := table.NewIterator(nil)
+	defer it.Close()
+	for target, expected := range map[string]string{"": "apple", "apple": "apple", "az": "cherry", "b": "cherry", "cherry": "cherry", "cz": "", "d": ""} {
+		it.Seek([]byte(target))
+		if expected == "" {
+			if it.Valid() || it.Error() != nil {
+				t.Fatal("seek beyond the last key", target)
+			}
+		} else if !it.Valid() || string(it.Key()) != expected {
+			t.Fatal("seek error", target)
+		}
+	}
+	if value, err := table.Get([]byte("cherry"), nil); err != nil || len(value) != 4096 {
+		t.Fatal("get fail", err)
+	}
+	if _, err := table.Get([]byte("az"), nil); err != internal.ErrNotFound {
+		t.Fatal("get absent key", err)
+	}
+}
+
+func Test_SsTable_LevelDBMetaIndex(t *testing.T) {
+	opts := &opt.Options{
+		Env:               env.NewMemEnv(),
+		LevelDBCompatible: true,
+		PrefixExtractor:   filter.NewDelimiterPrefixExtractor(':'),
+	}
+	fileName := "000123.ldb"
+	builder, _ := NewTableBuilder(fileName, opts)
+	builder.Add(internal.NewInternalKey(1, internal.TypeValue, []byte("k1:0"), nil))
+	if err := builder.Finish(); err != nil {
+		t.Fatal("finish fail", err)
+	}
+
+	// keys of meta index block are the names of meta blocks without tag
+	table, err := Open(fileName, opts)
+	if err != nil {
+		t.Fatal("open table fail", err)
+	}
+	content, err := table.readBlockContents(table.footer.MetaIndexHandle)
+	if err != nil {
+		t.Fatal("read meta index block fail", err)
+	}
+	it := block.NewLevelDBRaw(content).NewIterator()
+	it.SeekToFirst()
+	if !it.Valid() || string(it.InternalKey().UserKey) != kPrefixFilterPrefix+opts.PrefixExtractor.Name() {
+		t.Fatal("meta index key error")
+	}
+	if it.Next(); it.Valid() {
+		t.Fatal("meta index block has more than one entry")
+	}
+	if table.PrefixMayMatch([]byte("k0:")) || !table.PrefixMayMatch([]byte("k1:")) {
+		t.Fatal("prefix filter error")
+	}
+}
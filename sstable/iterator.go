@@ -3,17 +3,26 @@ package sstable
 import (
 	"github.com/jo3yzhu/goveldb/internal"
 	"github.com/jo3yzhu/goveldb/sstable/block"
+	"github.com/jo3yzhu/goveldb/statistics"
 )
 
+var _ internal.InternalIterator = (*Iterator)(nil)
+
 type Iterator struct {
 	table           *SsTable
 	dataBlockHandle BlockHandle // the data block handle of current key
 	dataIter        *block.Iterator
 	indexIter       *block.Iterator
+	err             error  // error of reading data block, the iterator stops at the broken block
+	lowerBound      []byte // blocks before it are not read when moving backward
+	upperBound      []byte // blocks after it are not read when moving forward
+
+	prefixSameAsStart bool // if true, Seek is skipped if the prefix bloom filter says the prefix of target is absent
+	perf              *statistics.PerfContext
 }
 
 func (iter *Iterator) Valid() bool {
-	return iter.dataIter != nil && iter.dataIter.Valid()
+	return iter.err == nil && iter.dataIter != nil && iter.dataIter.Valid()
 }
 
 func (iter *Iterator) InternalKey() *internal.InternalKey {
@@ -36,12 +45,23 @@ func (iter *Iterator) initDataBlock() {
 			InternalKey: iter.indexIter.InternalKey(),
 		}
 
-		dataBlockHandle := index.GetBlockHandle()
+		dataBlockHandle, err := index.GetBlockHandle(iter.table.footer.Version)
+		if err != nil {
+			iter.err = err
+			iter.dataIter = nil
+			return
+		}
 
 		if iter.dataIter != nil && iter.dataBlockHandle == dataBlockHandle {
 			// nothing to do
 		} else {
-			iter.dataIter = iter.table.readBlock(dataBlockHandle).NewIterator()
+			dataBlock, err := iter.table.readBlock(dataBlockHandle, iter.perf)
+			if err != nil {
+				iter.err = err
+				iter.dataIter = nil
+				return
+			}
+			iter.dataIter = dataBlock.NewIterator()
 			iter.dataBlockHandle = dataBlockHandle
 		}
 	}
@@ -49,7 +69,13 @@ func (iter *Iterator) initDataBlock() {
 
 func (iter *Iterator) skipEmptyDataBlocksForward() {
 	for iter.dataIter == nil || !iter.dataIter.Valid() {
-		if !iter.indexIter.Valid() {
+		if iter.err != nil || !iter.indexIter.Valid() {
+			iter.dataIter = nil
+			return
+		}
+
+		// the index key, which is not less than any key of current block, reaches upper bound, so do the following blocks
+		if iter.upperBound != nil && internal.UserKeyComparator(iter.indexIter.InternalKey().UserKey, iter.upperBound) >= 0 {
 			iter.dataIter = nil
 			return
 		}
@@ -63,11 +89,17 @@ func (iter *Iterator) skipEmptyDataBlocksForward() {
 
 func (iter *Iterator) skipEmptyDataBlocksBackward() {
 	for iter.dataIter == nil || !iter.dataIter.Valid() {
-		if !iter.indexIter.Valid() {
+		if iter.err != nil || !iter.indexIter.Valid() {
 			iter.dataIter = nil
 			return
 		}
 		iter.indexIter.Prev()
+
+		// the index key of previous block is before lower bound, so are all of the keys in it
+		if iter.lowerBound != nil && iter.indexIter.Valid() && internal.UserKeyComparator(iter.indexIter.InternalKey().UserKey, iter.lowerBound) < 0 {
+			iter.dataIter = nil
+			return
+		}
 		iter.initDataBlock()
 		if iter.dataIter != nil {
 			iter.dataIter.SeekToLast()
@@ -76,7 +108,22 @@ func (iter *Iterator) skipEmptyDataBlocksBackward() {
 }
 
 func (iter *Iterator) Seek(target []byte) {
-	// indexIter's key is the largest key of data block it managed (details in table_build)
+	iter.err = nil
+
+	// there's no key with the prefix of target, and keys with other prefixes are not needed
+	if iter.prefixSameAsStart {
+		extractor := iter.table.prefixExtractor
+		if extractor != nil && extractor.InDomain(target) {
+			mayMatch := iter.table.PrefixMayMatch(extractor.Transform(target))
+			iter.perf.RecordBloomCheck(!mayMatch)
+			if !mayMatch {
+				iter.table.stats.RecordTick(statistics.BloomUseful, 1)
+				iter.dataIter = nil
+				return
+			}
+		}
+	}
+	// indexIter's key is not less than any key of data block it managed and less than keys of the next block (details in table_build)
 	iter.indexIter.Seek(target)
 
 	// init data block by indexIter
@@ -87,10 +134,12 @@ func (iter *Iterator) Seek(target []byte) {
 		iter.dataIter.Seek(target)
 	}
 
-	iter.skipEmptyDataBlocksBackward()
+	// all of the keys in the block are before target, then the next key is the first one of the following blocks
+	iter.skipEmptyDataBlocksForward()
 }
 
 func (iter *Iterator) SeekToFirst() {
+	iter.err = nil
 	iter.indexIter.SeekToFirst()
 	iter.initDataBlock()
 	if iter.dataIter != nil {
@@ -100,6 +149,7 @@ func (iter *Iterator) SeekToFirst() {
 }
 
 func (iter *Iterator) SeekToLast() {
+	iter.err = nil
 	iter.indexIter.SeekToLast()
 	iter.initDataBlock()
 	if iter.dataIter != nil {
@@ -117,3 +167,14 @@ func (iter *Iterator) Prev() {
 	iter.dataIter.Prev()
 	iter.skipEmptyDataBlocksBackward()
 }
+
+func (iter *Iterator) Error() error {
+	return iter.err
+}
+
+// @description: release the blocks referred by iterator, the table is still owned by table cache
+
+func (iter *Iterator) Close() {
+	iter.dataIter = nil
+	iter.indexIter = nil
+}
@@ -1,76 +1,228 @@
 package sstable
 
 import (
+	"encoding/binary"
+	"github.com/golang/snappy"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/filter"
 	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
 	"github.com/jo3yzhu/goveldb/sstable/block"
-	"io"
-	"os"
+	"github.com/jo3yzhu/goveldb/statistics"
+	"github.com/jo3yzhu/goveldb/utils"
+	"time"
 )
 
 type SsTable struct {
-	index  *block.Block // sstable has a unique index block indicate where data block is
-	footer Footer
-	file   *os.File
+	index           *block.Block // sstable has a unique index block indicate where data block is
+	footer          Footer
+	file            env.RandomAccessFile
+	filter          []byte                 // prefix bloom filter
+	prefixExtractor filter.PrefixExtractor // the extractor which filter is built by, nil if there's no filter
+	stats           *statistics.Statistics
 }
 
 // @description: read a block from disk by block handle
-// @param: the block handle with information where the block is and how long it is
-// @return: the block
+// @param: the block handle with information where the block is and how long it is, and the perf context to record into
+// @return: the block and error if it can't be read or it's corrupted
 
-func (table *SsTable) readBlock(handle BlockHandle) *block.Block {
-	p := make([]byte, handle.Size)
-	n, err := table.file.ReadAt(p, int64(handle.Offset))
-	if err != nil || uint32(n) != handle.Size {
-		return nil
+func (table *SsTable) readBlock(handle BlockHandle, perf *statistics.PerfContext) (*block.Block, error) {
+	start := time.Now()
+	content, err := table.readBlockContents(handle)
+	if err != nil {
+		return nil, err
+	}
+	perf.RecordBlockRead(handle.Size, uint64(len(content)), time.Since(start))
+
+	var b *block.Block
+	switch table.footer.Version {
+	case kFormatLevelDB:
+		b = block.NewLevelDB(content)
+	case kFormatLegacy:
+		b = block.NewLegacy(content)
+	default:
+		b = block.New(content)
+	}
+	if b == nil {
+		return nil, internal.ErrCorruption
+	}
+	return b, nil
+}
+
+// @description: read the contents of a block, block with trailer is checked by its crc and uncompressed
+
+func (table *SsTable) readBlockContents(handle BlockHandle) ([]byte, error) {
+	defer table.stats.MeasureSince(statistics.TableReadMicros, table.stats.Start())
+	table.stats.RecordTick(statistics.BlockRead, 1)
+	table.stats.RecordTick(statistics.BlockReadBytes, handle.Size)
+
+	if table.footer.Version == kFormatVarint || table.footer.Version == kFormatLegacy {
+		p := make([]byte, handle.Size)
+		if _, err := table.file.ReadAt(p, int64(handle.Offset)); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	p := make([]byte, handle.Size+kBlockTrailerSize)
+	if _, err := table.file.ReadAt(p, int64(handle.Offset)); err != nil {
+		return nil, err
+	}
+
+	content := p[:handle.Size]
+	trailer := p[handle.Size:]
+	if utils.Crc32c(content, trailer[:1]) != utils.UnmaskCrc(binary.LittleEndian.Uint32(trailer[1:])) {
+		return nil, internal.ErrCorruption
 	}
 
-	return block.New(p)
+	switch trailer[0] {
+	case kNoCompression:
+		return content, nil
+	case kSnappyCompression:
+		content, err := snappy.Decode(nil, content)
+		if err != nil {
+			return nil, internal.ErrCorruption
+		}
+		return content, nil
+	default:
+		return nil, internal.ErrCorruption
+	}
 }
 
-func Open(fileName string) (*SsTable, error) {
+// @description: read prefix bloom filter built by the prefix extractor
+// @note: meta blocks are not needed for operation, so the filter is ignored if there's any error
+
+func (table *SsTable) readFilter(prefixExtractor filter.PrefixExtractor) {
+	if prefixExtractor == nil || table.footer.MetaIndexHandle.Size == 0 {
+		return
+	}
+
+	content, err := table.readBlockContents(table.footer.MetaIndexHandle)
+	if err != nil {
+		return
+	}
+
+	// keys of meta index block are the raw names of meta blocks in leveldb format
+	var metaIndex *block.Block
+	if table.footer.Version == kFormatLevelDB {
+		metaIndex = block.NewLevelDBRaw(content)
+	} else {
+		metaIndex = block.New(content)
+	}
+	if metaIndex == nil {
+		return
+	}
+
+	name := []byte(kPrefixFilterPrefix + prefixExtractor.Name())
+	iter := metaIndex.NewIterator()
+	iter.Seek(name)
+	if !iter.Valid() || internal.UserKeyComparator(iter.InternalKey().UserKey, name) != 0 {
+		return
+	}
+
+	var handle BlockHandle
+	if _, err = handle.DecodeFromBytes(iter.InternalKey().UserValue); err != nil {
+		return
+	}
+	if table.filter, err = table.readBlockContents(handle); err == nil {
+		table.prefixExtractor = prefixExtractor
+	}
+}
+
+// @description: test if there may be any key with the prefix in table
+// @return: false if the prefix is definitely absent, true if there's no prefix bloom filter
+
+func (table *SsTable) PrefixMayMatch(prefix []byte) bool {
+	if table.prefixExtractor == nil {
+		return true
+	}
+	return filter.BloomMayContain(table.filter, prefix)
+}
+
+func Open(fileName string, opts *opt.Options) (*SsTable, error) {
 	var table SsTable
+	table.stats = opts.GetStatistics()
 	var err error
 
-	table.file, err = os.Open(fileName)
+	table.file, err = opts.GetEnv().NewRandomAccessFile(fileName)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			_ = table.file.Close()
+		}
+	}()
 
-	// 1. in case file is too short
-	stat, _ := table.file.Stat()
-	footerSize := int64(table.footer.Size())
-	if stat.Size() < footerSize {
-		return nil, internal.ErrTableTooShort
-	}
-
-	// 2. read footer block
-	_, err = table.file.Seek(-footerSize, io.SeekEnd)
+	// 1. read the tail of file which contains footer
+	size, err := table.file.Size()
 	if err != nil {
 		return nil, err
 	}
+	tailSize := int64(kFooterEncodedLength)
+	if size < tailSize {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if _, err = table.file.ReadAt(tail, size-tailSize); err != nil {
+		return nil, err
+	}
 
-	err = table.footer.DecodeFrom(table.file)
+	// 2. decode footer block, leveldb's magic number means leveldb format in compatible mode
+	err = table.footer.decode(tail, opts.GetLevelDBCompatible())
 	if err != nil {
 		return nil, err
 	}
 
+	// index block of legacy format is followed by footer and there's no meta block,
+	// otherwise it's likely a table of leveldb, which is only read in compatible mode
+	if table.footer.Version == kFormatLegacy {
+		indexEnd := table.footer.IndexHandle.Offset + table.footer.IndexHandle.Size
+		if table.footer.MetaIndexHandle != (BlockHandle{}) || indexEnd != uint64(size-kLegacyFooterEncodedLength) {
+			err = internal.ErrUnsupportedFormat
+			return nil, err
+		}
+	}
+
 	// 3. read index block
-	table.index = table.readBlock(table.footer.IndexHandle)
+	table.index, err = table.readBlock(table.footer.IndexHandle, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	// TODO: read meta block
+	// 4. read meta block
+	table.readFilter(opts.GetPrefixExtractor())
 
 	return &table, nil
 }
 
-func (table *SsTable) NewIterator() *Iterator {
+// @description: create an iterator of sstable
+// @param: read options, blocks entirely out of the bounds in it are not read, nil means no bounds
+
+func (table *SsTable) NewIterator(opts *opt.ReadOptions) *Iterator {
 	return &Iterator{
-		table:     table,
-		indexIter: table.index.NewIterator(),
+		table:             table,
+		indexIter:         table.index.NewIterator(),
+		lowerBound:        opts.GetLowerBound(),
+		upperBound:        opts.GetUpperBound(),
+		prefixSameAsStart: opts.GetPrefixSameAsStart(),
+		perf:              opts.GetPerfContext(),
 	}
 }
 
-func (table *SsTable) Get(target [] byte) ([]byte, error) {
-	iter := table.NewIterator()
+// @description: close the file of sstable, iterators of it must not be used after that
+
+func (table *SsTable) Close() error {
+	return table.file.Close()
+}
+
+// @description: get the newest entry of key in sstable
+// @param: the key and the perf context to record into, which may be nil
+// @return: the value, ErrDeletion if the key is deleted, or ErrNotFound
+
+func (table *SsTable) Get(target []byte, perf *statistics.PerfContext) ([]byte, error) {
+	iter := table.NewIterator(&opt.ReadOptions{PerfContext: perf})
+	defer iter.Close()
 	iter.Seek(target)
 
 	if iter.Valid() {
@@ -84,5 +236,29 @@ func (table *SsTable) Get(target [] byte) ([]byte, error) {
 		}
 	}
 
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
 	return nil, internal.ErrNotFound
 }
+
+// @description: estimate the offset in file where the data of key is, the data of keys after the last one is at the end of data blocks
+// @param: the user key
+// @return: the offset of the first data block whose last key >= key
+
+func (table *SsTable) ApproximateOffsetOf(key []byte) uint64 {
+	indexIter := table.index.NewIterator()
+	indexIter.Seek(key)
+	if indexIter.Valid() {
+		index := IndexBlockHandle{
+			InternalKey: indexIter.InternalKey(),
+		}
+		if handle, err := index.GetBlockHandle(table.footer.Version); err == nil {
+			return handle.Offset
+		}
+	}
+
+	// key is past the last key in table, or the index entry is broken
+	// the index block follows data blocks and meta blocks, which is close to the end of data
+	return table.footer.IndexHandle.Offset
+}
@@ -1,12 +1,14 @@
 package sstable
 
 import (
+	"github.com/jo3yzhu/goveldb/env"
 	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
 	"testing"
 )
 
 func Test_SsTable_Build(t *testing.T) {
-	builder := NewTableBuilder("./builder.db")
+	builder, _ := NewTableBuilder("./builder.db", &opt.Options{Env: env.NewMemEnv()})
 	item := internal.NewInternalKey(1, internal.TypeValue, []byte("123"), []byte("1234"))
 	builder.Add(item)
 	item = internal.NewInternalKey(2, internal.TypeValue, []byte("124"), []byte("1245"))
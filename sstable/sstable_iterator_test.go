@@ -1,12 +1,17 @@
 package sstable
 
 import (
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/filter"
 	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
 	"testing"
 )
 
 func Test_SsTable_Iterator(t *testing.T) {
-	builder := NewTableBuilder("000123.ldb")
+	opts := &opt.Options{Env: env.NewMemEnv()}
+	builder, _ := NewTableBuilder("000123.ldb", opts)
 	item := internal.NewInternalKey(1, internal.TypeValue, []byte("123"), []byte("1234"))
 	builder.Add(item)
 	item = internal.NewInternalKey(2, internal.TypeValue, []byte("124"), []byte("1245"))
@@ -19,12 +24,12 @@ func Test_SsTable_Iterator(t *testing.T) {
 		return
 	}
 
-	table, err := Open("000123.ldb")
+	table, err := Open("000123.ldb", opts)
 	if err != nil {
 		t.Fail()
 		return
 	}
-	it := table.NewIterator()
+	it := table.NewIterator(nil)
 
 	// seek lexicographically
 	it.Seek([]byte("1240000"))
@@ -36,3 +41,136 @@ func Test_SsTable_Iterator(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_SsTable_Iterator_Corruption(t *testing.T) {
+	fs := env.NewMemEnv()
+	opts := &opt.Options{Env: fs, LevelDBCompatible: true}
+	fileName := "000123.ldb"
+
+	builder, _ := NewTableBuilder(fileName, opts)
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%06d", i))
+		builder.Add(internal.NewInternalKey(uint64(i+1), internal.TypeValue, key, key))
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal("finish fail", err)
+	}
+
+	// flip a bit in the first data block
+	p, _ := env.ReadFile(fs, fileName)
+	p[10] ^= 1
+	_ = env.WriteFile(fs, fileName, p, false)
+
+	table, err := Open(fileName, opts)
+	if err != nil {
+		t.Fatal("open table fail", err)
+	}
+	it := table.NewIterator(nil)
+	defer it.Close()
+	it.SeekToFirst()
+	if it.Valid() || it.Error() != internal.ErrCorruption {
+		t.Fatal("corruption is not reported")
+	}
+	if _, err := table.Get([]byte("000000"), nil); err != internal.ErrCorruption {
+		t.Fatal("corruption is not reported by get")
+	}
+
+	// other blocks are still readable
+	if value, err := table.Get([]byte("000999"), nil); err != nil || string(value) != "000999" {
+		t.Fatal("get from intact block fail")
+	}
+}
+
+func Test_SsTable_Iterator_Bounds(t *testing.T) {
+	fs := env.NewMemEnv()
+	opts := &opt.Options{Env: fs, LevelDBCompatible: true}
+	fileName := "000123.ldb"
+
+	builder, _ := NewTableBuilder(fileName, opts)
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%06d", i))
+		builder.Add(internal.NewInternalKey(uint64(i+1), internal.TypeValue, key, key))
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal("finish fail", err)
+	}
+
+	// the first block is broken, but it's never read if it's out of bounds
+	p, _ := env.ReadFile(fs, fileName)
+	p[10] ^= 1
+	_ = env.WriteFile(fs, fileName, p, false)
+
+	table, err := Open(fileName, opts)
+	if err != nil {
+		t.Fatal("open table fail", err)
+	}
+	it := table.NewIterator(&opt.ReadOptions{LowerBound: []byte("000500")})
+	defer it.Close()
+	n := 0
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		n++
+	}
+	if it.Error() != nil || n == 0 || n >= 1000 {
+		t.Fatal("blocks before lower bound are read", n, it.Error())
+	}
+}
+
+func Test_SsTable_PrefixFilter(t *testing.T) {
+	for _, compatible := range []bool{false, true} {
+		fs := env.NewMemEnv()
+		opts := &opt.Options{
+			Env:               fs,
+			LevelDBCompatible: compatible,
+			PrefixExtractor:   filter.NewDelimiterPrefixExtractor(':'),
+		}
+		fileName := "000123.ldb"
+
+		builder, _ := NewTableBuilder(fileName, opts)
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("k%d:%04d", i/500+1, i))
+			builder.Add(internal.NewInternalKey(uint64(i+1), internal.TypeValue, key, key))
+		}
+		if err := builder.Finish(); err != nil {
+			t.Fatal("finish fail", err)
+		}
+
+		// the first block is broken, it's never read if the filter says the prefix is absent
+		p, _ := env.ReadFile(fs, fileName)
+		p[10] ^= 1
+		_ = env.WriteFile(fs, fileName, p, false)
+
+		table, err := Open(fileName, opts)
+		if err != nil {
+			t.Fatal("open table fail", err)
+		}
+		if !table.PrefixMayMatch([]byte("k1:")) || !table.PrefixMayMatch([]byte("k2:")) || table.PrefixMayMatch([]byte("k0:")) {
+			t.Fatal("prefix filter error")
+		}
+
+		it := table.NewIterator(&opt.ReadOptions{PrefixSameAsStart: true})
+		it.Seek([]byte("k0:0000"))
+		if it.Valid() || it.Error() != nil {
+			t.Fatal("table is not skipped by prefix filter", it.Error())
+		}
+		it.Close()
+
+		// the filter built by another extractor is ignored
+		table, err = Open(fileName, &opt.Options{
+			Env:               fs,
+			LevelDBCompatible: compatible,
+			PrefixExtractor:   filter.NewFixedPrefixExtractor(3),
+		})
+		if err != nil {
+			t.Fatal("open table fail", err)
+		}
+		if !table.PrefixMayMatch([]byte("k0:")) {
+			t.Fatal("mismatched filter is used")
+		}
+		it = table.NewIterator(&opt.ReadOptions{PrefixSameAsStart: true})
+		it.Seek([]byte("k0:0000"))
+		if it.Error() != internal.ErrCorruption {
+			t.Fatal("first block is not read", it.Error())
+		}
+		it.Close()
+	}
+}
@@ -1,45 +1,74 @@
 package sstable
 
 import (
+	"bytes"
+	"encoding/binary"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/filter"
 	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
 	"github.com/jo3yzhu/goveldb/sstable/block"
-	"os"
+	"github.com/jo3yzhu/goveldb/utils"
 )
 
 const (
 	MaxBlockSize = 4 * 1024
+
+	// restart interval of blocks in leveldb format
+	kDataBlockRestartInterval  = 16
+	kIndexBlockRestartInterval = 1
+
+	// prefix bloom filter is stored in meta block, whose key in meta index block is the prefix followed by name of extractor
+	kFilterBitsPerKey   = 10
+	kPrefixFilterPrefix = "prefixfilter."
 )
 
 // NOTE: sstable know nothing about sorting, so is TableBuilder
 
 type TableBuilder struct {
-	file               *os.File
-	offset             uint32             // current offset while writing
-	numEntries         int32              // counter
-	dataBlockBuilder   block.BlockBuilder // block builder for data block
-	indexBlockBuilder  block.BlockBuilder // block builder for index block
-	pendingIndexEntry  bool               // indicate a fresh block begin
+	file               env.WritableFile
+	offset             uint64        // current offset while writing
+	numEntries         int32         // counter
+	dataBlockBuilder   block.Builder // block builder for data block
+	indexBlockBuilder  block.Builder // block builder for index block
+	pendingIndexEntry  bool          // indicate a fresh block begin
 	pendingIndexHandle IndexBlockHandle
+	format             int // format version of the table
 	err                error
+	prefixExtractor    filter.PrefixExtractor
+	filterBuilder      *filter.BloomBuilder // prefixes of keys, nil if there's no prefix extractor
+	lastPrefix         []byte
 }
 
-
-func NewTableBuilder(fileName string) *TableBuilder {
+func NewTableBuilder(fileName string, opts *opt.Options) (*TableBuilder, error) {
 	var builder TableBuilder
 	var err error
-	builder.file, err = os.Create(fileName)
+	builder.file, err = opts.GetEnv().NewWritableFile(fileName)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	builder.pendingIndexEntry = false
-	return &builder
+
+	if opts.GetLevelDBCompatible() {
+		builder.format = kFormatLevelDB
+		builder.dataBlockBuilder = block.NewLevelDBBlockBuilder(kDataBlockRestartInterval)
+		builder.indexBlockBuilder = block.NewLevelDBBlockBuilder(kIndexBlockRestartInterval)
+	} else {
+		builder.format = kFormatChecksum
+		builder.dataBlockBuilder = new(block.BlockBuilder)
+		builder.indexBlockBuilder = new(block.BlockBuilder)
+	}
+
+	if builder.prefixExtractor = opts.GetPrefixExtractor(); builder.prefixExtractor != nil {
+		builder.filterBuilder = filter.NewBloomBuilder(kFilterBitsPerKey)
+	}
+	return &builder, nil
 }
 
-func (builder *TableBuilder) FileSize() uint32 {
+func (builder *TableBuilder) FileSize() uint64 {
 	return builder.offset
 }
 
-
 func (builder *TableBuilder) Add(internalKey *internal.InternalKey) {
 	if builder.err != nil {
 		return
@@ -47,11 +76,17 @@ func (builder *TableBuilder) Add(internalKey *internal.InternalKey) {
 
 	// the first time Add after flush and Finish leads to add internalKey to indexBlockBuilder
 	if builder.pendingIndexEntry {
-		builder.indexBlockBuilder.Add(builder.pendingIndexHandle.InternalKey)
-		builder.pendingIndexEntry = false
+		builder.addIndexEntry(internalKey)
 	}
 
-	// TODO: filter block
+	// keys with the same prefix are adjacent, so the prefix is added only once
+	if builder.prefixExtractor != nil && builder.prefixExtractor.InDomain(internalKey.UserKey) {
+		prefix := builder.prefixExtractor.Transform(internalKey.UserKey)
+		if builder.filterBuilder.Empty() || !bytes.Equal(prefix, builder.lastPrefix) {
+			builder.filterBuilder.Add(prefix)
+			builder.lastPrefix = append(builder.lastPrefix[:0], prefix...)
+		}
+	}
 
 	// updating pendingIndexHandle
 	builder.pendingIndexHandle.InternalKey = internalKey
@@ -59,7 +94,7 @@ func (builder *TableBuilder) Add(internalKey *internal.InternalKey) {
 	// append data block
 	builder.numEntries++
 	builder.dataBlockBuilder.Add(internalKey)
-	if builder.dataBlockBuilder.CurrentSizeEstimate() > MaxBlockSize {
+	if builder.dataBlockBuilder.CurrentSizeEstimate() >= MaxBlockSize {
 		builder.flush()
 	}
 }
@@ -76,43 +111,106 @@ func (builder *TableBuilder) flush() {
 	// dismiss value
 	builder.pendingIndexHandle.InternalKey = internal.NewInternalKey(orgKey.Seq, orgKey.Type, orgKey.UserKey, nil)
 	// write data block to file and set its handle to value
-	builder.pendingIndexHandle.SetBlockHandle(builder.writeBlock(&builder.dataBlockBuilder))
+	builder.pendingIndexHandle.SetBlockHandle(builder.writeBlock(builder.dataBlockBuilder))
 	builder.pendingIndexEntry = true
 }
 
+// @description: add the pending index entry of the last flushed data block
+// @param: the first key of the next data block, nil if there's no more block
+// @note: leveldb shortens the key to a separator between the two blocks, the index block is smaller and the table is byte-identical to leveldb's
+
+func (builder *TableBuilder) addIndexEntry(next *internal.InternalKey) {
+	if builder.format == kFormatLevelDB {
+		key := builder.pendingIndexHandle.InternalKey
+		if next != nil {
+			key = internal.FindShortestSeparator(key, next)
+		} else {
+			key = internal.FindShortSuccessor(key)
+		}
+		builder.pendingIndexHandle.InternalKey = internal.NewInternalKey(key.Seq, key.Type, key.UserKey, builder.pendingIndexHandle.UserValue)
+	}
+	builder.indexBlockBuilder.Add(builder.pendingIndexHandle.InternalKey)
+	builder.pendingIndexEntry = false
+}
+
 func (builder *TableBuilder) Finish() error {
 	// write data block
 	builder.flush()
 
 	// write index block
 	if builder.pendingIndexEntry {
-		builder.indexBlockBuilder.Add(builder.pendingIndexHandle.InternalKey)
-		builder.pendingIndexEntry = false
+		builder.addIndexEntry(nil)
 	}
 	var footer Footer
-	footer.IndexHandle = builder.writeBlock(&builder.indexBlockBuilder)
+	footer.Version = builder.format
+
+	// write filter block and meta index block, leveldb always writes meta index block even if it's empty
+	var metaIndexBlockBuilder block.Builder
+	if builder.format == kFormatLevelDB {
+		metaIndexBlockBuilder = block.NewLevelDBRawBlockBuilder(kDataBlockRestartInterval)
+	} else {
+		metaIndexBlockBuilder = new(block.BlockBuilder)
+	}
+	if builder.filterBuilder != nil && !builder.filterBuilder.Empty() {
+		filterHandle := builder.writeRawBlock(builder.filterBuilder.Finish())
+		metaIndexBlockBuilder.Add(&internal.InternalKey{
+			Type:      internal.TypeValue,
+			UserKey:   []byte(kPrefixFilterPrefix + builder.prefixExtractor.Name()),
+			UserValue: filterHandle.EncodeToBytes(),
+		})
+	}
+	if builder.format == kFormatLevelDB || !metaIndexBlockBuilder.Empty() {
+		footer.MetaIndexHandle = builder.writeBlock(metaIndexBlockBuilder)
+	}
+	footer.IndexHandle = builder.writeBlock(builder.indexBlockBuilder)
 
 	// write footer, footer needs to know where index block is
-	_ = footer.EncodeTo(builder.file)
-	_ = builder.file.Close()
-	return nil
+	if builder.err == nil {
+		builder.err = footer.EncodeTo(builder.file)
+	}
+	builder.offset += kFooterEncodedLength
+
+	// the table must be durable before it's referred by manifest
+	if builder.err == nil {
+		builder.err = builder.file.Sync()
+	}
+	if err := builder.file.Close(); builder.err == nil {
+		builder.err = err
+	}
+	return builder.err
 }
 
-func (builder *TableBuilder) writeBlock(blockBuilder *block.BlockBuilder) BlockHandle {
+func (builder *TableBuilder) writeBlock(blockBuilder block.Builder) BlockHandle {
 
 	// Finish function will append nums of entries of block at end
 	// It's not the BlockBuilder's Finish instead of TableBuilder's Finish
-	content := blockBuilder.Finish()
+	blockHandle := builder.writeRawBlock(blockBuilder.Finish())
+	blockBuilder.Reset()
+	return blockHandle
+}
+
+// @description: write the contents of a block to file
+// @return: the block handle of it
 
-	// TODO: compress and crc
+func (builder *TableBuilder) writeRawBlock(content []byte) BlockHandle {
+	// TODO: compress
 
 	var blockHandle BlockHandle
 	blockHandle.Offset = builder.offset
-	blockHandle.Size = uint32(len(content))
-	builder.offset += uint32(len(content))
-	_, builder.err = builder.file.Write(content)
-	_ = builder.file.Sync()
-	blockBuilder.Reset()
+	blockHandle.Size = uint64(len(content))
+
+	// block is followed by trailer of compression type and crc, which is not counted in block handle
+	if builder.format != kFormatVarint {
+		var trailer [kBlockTrailerSize]byte
+		trailer[0] = kNoCompression
+		binary.LittleEndian.PutUint32(trailer[1:], utils.MaskCrc(utils.Crc32c(content, trailer[:1])))
+		content = append(content, trailer[:]...)
+	}
+
+	builder.offset += uint64(len(content))
+	if builder.err == nil {
+		_, builder.err = builder.file.Write(content)
+	}
 
 	return blockHandle
-}
\ No newline at end of file
+}
@@ -0,0 +1,114 @@
+// Histogram counts values in buckets of exponentially growing limits: 1, 2, 5, 10, 20, 50 ... 5e9, values greater than the last limit are in an extra bucket
+// Each field is updated with atomics, so a snapshot may be slightly inconsistent when values are added concurrently
+
+package statistics
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+var bucketLimits = func() []uint64 {
+	var limits []uint64
+	for scale := uint64(1); scale <= 1e9; scale *= 10 {
+		limits = append(limits, scale, 2*scale, 5*scale)
+	}
+	return limits
+}()
+
+// the zero value is an empty histogram, min is kept inverted so that it's not mistaken for a value of 0 before any value is added
+
+type histogram struct {
+	count   uint64
+	sum     uint64
+	invMin  uint64 // ^min, the greatest inverted value is the least value
+	max     uint64
+	buckets [31]uint64 // the last one is for values greater than all limits
+}
+
+func (h *histogram) add(value uint64) {
+	i := 0
+	for i < len(bucketLimits) && value > bucketLimits[i] {
+		i++
+	}
+	atomic.AddUint64(&h.buckets[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, value)
+
+	for {
+		invMin := atomic.LoadUint64(&h.invMin)
+		if ^value <= invMin || atomic.CompareAndSwapUint64(&h.invMin, invMin, ^value) {
+			break
+		}
+	}
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if value <= max || atomic.CompareAndSwapUint64(&h.max, max, value) {
+			break
+		}
+	}
+}
+
+func (h *histogram) data() HistogramData {
+	data := HistogramData{
+		Count: atomic.LoadUint64(&h.count),
+		Sum:   atomic.LoadUint64(&h.sum),
+		Min:   ^atomic.LoadUint64(&h.invMin),
+		Max:   atomic.LoadUint64(&h.max),
+	}
+	for i := range h.buckets {
+		data.Buckets[i] = atomic.LoadUint64(&h.buckets[i])
+	}
+	if data.Count == 0 {
+		data.Min = 0
+	}
+	return data
+}
+
+// HistogramData is a snapshot of histogram
+
+type HistogramData struct {
+	Count   uint64
+	Sum     uint64
+	Min     uint64
+	Max     uint64
+	Buckets [31]uint64 // counts of values <= each limit and > the previous one
+}
+
+func (data *HistogramData) Average() float64 {
+	if data.Count == 0 {
+		return 0
+	}
+	return float64(data.Sum) / float64(data.Count)
+}
+
+// @description: estimate a percentile by interpolating in the bucket where it is
+// @param: the percentile in [0, 100]
+
+func (data *HistogramData) Percentile(p float64) float64 {
+	if data.Count == 0 {
+		return 0
+	}
+
+	threshold := float64(data.Count) * p / 100
+	var cumulative uint64
+	for i, n := range data.Buckets {
+		if n == 0 {
+			continue
+		}
+		if float64(cumulative+n) >= threshold {
+			left := float64(0)
+			if i > 0 {
+				left = float64(bucketLimits[i-1])
+			}
+			right := float64(data.Max)
+			if i < len(bucketLimits) {
+				right = float64(bucketLimits[i])
+			}
+			result := left + (right-left)*(threshold-float64(cumulative))/float64(n)
+			return math.Max(float64(data.Min), math.Min(result, float64(data.Max)))
+		}
+		cumulative += n
+	}
+	return float64(data.Max)
+}
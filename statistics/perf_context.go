@@ -0,0 +1,106 @@
+// PerfContext traces a single operation, such as a Get call or an iterator, it's passed by ReadOptions or context.Context
+// It's not safe for concurrent use, so each goroutine should have its own one, and a nil *PerfContext records nothing
+
+package statistics
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type PerfContext struct {
+	MemtablesConsulted   uint64 // memtable and immutable memtable searched by Get
+	Level0FilesConsulted uint64 // files in level0 searched by Get
+	DeeperFilesConsulted uint64 // files in level1 or deeper searched by Get
+	BlocksRead           uint64 // data and index blocks read from sstables
+	BlockReadBytes       uint64 // bytes of blocks read from disk
+	BytesDecoded         uint64 // bytes of blocks after they are checked and uncompressed
+	BloomChecks          uint64 // prefix bloom filters checked by Seek
+	BloomUseful          uint64 // sstables skipped by prefix bloom filter
+
+	MemtableGetTime time.Duration // time of searching memtables in Get
+	TableGetTime    time.Duration // time of searching sstables in Get, including reading blocks
+	BlockReadTime   time.Duration // time of reading, checking and uncompressing blocks
+	GetTime         time.Duration // total time of Get
+}
+
+func (pc *PerfContext) Reset() {
+	if pc != nil {
+		*pc = PerfContext{}
+	}
+}
+
+// @description: record a block read from sstable
+// @param: the bytes read from disk, the bytes of block contents and the time spent
+
+func (pc *PerfContext) RecordBlockRead(readBytes, decodedBytes uint64, d time.Duration) {
+	if pc == nil {
+		return
+	}
+	pc.BlocksRead++
+	pc.BlockReadBytes += readBytes
+	pc.BytesDecoded += decodedBytes
+	pc.BlockReadTime += d
+}
+
+// @description: record a check of prefix bloom filter
+// @param: if the sstable is skipped by the filter
+
+func (pc *PerfContext) RecordBloomCheck(useful bool) {
+	if pc == nil {
+		return
+	}
+	pc.BloomChecks++
+	if useful {
+		pc.BloomUseful++
+	}
+}
+
+// @description: format the non-zero counters and times, which is handy to attach to a trace span
+// @return: the text like "memtables_consulted=2 level0_files_consulted=1 get_time=35µs"
+
+func (pc *PerfContext) String() string {
+	if pc == nil {
+		return ""
+	}
+
+	var s string
+	add := func(name string, value interface{}, zero bool) {
+		if zero {
+			return
+		}
+		if s != "" {
+			s += " "
+		}
+		s += fmt.Sprintf("%s=%v", name, value)
+	}
+	add("memtables_consulted", pc.MemtablesConsulted, pc.MemtablesConsulted == 0)
+	add("level0_files_consulted", pc.Level0FilesConsulted, pc.Level0FilesConsulted == 0)
+	add("deeper_files_consulted", pc.DeeperFilesConsulted, pc.DeeperFilesConsulted == 0)
+	add("blocks_read", pc.BlocksRead, pc.BlocksRead == 0)
+	add("block_read_bytes", pc.BlockReadBytes, pc.BlockReadBytes == 0)
+	add("bytes_decoded", pc.BytesDecoded, pc.BytesDecoded == 0)
+	add("bloom_checks", pc.BloomChecks, pc.BloomChecks == 0)
+	add("bloom_useful", pc.BloomUseful, pc.BloomUseful == 0)
+	add("memtable_get_time", pc.MemtableGetTime, pc.MemtableGetTime == 0)
+	add("table_get_time", pc.TableGetTime, pc.TableGetTime == 0)
+	add("block_read_time", pc.BlockReadTime, pc.BlockReadTime == 0)
+	add("get_time", pc.GetTime, pc.GetTime == 0)
+	return s
+}
+
+type perfContextKey struct{}
+
+// @description: attach a perf context to ctx, operations taking ctx record into it
+
+func WithPerfContext(ctx context.Context, pc *PerfContext) context.Context {
+	return context.WithValue(ctx, perfContextKey{}, pc)
+}
+
+// @return: the perf context attached to ctx, nil if there's none
+
+func PerfContextFromContext(ctx context.Context) *PerfContext {
+	pc, _ := ctx.Value(perfContextKey{}).(*PerfContext)
+	return pc
+}
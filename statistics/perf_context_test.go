@@ -0,0 +1,36 @@
+package statistics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_PerfContext(t *testing.T) {
+	pc := &PerfContext{MemtablesConsulted: 2}
+	pc.RecordBlockRead(100, 200, time.Millisecond)
+	pc.RecordBloomCheck(true)
+	pc.RecordBloomCheck(false)
+	if pc.BlocksRead != 1 || pc.BlockReadBytes != 100 || pc.BytesDecoded != 200 || pc.BloomChecks != 2 || pc.BloomUseful != 1 {
+		t.Fatal("perf context error", pc)
+	}
+	expected := "memtables_consulted=2 blocks_read=1 block_read_bytes=100 bytes_decoded=200 bloom_checks=2 bloom_useful=1 block_read_time=1ms"
+	if pc.String() != expected {
+		t.Fatal("perf context format error", pc.String())
+	}
+	pc.Reset()
+	if pc.String() != "" {
+		t.Fatal("perf context is not reset")
+	}
+
+	// nil perf context records nothing
+	var nilPc *PerfContext
+	nilPc.RecordBlockRead(1, 1, 0)
+	nilPc.RecordBloomCheck(true)
+	nilPc.Reset()
+
+	ctx := WithPerfContext(context.Background(), pc)
+	if PerfContextFromContext(ctx) != pc || PerfContextFromContext(context.Background()) != nil {
+		t.Fatal("perf context of context error")
+	}
+}
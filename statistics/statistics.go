@@ -0,0 +1,209 @@
+// Statistics collects tickers and histograms of database, it's shared by all goroutines and updated with atomics
+// A nil *Statistics is valid and records nothing, so that the paths of database don't need to check if it's enabled
+// The zero value of Statistics is ready to use as the one returned by New
+
+package statistics
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Ticker is a counter which only increases
+
+type Ticker int
+
+const (
+	KeysWritten       Ticker = iota // number of keys written by Put, Delete and Write
+	BytesWritten                    // bytes of write batches
+	KeysRead                        // number of Get calls
+	BytesRead                       // bytes of values returned by Get
+	MemtableHit                     // Get is answered by memtable or immutable memtable
+	MemtableMiss                    // Get goes to sstables
+	GetHitL0                        // Get is answered by a file in level0
+	GetHitL1                        // Get is answered by a file in level1
+	GetHitL2AndUp                   // Get is answered by a file in level2 or deeper
+	TableCacheHit                   // sstable is found opened in table cache, it's not a block cache, which goveldb doesn't have
+	TableCacheMiss                  // sstable is opened from disk, its blocks are still read from disk on each access
+	BlockRead                       // number of blocks read from disk, goveldb has no block cache so every block access is a read
+	BlockReadBytes                  // bytes of blocks read from disk
+	BloomUseful                     // sstable is skipped by prefix bloom filter
+	StallMicros                     // time writers are delayed or stopped by write stalls
+	FlushWriteBytes                 // bytes of level0 tables written by flush
+	CompactReadBytes                // bytes of input files read by compaction
+	CompactWriteBytes               // bytes of output files written by compaction
+	tickerCount
+)
+
+var tickerNames = [tickerCount]string{
+	KeysWritten:       "goveldb.keys.written",
+	BytesWritten:      "goveldb.bytes.written",
+	KeysRead:          "goveldb.keys.read",
+	BytesRead:         "goveldb.bytes.read",
+	MemtableHit:       "goveldb.memtable.hit",
+	MemtableMiss:      "goveldb.memtable.miss",
+	GetHitL0:          "goveldb.l0.hit",
+	GetHitL1:          "goveldb.l1.hit",
+	GetHitL2AndUp:     "goveldb.l2andup.hit",
+	TableCacheHit:     "goveldb.table.cache.hit",
+	TableCacheMiss:    "goveldb.table.cache.miss",
+	BlockRead:         "goveldb.block.read",
+	BlockReadBytes:    "goveldb.block.read.bytes",
+	BloomUseful:       "goveldb.bloom.filter.useful",
+	StallMicros:       "goveldb.stall.micros",
+	FlushWriteBytes:   "goveldb.flush.write.bytes",
+	CompactReadBytes:  "goveldb.compact.read.bytes",
+	CompactWriteBytes: "goveldb.compact.write.bytes",
+}
+
+func (t Ticker) String() string {
+	return tickerNames[t]
+}
+
+// Histogram is a distribution of values, which are latencies in microseconds
+
+type Histogram int
+
+const (
+	DbGet            Histogram = iota // latency of Get
+	DbPut                             // latency of Put
+	DbDelete                          // latency of Delete
+	DbWrite                           // latency of Write, including the ones called by Put and Delete
+	DbSeek                            // latency of Seek of iterator
+	TableReadMicros                   // latency of reading a block from sstable
+	FlushMicros                       // duration of flush
+	CompactionMicros                  // duration of compaction
+	histogramCount
+)
+
+var histogramNames = [histogramCount]string{
+	DbGet:            "goveldb.db.get.micros",
+	DbPut:            "goveldb.db.put.micros",
+	DbDelete:         "goveldb.db.delete.micros",
+	DbWrite:          "goveldb.db.write.micros",
+	DbSeek:           "goveldb.db.seek.micros",
+	TableReadMicros:  "goveldb.table.read.micros",
+	FlushMicros:      "goveldb.flush.micros",
+	CompactionMicros: "goveldb.compaction.micros",
+}
+
+func (h Histogram) String() string {
+	return histogramNames[h]
+}
+
+type Statistics struct {
+	tickers    [tickerCount]uint64
+	histograms [histogramCount]histogram
+}
+
+func New() *Statistics {
+	return &Statistics{}
+}
+
+// @description: add n to a ticker
+
+func (s *Statistics) RecordTick(t Ticker, n uint64) {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.tickers[t], n)
+}
+
+func (s *Statistics) GetTickerCount(t Ticker) uint64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.tickers[t])
+}
+
+// @description: add a value to a histogram
+
+func (s *Statistics) RecordHistogram(h Histogram, value uint64) {
+	if s == nil {
+		return
+	}
+	s.histograms[h].add(value)
+}
+
+// @description: add a duration in microseconds to a histogram
+
+func (s *Statistics) MeasureTime(h Histogram, d time.Duration) {
+	s.RecordHistogram(h, uint64(d.Microseconds()))
+}
+
+// @description: add the time since start in microseconds to a histogram, start is zero if statistics is disabled
+// @note: it's used with Start as defer s.MeasureSince(h, s.Start()), so that time is not read if statistics is disabled
+
+func (s *Statistics) MeasureSince(h Histogram, start time.Time) {
+	if s == nil {
+		return
+	}
+	s.MeasureTime(h, time.Since(start))
+}
+
+func (s *Statistics) Start() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (s *Statistics) GetHistogram(h Histogram) HistogramData {
+	if s == nil {
+		return HistogramData{}
+	}
+	return s.histograms[h].data()
+}
+
+// @description: dump all tickers and histograms as text, a line for each of them
+// @return: the text like
+//		goveldb.keys.written COUNT : 100
+//		goveldb.db.get.micros P50 : 1.000000 P95 : 2.000000 P99 : 3.000000 P100 : 5.000000 COUNT : 10 SUM : 15
+
+func (s *Statistics) String() string {
+	var b strings.Builder
+	for t := Ticker(0); t < tickerCount; t++ {
+		fmt.Fprintf(&b, "%s COUNT : %d\n", t, s.GetTickerCount(t))
+	}
+	for h := Histogram(0); h < histogramCount; h++ {
+		data := s.GetHistogram(h)
+		fmt.Fprintf(&b, "%s P50 : %f P95 : %f P99 : %f P100 : %f COUNT : %d SUM : %d\n",
+			h, data.Percentile(50), data.Percentile(95), data.Percentile(99), float64(data.Max), data.Count, data.Sum)
+	}
+	return b.String()
+}
+
+// @description: write all tickers and histograms in prometheus text exposition format
+//				 names are converted to prometheus style, such as goveldb_keys_written_total and goveldb_db_get_micros
+// @return: error of writer if any
+
+func (s *Statistics) WritePrometheus(w io.Writer) error {
+	var b strings.Builder
+	for t := Ticker(0); t < tickerCount; t++ {
+		name := prometheusName(t.String()) + "_total"
+		fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+		fmt.Fprintf(&b, "%s %d\n", name, s.GetTickerCount(t))
+	}
+	for h := Histogram(0); h < histogramCount; h++ {
+		name := prometheusName(h.String())
+		data := s.GetHistogram(h)
+		fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+		var cumulative uint64
+		for i, limit := range bucketLimits {
+			cumulative += data.Buckets[i]
+			fmt.Fprintf(&b, "%s_bucket{le=\"%d\"} %d\n", name, limit, cumulative)
+		}
+		fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %d\n", name, data.Count)
+		fmt.Fprintf(&b, "%s_sum %d\n", name, data.Sum)
+		fmt.Fprintf(&b, "%s_count %d\n", name, data.Count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func prometheusName(name string) string {
+	return strings.ReplaceAll(name, ".", "_")
+}
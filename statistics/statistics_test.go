@@ -0,0 +1,103 @@
+package statistics
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Statistics(t *testing.T) {
+	s := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := uint64(1); v <= 100; v++ {
+				s.RecordTick(KeysWritten, 1)
+				s.RecordHistogram(DbGet, v)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s.GetTickerCount(KeysWritten) != 1000 {
+		t.Fatal("ticker count error", s.GetTickerCount(KeysWritten))
+	}
+	data := s.GetHistogram(DbGet)
+	if data.Count != 1000 || data.Sum != 50500 || data.Min != 1 || data.Max != 100 || data.Average() != 50.5 {
+		t.Fatal("histogram data error", data)
+	}
+	if p := data.Percentile(50); p < 40 || p > 60 {
+		t.Fatal("median error", p)
+	}
+	if data.Percentile(100) != 100 || data.Percentile(0) != 1 {
+		t.Fatal("percentile bounds error", data.Percentile(0), data.Percentile(100))
+	}
+
+	// empty histogram
+	if data := s.GetHistogram(DbPut); data.Min != 0 || data.Percentile(99) != 0 {
+		t.Fatal("empty histogram error", data)
+	}
+}
+
+func Test_Statistics_Nil(t *testing.T) {
+	var s *Statistics
+	s.RecordTick(KeysRead, 1)
+	s.MeasureSince(DbGet, s.Start())
+	if s.GetTickerCount(KeysRead) != 0 || s.GetHistogram(DbGet).Count != 0 || !s.Start().IsZero() {
+		t.Fatal("nil statistics records")
+	}
+}
+
+func Test_Statistics_ZeroValue(t *testing.T) {
+	var s Statistics
+	s.RecordHistogram(DbGet, 5)
+	s.RecordHistogram(DbGet, 3)
+	s.RecordHistogram(DbGet, 8)
+	if data := s.GetHistogram(DbGet); data.Count != 3 || data.Min != 3 || data.Max != 8 || data.Percentile(0) != 3 {
+		t.Fatal("histogram of zero value statistics error", data)
+	}
+
+	// a value of 0 is the least one, and the greatest value is kept as min if it's the only one
+	s.RecordHistogram(DbPut, ^uint64(0))
+	s.RecordHistogram(DbDelete, 0)
+	if s.GetHistogram(DbPut).Min != ^uint64(0) || s.GetHistogram(DbDelete).Min != 0 || s.GetHistogram(DbDelete).Count != 1 {
+		t.Fatal("min of histogram error", s.GetHistogram(DbPut), s.GetHistogram(DbDelete))
+	}
+	if data := s.GetHistogram(DbWrite); data.Count != 0 || data.Min != 0 {
+		t.Fatal("empty histogram error", data)
+	}
+}
+
+func Test_Statistics_Dump(t *testing.T) {
+	s := New()
+	s.RecordTick(BytesRead, 42)
+	s.MeasureTime(CompactionMicros, 3*time.Millisecond)
+
+	text := s.String()
+	if !strings.Contains(text, "goveldb.bytes.read COUNT : 42\n") || !strings.Contains(text, "goveldb.compaction.micros P50") {
+		t.Fatal("text dump error", text)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WritePrometheus(&buf); err != nil {
+		t.Fatal("write prometheus fail", err)
+	}
+	for _, line := range []string{
+		"# TYPE goveldb_bytes_read_total counter\n",
+		"goveldb_bytes_read_total 42\n",
+		"# TYPE goveldb_compaction_micros histogram\n",
+		"goveldb_compaction_micros_bucket{le=\"2000\"} 0\n",
+		"goveldb_compaction_micros_bucket{le=\"5000\"} 1\n",
+		"goveldb_compaction_micros_bucket{le=\"+Inf\"} 1\n",
+		"goveldb_compaction_micros_sum 3000\n",
+		"goveldb_compaction_micros_count 1\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatal("prometheus line is missing", line)
+		}
+	}
+}
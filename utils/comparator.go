@@ -1,22 +1,27 @@
 package utils
 
 // Comparator is used in sorted data structure, in goveldb, it's skip list
-// Skip list use IntComparator to determine how to sort kv pair whose key type is int
+// @return: +1 if a > b, -1 if a < b, 0 if a == b
 
-type Comparator func(lhs, rhs interface{}) int
+type Comparator[T any] func(lhs, rhs T) int
 
-// @description:
-// @return: +1 if a > b, -1 if a < b, 0 if a == b
+// Ordered is a constraint of types which can be compared by operator < and >
 
-func IntComparator(a, b interface{}) int {
-	l := a.(int)
-	r := b.(int)
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 | ~string
+}
+
+// @description: compare values of ordered type by operator, skip list use it to sort keys like int or string
+// @return: +1 if a > b, -1 if a < b, 0 if a == b
 
+func OrderedComparator[T Ordered](l, r T) int {
 	switch {
 	case l > r:
 		return +1
 	case l < r:
-		return -1;
+		return -1
 	default:
 		return 0
 	}
@@ -0,0 +1,33 @@
+package utils
+
+import "hash/crc32"
+
+// crc32c is used to protect records in log files as leveldb does
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+const kMaskDelta = 0xa282ead8
+
+// @description: compute the crc32c of the concatenation of all the slices
+
+func Crc32c(p ...[]byte) uint32 {
+	var crc uint32
+	for _, b := range p {
+		crc = crc32.Update(crc, castagnoliTable, b)
+	}
+	return crc
+}
+
+// @description: return a masked representation of crc
+// @note: it's problematic to compute the crc of a string that contains embedded crc, so crc stored somewhere should be masked
+
+func MaskCrc(crc uint32) uint32 {
+	return ((crc >> 15) | (crc << 17)) + kMaskDelta
+}
+
+// @description: return the crc whose masked representation is maskedCrc
+
+func UnmaskCrc(maskedCrc uint32) uint32 {
+	rot := maskedCrc - kMaskDelta
+	return (rot >> 17) | (rot << 15)
+}
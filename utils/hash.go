@@ -0,0 +1,34 @@
+package utils
+
+import "encoding/binary"
+
+// @description: the hash function of leveldb, which is similar to murmur hash
+// @param: the bytes to hash and the seed
+
+func Hash(data []byte, seed uint32) uint32 {
+	const m = 0xc6a4a793
+	const r = 24
+	h := seed ^ uint32(len(data))*m
+
+	// pick up four bytes at a time
+	for ; len(data) >= 4; data = data[4:] {
+		h += binary.LittleEndian.Uint32(data)
+		h *= m
+		h ^= h >> 16
+	}
+
+	// pick up remaining bytes
+	switch len(data) {
+	case 3:
+		h += uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(data[0])
+		h *= m
+		h ^= h >> r
+	}
+	return h
+}
@@ -1,12 +1,32 @@
 package version
 
 import (
+	"context"
+	"github.com/jo3yzhu/goveldb/event"
 	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/logger"
 	"github.com/jo3yzhu/goveldb/memtable"
 	"github.com/jo3yzhu/goveldb/sstable"
-	"log"
+	"time"
 )
 
+// CompactionStats is the cost of a compaction, which is accumulated into the output level
+
+type CompactionStats struct {
+	Level        int // the level which the output files are written in
+	Duration     time.Duration
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// @description: accumulate the cost of another compaction into stats
+
+func (stats *CompactionStats) Add(other *CompactionStats) {
+	stats.Duration += other.Duration
+	stats.BytesRead += other.BytesRead
+	stats.BytesWritten += other.BytesWritten
+}
+
 type Compaction struct {
 	level  int
 	inputs [2][]*FileMetaData
@@ -19,44 +39,75 @@ func (c *Compaction) isTrivialMove() bool {
 	return len(c.inputs[0]) == 1 && len(c.inputs[1]) == 0
 }
 
-func (c *Compaction) Log() {
-	log.Printf("Compaction, level:%d", c.level)
-	for i := 0; i < len(c.inputs[0]); i++ {
-		log.Printf("inputs[0]: %d", c.inputs[0][i].number)
-	}
-	for i := 0; i < len(c.inputs[1]); i++ {
-		log.Printf("inputs[1]: %d", c.inputs[1][i].number)
+// @description: log the level and input file numbers of compaction
+
+func (c *Compaction) Log(infoLog logger.Logger) {
+	infoLog.Log(logger.Info, "compaction begin", "level", c.level,
+		"inputs0", fileNumbers(c.inputs[0]), "inputs1", fileNumbers(c.inputs[1]))
+}
+
+func fileNumbers(files []*FileMetaData) []uint64 {
+	numbers := make([]uint64, len(files))
+	for i, f := range files {
+		numbers[i] = f.number
 	}
+	return numbers
 }
 
 // @description: write the immutable to level0 into this version, which is known as minor compaction
 // @param: the memtable needed to be written
+// @return: the stats of compaction and error if the sstable can't be written, and the version is not modified
 
-func (v *Version) WriteLevel0Table(imm *memtable.MemTable) {
+func (v *Version) WriteLevel0Table(imm *memtable.MemTable) (*CompactionStats, error) {
+	start := time.Now()
 
 	// generate sstable builder for writing sstable
 	var meta FileMetaData
 	meta.allowSeeks = 1 << 30
 	meta.number = v.nextFileNumber
 	v.nextFileNumber++
-	builder := sstable.NewTableBuilder(internal.TableFileName(v.tableCache.dbName, meta.number))
+	info := event.FlushInfo{DbName: v.tableCache.dbName, FileNumber: meta.number}
+	v.listeners().OnFlushBegin(info)
+	end := func(stats *CompactionStats, err error) (*CompactionStats, error) {
+		info.Duration = time.Since(start)
+		info.Err = err
+		v.listeners().OnFlushEnd(info)
+		return stats, err
+	}
+
+	fileName := internal.TableFileName(v.tableCache.dbName, meta.number)
+	builder, err := sstable.NewTableBuilder(fileName, v.tableCache.opts)
+	if err != nil {
+		v.listeners().OnTableFileCreated(v.tableFileInfo(meta.number, 0, err))
+		return end(nil, err)
+	}
 
 	// iterate memtable
 	iter := imm.NewIterator()
+	defer iter.Close()
 	iter.SeekToFirst()
-	if iter.Valid() {
-		meta.smallest = iter.InternalKey()
-		for ; iter.Valid(); iter.Next() {
-			meta.largest = iter.InternalKey()
-			builder.Add(iter.InternalKey())
-		}
+	if !iter.Valid() {
 		_ = builder.Finish()
-		meta.fileSize = uint64(builder.FileSize())
+		return end(&CompactionStats{Duration: time.Since(start)}, v.removeTable(meta.number))
+	}
 
-		// is this necessary?
-		meta.largest.UserValue = nil
-		meta.smallest.UserValue = nil
+	smallest := iter.InternalKey()
+	largest := smallest
+	for ; iter.Valid(); iter.Next() {
+		largest = iter.InternalKey()
+		builder.Add(largest)
 	}
+	if err := builder.Finish(); err != nil {
+		_ = v.env().RemoveFile(fileName)
+		v.listeners().OnTableFileCreated(v.tableFileInfo(meta.number, 0, err))
+		return end(nil, err)
+	}
+	meta.fileSize = uint64(builder.FileSize())
+	v.listeners().OnTableFileCreated(v.tableFileInfo(meta.number, meta.fileSize, nil))
+
+	// keys of memtable refer to its arena, copy them without value so that the arena can be released
+	meta.smallest = internal.NewInternalKey(smallest.Seq, smallest.Type, smallest.UserKey, nil)
+	meta.largest = internal.NewInternalKey(largest.Seq, largest.Type, largest.UserKey, nil)
 
 	// pick a level for writing
 	level := 0
@@ -70,6 +121,76 @@ func (v *Version) WriteLevel0Table(imm *memtable.MemTable) {
 	}
 
 	v.addFile(level, &meta)
+	v.infoLog().Log(logger.Info, "level0 table written", "file", meta.number, "level", level, "size", meta.fileSize)
+	info.Level = level
+	info.Size = meta.fileSize
+	return end(&CompactionStats{
+		Level:        level,
+		Duration:     time.Since(start),
+		BytesWritten: meta.fileSize,
+	}, nil)
+}
+
+// @description: remove a table file which is not added to version
+// @return: error if it can't be removed
+
+func (v *Version) removeTable(number uint64) error {
+	err := v.env().RemoveFile(internal.TableFileName(v.tableCache.dbName, number))
+	v.listeners().OnTableFileDeleted(v.tableFileInfo(number, 0, err))
+	return err
+}
+
+// @description: reopen a table written by compaction and read all entries of it, which is done in paranoid mode
+// @param: the meta of table
+// @return: internal.ErrCorruption if the size, order or range of entries mismatches the meta, or error of reading
+
+func (v *Version) verifyTable(meta *FileMetaData) error {
+	fileName := internal.TableFileName(v.tableCache.dbName, meta.number)
+	size, err := v.env().GetFileSize(fileName)
+	if err != nil {
+		return err
+	}
+	if uint64(size) != meta.fileSize {
+		return internal.ErrCorruption
+	}
+
+	// the table is opened bypassing table cache, so that it's read from file
+	table, err := sstable.Open(fileName, v.tableCache.opts)
+	if err != nil {
+		return err
+	}
+	defer table.Close()
+	iter := table.NewIterator(nil)
+	defer iter.Close()
+
+	var prev *internal.InternalKey // keys of iterator refer to the block, which is released when moving to the next one
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		key := iter.InternalKey()
+		if prev == nil && internal.InternalKeyComparator(key, meta.smallest) != 0 {
+			return internal.ErrCorruption
+		}
+		if prev != nil && internal.InternalKeyComparator(prev, key) >= 0 {
+			return internal.ErrCorruption
+		}
+		prev = internal.NewInternalKey(key.Seq, key.Type, key.UserKey, nil)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if prev == nil || internal.InternalKeyComparator(prev, meta.largest) != 0 {
+		return internal.ErrCorruption
+	}
+	return nil
+}
+
+func (v *Version) tableFileInfo(number, size uint64, err error) event.TableFileInfo {
+	return event.TableFileInfo{
+		DbName:     v.tableCache.dbName,
+		FileNumber: number,
+		FileName:   internal.TableFileName(v.tableCache.dbName, number),
+		Size:       size,
+		Err:        err,
+	}
 }
 
 // @description: calculate total file size of a level and then choose one to compact
@@ -167,109 +288,260 @@ func (v *Version) pickCompaction() *Compaction {
 		largest = c.inputs[0][0].largest
 	}
 
-	// to find out all sstable files in level + 1 which are overlapped with previous level
-	// if any, put it into c.inputs[1]
+	v.setupOtherInputs(&c, smallest.UserKey, largest.UserKey)
+	return &c
+}
+
+// @description: to find out all sstable files in level + 1 which are overlapped with the inputs of level
+//               if any, put it into c.inputs[1]
+// @param: the compaction and the user key range of its inputs in level
+
+func (v *Version) setupOtherInputs(c *Compaction, smallest, largest []byte) {
 	for i := 0; i < len(v.files[c.level+1]); i++ {
 		f := v.files[c.level+1][i]
 
 		// completely before specified range, skip it,
-		if internal.InternalKeyComparator(f.largest, smallest) < 0 {
+		if internal.UserKeyComparator(f.largest.UserKey, smallest) < 0 {
 			continue
 			// completely after specified range; skip it,
-		} else if internal.InternalKeyComparator(f.smallest, largest) > 0 {
+		} else if internal.UserKeyComparator(f.smallest.UserKey, largest) > 0 {
 			continue
 		} else {
 			c.inputs[1] = append(c.inputs[1], f)
 		}
 	}
+}
+
+// @description: pick files in level overlapping the user key range [start, limit] to compact, which is known as manual compaction
+// @param: the level and the range, nil start means before all keys and nil limit means after all keys
+// @return: the compaction, nil if no file overlaps the range
+// @note: all of the files in level0 are picked if any of them overlaps the range,
+//        otherwise an older file left in level0 may shadow the newer data compacted into level1
 
+func (v *Version) pickRangeCompaction(level int, start, limit []byte) *Compaction {
+	var c Compaction
+	c.level = level
+
+	overlapped := false
+	for _, f := range v.files[level] {
+		if start != nil && internal.UserKeyComparator(f.largest.UserKey, start) < 0 {
+			continue
+		}
+		if limit != nil && internal.UserKeyComparator(f.smallest.UserKey, limit) > 0 {
+			continue
+		}
+		overlapped = true
+		if level > 0 {
+			c.inputs[0] = append(c.inputs[0], f)
+		}
+	}
+	if !overlapped {
+		return nil
+	}
+	if level == 0 {
+		c.inputs[0] = append(c.inputs[0], v.files[0]...)
+	}
+
+	smallest, largest := c.inputs[0][0].smallest.UserKey, c.inputs[0][0].largest.UserKey
+	for _, f := range c.inputs[0][1:] {
+		if internal.UserKeyComparator(f.smallest.UserKey, smallest) < 0 {
+			smallest = f.smallest.UserKey
+		}
+		if internal.UserKeyComparator(f.largest.UserKey, largest) > 0 {
+			largest = f.largest.UserKey
+		}
+	}
+	v.setupOtherInputs(&c, smallest, largest)
 	return &c
 }
 
-func (v *Version) makeInputIterator(c *Compaction) *MergingIterator {
-	var list []*sstable.Iterator
+// @description: test if no file in levels deeper than the output level of compaction may contain the key
+// @note: a deletion can be dropped by compaction only if there's no older entry of the key in deeper levels
 
-	// load iterators of sstable files to be merged into memory and construct a MergingIterator
-	for i := 0; i < len(c.inputs[0]); i++ {
-		list = append(list, v.tableCache.NewIterator(c.inputs[0][i].number))
+func (v *Version) isBaseLevelForKey(c *Compaction, key []byte) bool {
+	for level := c.level + 2; level < internal.NumLevels; level++ {
+		files := v.files[level]
+		index := findFile(files, key)
+		if index < len(files) && internal.UserKeyComparator(key, files[index].smallest.UserKey) >= 0 {
+			return false
+		}
 	}
-	for i := 0; i < len(c.inputs[1]); i++ {
-		list = append(list, v.tableCache.NewIterator(c.inputs[1][i].number))
+	return true
+}
+
+func (v *Version) makeInputIterator(c *Compaction) *MergingIterator {
+	var list []internal.InternalIterator
+
+	// files in level0 may overlap each other, so each of them is iterated alone
+	// files in other levels are concatenated and opened one by one when reached
+	if c.level == 0 {
+		for i := 0; i < len(c.inputs[0]); i++ {
+			list = append(list, v.tableCache.NewIterator(c.inputs[0][i].number, nil))
+		}
+	} else {
+		list = append(list, NewLevelIterator(v.tableCache, c.inputs[0], nil))
 	}
+	list = append(list, NewLevelIterator(v.tableCache, c.inputs[1], nil))
 	return NewMergingIterator(list)
 }
 
 // @description: compact the inputs sstable file picked by v.pickCompaction
-// @note1: new sstable file should be created if newly merged file has reached the limit size of sstable file
+// @return: the stats of compaction, nil if there's nothing to compact or the compaction is aborted, and error if it's aborted
 
-func (v *Version) DoCompactionWork() bool {
+func (v *Version) DoCompactionWork() (*CompactionStats, error) {
 	c := v.pickCompaction()
 	if c == nil {
-		return false
+		return nil, nil
 	}
+	return v.doCompaction(context.Background(), c)
+}
+
+// @description: compact files in level overlapping the user key range [start, limit] into level + 1
+// @param: the context which aborts the compaction when it's done, the level and the range, nil means unbounded
+// @return: the stats of compaction, nil if no file overlaps the range or the compaction is aborted, and error if it's aborted
 
-	log.Printf("DoCompactionWork begin\n")
-	defer log.Printf("DoCompactionWork end\n")
-	c.Log()
+func (v *Version) CompactRange(ctx context.Context, level int, start, limit []byte) (*CompactionStats, error) {
+	c := v.pickRangeCompaction(level, start, limit)
+	if c == nil {
+		return nil, nil
+	}
+	return v.doCompaction(ctx, c)
+}
+
+// @description: merge the inputs of compaction into new files in the next level
+// @param: the context which aborts the compaction when it's done and the compaction
+// @note1: new sstable file should be created if newly merged file has reached the limit size of sstable file
+// @note2: the version is not modified if the compaction is aborted, and the newly created files are removed
+
+func (v *Version) doCompaction(ctx context.Context, c *Compaction) (*CompactionStats, error) {
+	start := time.Now()
+
+	c.Log(v.infoLog())
+	info := event.CompactionInfo{
+		DbName:      v.tableCache.dbName,
+		Level:       c.level,
+		OutputLevel: c.level + 1,
+		InputFiles:  [2][]uint64{fileNumbers(c.inputs[0]), fileNumbers(c.inputs[1])},
+	}
+	v.listeners().OnCompactionBegin(info)
 
 	if c.isTrivialMove() {
 		// just move it to next level
 		v.deleteFile(c.level, c.inputs[0][0])
 		v.addFile(c.level+1, c.inputs[0][0])
-		return true
+		info.OutputFiles = info.InputFiles[0]
+		info.Duration = time.Since(start)
+		v.listeners().OnCompactionEnd(info)
+		return &CompactionStats{Level: c.level + 1, Duration: info.Duration}, nil
 	}
 
 	var list []*FileMetaData             // newly merged sstable
+	var building uint64                  // number of the file being built, it's not in list until it's finished
 	var currentKey *internal.InternalKey // to remove duplicated internal key
 	iter := v.makeInputIterator(c)
+	defer iter.Close()
+
+	abort := func(err error) (*CompactionStats, error) {
+		v.infoLog().Log(logger.Error, "compaction aborted", "level", c.level, "error", err)
+		if building != 0 {
+			_ = v.env().RemoveFile(internal.TableFileName(v.tableCache.dbName, building))
+		}
+		for _, meta := range list {
+			_ = v.removeTable(meta.number)
+		}
+		info.Duration = time.Since(start)
+		info.Err = err
+		v.listeners().OnCompactionEnd(info)
+		return nil, err
+	}
 
 	// begin to create a new merged sstable
 	// internal keys of the same user key are sorted by seq in sstable, so for the same user key, the newer one has older seq
-	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+	for iter.SeekToFirst(); iter.Valid(); {
 		var meta FileMetaData
 		meta.allowSeeks = 1 << 30
 		meta.number = v.nextFileNumber
 		v.nextFileNumber++
 
 		fileName := internal.TableFileName(v.tableCache.dbName, meta.number)
-		builder := sstable.NewTableBuilder(fileName)
-		meta.smallest = iter.InternalKey()
+		builder, err := sstable.NewTableBuilder(fileName, v.tableCache.opts)
+		if err != nil {
+			v.listeners().OnTableFileCreated(v.tableFileInfo(meta.number, 0, err))
+			return abort(err)
+		}
+		building = meta.number
 
 		for ; iter.Valid(); iter.Next() {
-			// bug fix
-			if iter.InternalKey().Type == internal.TypeDeletion {
-				continue
+			select {
+			case <-ctx.Done():
+				return abort(ctx.Err())
+			default:
 			}
 
 			if currentKey != nil {
-				if iter.InternalKey().Type == internal.TypeDeletion {
-					continue
-				}
 				// the older key input by user may be overwritten, so UserKey comparison is needed instead of InternalKey comparison
 				duplicated := internal.UserKeyComparator(iter.InternalKey().UserKey, currentKey.UserKey)
 				if duplicated == 0 {
 					continue
 				} else if duplicated < 0 {
-					log.Fatalf("%s < %s", string(iter.InternalKey().UserKey), string(currentKey.UserKey))
+					// inputs are out of order, which means some of them are broken
+					v.infoLog().Log(logger.Error, "keys out of order in compaction inputs",
+						"key", iter.InternalKey().UserKey, "previous", currentKey.UserKey)
+					return abort(internal.ErrCorruption)
 				}
 			}
-			// TODO: maybe fix a bug
+			// the newest entry of a user key hides the older ones, even if it's a deletion
 			currentKey = iter.InternalKey()
+
+			// a deletion is useless if there's no older entry of the key in deeper levels
+			if currentKey.Type == internal.TypeDeletion && v.isBaseLevelForKey(c, currentKey.UserKey) {
+				continue
+			}
+			// the first entry of iterator may be dropped, the smallest one is the first one added
+			if meta.smallest == nil {
+				meta.smallest = iter.InternalKey()
+			}
 			meta.largest = iter.InternalKey()
 			builder.Add(iter.InternalKey())
 
 			// a newly merged file cannot be too large in compaction
 			if builder.FileSize() > internal.MaxFileSize {
+				iter.Next()
 				break
 			}
 		}
 
-		builder.Finish()
+		if err := builder.Finish(); err != nil {
+			v.listeners().OnTableFileCreated(v.tableFileInfo(meta.number, 0, err))
+			return abort(err)
+		}
+		building = 0
 		meta.fileSize = uint64(builder.FileSize())
-		meta.smallest.UserKey = nil
-		meta.largest.UserKey = nil
+		v.listeners().OnTableFileCreated(v.tableFileInfo(meta.number, meta.fileSize, nil))
 
+		// all of the rest keys are deleted, no need to keep the empty file
+		if meta.largest == nil {
+			_ = v.removeTable(meta.number)
+			continue
+		}
 		list = append(list, &meta)
+
+		// keys of iterator refer to the block, copy them without value
+		meta.smallest = internal.NewInternalKey(meta.smallest.Seq, meta.smallest.Type, meta.smallest.UserKey, nil)
+		meta.largest = internal.NewInternalKey(meta.largest.Seq, meta.largest.Type, meta.largest.UserKey, nil)
+	}
+
+	// any input may be broken, and the merged files are incomplete
+	if err := iter.Error(); err != nil {
+		return abort(err)
+	}
+	if v.tableCache.opts.GetParanoidChecks() {
+		for _, meta := range list {
+			if err := v.verifyTable(meta); err != nil {
+				v.infoLog().Log(logger.Error, "compaction output is broken", "file", meta.number, "error", err)
+				return abort(err)
+			}
+		}
 	}
 
 	// the files after merged would be ignored in version instance instead of deleted
@@ -285,5 +557,18 @@ func (v *Version) DoCompactionWork() bool {
 		v.addFile(c.level+1, list[i])
 	}
 
-	return true
+	stats := &CompactionStats{
+		Level:        c.level + 1,
+		Duration:     time.Since(start),
+		BytesRead:    totalFileSize(c.inputs[0]) + totalFileSize(c.inputs[1]),
+		BytesWritten: totalFileSize(list),
+	}
+	v.infoLog().Log(logger.Info, "compaction end", "level", stats.Level, "outputs", fileNumbers(list),
+		"read", stats.BytesRead, "written", stats.BytesWritten, "duration", stats.Duration)
+	info.OutputFiles = fileNumbers(list)
+	info.BytesRead = stats.BytesRead
+	info.BytesWritten = stats.BytesWritten
+	info.Duration = stats.Duration
+	v.listeners().OnCompactionEnd(info)
+	return stats, nil
 }
@@ -0,0 +1,293 @@
+package version
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/event"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/logger"
+	"github.com/jo3yzhu/goveldb/memtable"
+	"github.com/jo3yzhu/goveldb/opt"
+	"github.com/jo3yzhu/goveldb/sstable"
+	"strings"
+	"testing"
+)
+
+func Test_Compaction_Corruption(t *testing.T) {
+	fs := env.NewMemEnv()
+	dbName := "/corruption"
+	_ = fs.CreateDir(dbName)
+	v := New(dbName, &opt.Options{Env: fs, LevelDBCompatible: true})
+
+	// overlapping files in level0 trigger a compaction
+	numFiles := internal.L0CompactionTrigger + 1
+	for i := 0; i < numFiles; i++ {
+		memTable := memtable.New()
+		for j := 0; j < 100; j++ {
+			key := []byte(fmt.Sprintf("%04d", j))
+			memTable.Add(uint64(i*100+j+1), internal.TypeValue, key, key)
+		}
+		if _, err := v.WriteLevel0Table(memTable); err != nil {
+			t.Fatal("write table fail", err)
+		}
+	}
+
+	// the first ones are pushed to deeper levels, put them back to level0
+	for level := 1; level < internal.NumLevels; level++ {
+		v.files[0] = append(v.files[0], v.files[level]...)
+		v.files[level] = nil
+	}
+
+	// flip a bit in the data block of a file
+	fileName := internal.TableFileName(dbName, v.files[0][1].number)
+	p, _ := env.ReadFile(fs, fileName)
+	p[10] ^= 1
+	_ = env.WriteFile(fs, fileName, p, false)
+
+	entries, _ := fs.GetChildren(dbName)
+	stats, err := v.DoCompactionWork()
+	if stats != nil || err != internal.ErrCorruption {
+		t.Fatal("compaction should be aborted", err)
+	}
+
+	// version is not modified and the merged files are removed
+	if v.NumLevelFiles(0) != numFiles || v.NumLevelFiles(1) != 0 {
+		t.Fatal("version is modified by aborted compaction")
+	}
+	if after, _ := fs.GetChildren(dbName); len(after) != len(entries) {
+		t.Fatal("merged files are not removed")
+	}
+}
+
+func Test_Compaction_OutOfOrder(t *testing.T) {
+	fs := env.NewMemEnv()
+	dbName := "/outoforder"
+	_ = fs.CreateDir(dbName)
+	var buf bytes.Buffer
+	v := New(dbName, &opt.Options{Env: fs, InfoLog: logger.New(&buf, logger.Info)})
+
+	numFiles := internal.L0CompactionTrigger + 1
+	for i := 0; i < numFiles; i++ {
+		memTable := memtable.New()
+		for j := 0; j < 100; j++ {
+			key := []byte(fmt.Sprintf("%04d", j))
+			memTable.Add(uint64(i*100+j+1), internal.TypeValue, key, key)
+		}
+		if _, err := v.WriteLevel0Table(memTable); err != nil {
+			t.Fatal("write table fail", err)
+		}
+	}
+	for level := 1; level < internal.NumLevels; level++ {
+		v.files[0] = append(v.files[0], v.files[level]...)
+		v.files[level] = nil
+	}
+
+	// rewrite a file with keys out of order, the compaction is aborted instead of terminating the process
+	builder, _ := sstable.NewTableBuilder(internal.TableFileName(dbName, v.files[0][1].number), &opt.Options{Env: fs})
+	for _, key := range []string{"0001", "0000"} {
+		builder.Add(internal.NewInternalKey(1000, internal.TypeValue, []byte(key), []byte(key)))
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal("finish fail", err)
+	}
+
+	stats, err := v.DoCompactionWork()
+	if stats != nil || err != internal.ErrCorruption {
+		t.Fatal("compaction should be aborted", err)
+	}
+	if !strings.Contains(buf.String(), "ERROR keys out of order") {
+		t.Fatal("error is not logged", buf.String())
+	}
+}
+
+type recordingListener struct {
+	event.BaseListener
+	flushes     []event.FlushInfo
+	compactions []event.CompactionInfo
+	created     []uint64
+	deleted     []uint64
+}
+
+func (l *recordingListener) OnFlushEnd(info event.FlushInfo) {
+	l.flushes = append(l.flushes, info)
+}
+
+func (l *recordingListener) OnCompactionEnd(info event.CompactionInfo) {
+	l.compactions = append(l.compactions, info)
+}
+
+func (l *recordingListener) OnTableFileCreated(info event.TableFileInfo) {
+	l.created = append(l.created, info.FileNumber)
+}
+
+func (l *recordingListener) OnTableFileDeleted(info event.TableFileInfo) {
+	l.deleted = append(l.deleted, info.FileNumber)
+}
+
+func Test_Compaction_Events(t *testing.T) {
+	listener := &recordingListener{}
+	fs := env.NewMemEnv()
+	_ = fs.CreateDir("/events")
+	v := New("/events", &opt.Options{Env: fs, EventListeners: []event.Listener{listener}})
+
+	numFiles := internal.L0CompactionTrigger + 1
+	for i := 0; i < numFiles; i++ {
+		memTable := memtable.New()
+		for j := 0; j < 100; j++ {
+			key := []byte(fmt.Sprintf("%04d", j))
+			memTable.Add(uint64(i*100+j+1), internal.TypeValue, key, key)
+		}
+		if _, err := v.WriteLevel0Table(memTable); err != nil {
+			t.Fatal("write table fail", err)
+		}
+	}
+	for level := 1; level < internal.NumLevels; level++ {
+		v.files[0] = append(v.files[0], v.files[level]...)
+		v.files[level] = nil
+	}
+	if len(listener.flushes) != numFiles || len(listener.created) != numFiles || listener.flushes[0].Size == 0 {
+		t.Fatal("flush events error", listener.flushes)
+	}
+
+	stats, err := v.DoCompactionWork()
+	if stats == nil || err != nil {
+		t.Fatal("compaction fail", err)
+	}
+	if len(listener.compactions) != 1 {
+		t.Fatal("compaction events error")
+	}
+	info := listener.compactions[0]
+	if info.Level != 0 || info.OutputLevel != 1 || len(info.InputFiles[0]) != numFiles || info.Err != nil {
+		t.Fatal("compaction info error", info)
+	}
+	if len(info.OutputFiles) != 1 || listener.created[numFiles] != info.OutputFiles[0] || info.BytesWritten != stats.BytesWritten {
+		t.Fatal("output files error", info.OutputFiles, listener.created)
+	}
+
+	// an empty memtable is flushed into a removed table
+	if _, err := v.WriteLevel0Table(memtable.New()); err != nil || len(listener.deleted) != 1 {
+		t.Fatal("empty table is not deleted", err)
+	}
+}
+
+func Test_Compaction_CompactRange(t *testing.T) {
+	fs := env.NewMemEnv()
+	_ = fs.CreateDir("/compact")
+	v := New("/compact", &opt.Options{Env: fs})
+
+	// the values are pushed to level2, and the deletions hiding some of them are pushed to level1
+	for i, valueType := range []internal.ValueType{internal.TypeValue, internal.TypeDeletion} {
+		memTable := memtable.New()
+		for j := 0; j < 100; j += i + 1 {
+			key := []byte(fmt.Sprintf("%04d", j))
+			memTable.Add(uint64(i*100+j+1), valueType, key, key)
+		}
+		if _, err := v.WriteLevel0Table(memTable); err != nil {
+			t.Fatal("write table fail", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if v.NumLevelFiles(1) != 1 || v.NumLevelFiles(2) != 1 {
+		t.Fatal("tables are not pushed to deeper levels")
+	}
+	if stats, err := v.CompactRange(ctx, 1, nil, nil); stats != nil || err != context.Canceled {
+		t.Fatal("compaction should be cancelled", err)
+	}
+	if v.NumLevelFiles(1) != 1 || v.NumLevelFiles(2) != 1 {
+		t.Fatal("version is modified by cancelled compaction")
+	}
+
+	if stats, _ := v.CompactRange(context.Background(), 1, []byte("9999"), nil); stats != nil {
+		t.Fatal("no file overlaps the range")
+	}
+	stats, err := v.CompactRange(context.Background(), 1, []byte("0010"), []byte("0020"))
+	if err != nil || stats == nil {
+		t.Fatal("compact range fail", err)
+	}
+	if v.NumLevelFiles(1) != 0 || v.NumLevelFiles(2) != 1 {
+		t.Fatal("level1 files are not compacted")
+	}
+	for j := 0; j < 100; j++ {
+		_, err := v.Get([]byte(fmt.Sprintf("%04d", j)), nil)
+		if j%2 == 0 && err == nil {
+			t.Fatal("deleted key is resurrected", j)
+		} else if j%2 == 1 && err != nil {
+			t.Fatal("get fail", j, err)
+		}
+	}
+}
+
+// corruptingEnv flips a bit in the first write of files created after it's armed, as a broken disk does
+
+type corruptingEnv struct {
+	env.Env
+	armed bool
+}
+
+type corruptingFile struct {
+	env.WritableFile
+	corrupted bool
+}
+
+func (e *corruptingEnv) NewWritableFile(name string) (env.WritableFile, error) {
+	file, err := e.Env.NewWritableFile(name)
+	if err != nil || !e.armed {
+		return file, err
+	}
+	return &corruptingFile{WritableFile: file}, nil
+}
+
+func (f *corruptingFile) Write(p []byte) (int, error) {
+	if f.corrupted || len(p) == 0 {
+		return f.WritableFile.Write(p)
+	}
+	f.corrupted = true
+	q := append([]byte(nil), p...)
+	q[0] ^= 1
+	return f.WritableFile.Write(q)
+}
+
+func Test_Compaction_ParanoidChecks(t *testing.T) {
+	for _, paranoid := range []bool{false, true} {
+		fs := &corruptingEnv{Env: env.NewMemEnv()}
+		dbName := "/paranoid"
+		_ = fs.CreateDir(dbName)
+		v := New(dbName, &opt.Options{Env: fs, LevelDBCompatible: true, ParanoidChecks: paranoid})
+
+		numFiles := internal.L0CompactionTrigger + 1
+		for i := 0; i < numFiles; i++ {
+			memTable := memtable.New()
+			for j := 0; j < 100; j++ {
+				key := []byte(fmt.Sprintf("%04d", j))
+				memTable.Add(uint64(i*100+j+1), internal.TypeValue, key, key)
+			}
+			if _, err := v.WriteLevel0Table(memTable); err != nil {
+				t.Fatal("write table fail", err)
+			}
+		}
+		for level := 1; level < internal.NumLevels; level++ {
+			v.files[0] = append(v.files[0], v.files[level]...)
+			v.files[level] = nil
+		}
+
+		// the merged file is broken while it's written, which is found only by reading it back
+		fs.armed = true
+		stats, err := v.DoCompactionWork()
+		if !paranoid {
+			if err != nil || stats == nil {
+				t.Fatal("compaction fail", err)
+			}
+			continue
+		}
+		if stats != nil || err != internal.ErrCorruption {
+			t.Fatal("broken output is not detected", err)
+		}
+		if v.NumLevelFiles(0) != numFiles || v.NumLevelFiles(1) != 0 {
+			t.Fatal("version is modified by aborted compaction")
+		}
+	}
+}
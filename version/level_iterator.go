@@ -0,0 +1,160 @@
+// Files in levels other than level0 are sorted and don't overlap each other, so a level can be iterated as a two-level iterator:
+// the file meta data list is the index, and each sstable is opened through table cache only when it's reached
+
+package version
+
+import (
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
+	"sort"
+)
+
+var _ internal.InternalIterator = (*LevelIterator)(nil)
+
+type LevelIterator struct {
+	tableCache *TableCache
+	opts       *opt.ReadOptions
+	files      []*FileMetaData // files overlapping with the bounds of read options
+	index      int             // index of current file, out of range if the iterator is invalid
+	dataIter   internal.InternalIterator
+	err        error // error of opening or reading a file, the iterator stops at the broken file
+}
+
+// @param: table cache, sorted files of a level and read options, files entirely out of the bounds in it are never opened
+
+func NewLevelIterator(tableCache *TableCache, files []*FileMetaData, opts *opt.ReadOptions) *LevelIterator {
+	if lowerBound := opts.GetLowerBound(); lowerBound != nil {
+		files = files[findFile(files, lowerBound):]
+	}
+	if upperBound := opts.GetUpperBound(); upperBound != nil {
+		files = files[:sort.Search(len(files), func(i int) bool {
+			return internal.UserKeyComparator(files[i].smallest.UserKey, upperBound) >= 0
+		})]
+	}
+
+	return &LevelIterator{
+		tableCache: tableCache,
+		opts:       opts,
+		files:      files,
+		index:      len(files),
+	}
+}
+
+func (iter *LevelIterator) Valid() bool {
+	return iter.err == nil && iter.dataIter != nil && iter.dataIter.Valid()
+}
+
+func (iter *LevelIterator) InternalKey() *internal.InternalKey {
+	return iter.dataIter.InternalKey()
+}
+
+// @description: open the sstable of current file, the opened one is reused if it's still current
+
+func (iter *LevelIterator) initDataIter(index int) {
+	if iter.dataIter != nil && iter.index == index {
+		return
+	}
+
+	if iter.dataIter != nil {
+		iter.dataIter.Close()
+		iter.dataIter = nil
+	}
+	iter.index = index
+	if index >= 0 && index < len(iter.files) {
+		iter.dataIter = iter.tableCache.NewIterator(iter.files[index].number, iter.opts)
+	}
+}
+
+// @description: stop at current file if it has error
+// @return: if there's error
+
+func (iter *LevelIterator) checkError() bool {
+	if iter.err == nil && iter.dataIter != nil {
+		iter.err = iter.dataIter.Error()
+	}
+	return iter.err != nil
+}
+
+func (iter *LevelIterator) skipEmptyFilesForward() {
+	for iter.dataIter == nil || !iter.dataIter.Valid() {
+		if iter.checkError() {
+			return
+		}
+		if iter.index+1 >= len(iter.files) {
+			iter.initDataIter(len(iter.files))
+			return
+		}
+		iter.initDataIter(iter.index + 1)
+		if iter.dataIter != nil {
+			iter.dataIter.SeekToFirst()
+		}
+	}
+}
+
+func (iter *LevelIterator) skipEmptyFilesBackward() {
+	for iter.dataIter == nil || !iter.dataIter.Valid() {
+		if iter.checkError() {
+			return
+		}
+		if iter.index-1 < 0 {
+			iter.initDataIter(-1)
+			return
+		}
+		iter.initDataIter(iter.index - 1)
+		if iter.dataIter != nil {
+			iter.dataIter.SeekToLast()
+		}
+	}
+}
+
+func (iter *LevelIterator) Seek(target []byte) {
+	iter.err = nil
+	// the first file whose largest key >= target may contain target
+	iter.initDataIter(findFile(iter.files, target))
+	if iter.dataIter != nil {
+		iter.dataIter.Seek(target)
+	}
+
+	// the file is skipped by prefix bloom filter, and the following files have no key with the prefix either
+	if iter.opts.GetPrefixSameAsStart() {
+		iter.checkError()
+		return
+	}
+	iter.skipEmptyFilesForward()
+}
+
+func (iter *LevelIterator) SeekToFirst() {
+	iter.err = nil
+	iter.initDataIter(0)
+	if iter.dataIter != nil {
+		iter.dataIter.SeekToFirst()
+	}
+	iter.skipEmptyFilesForward()
+}
+
+func (iter *LevelIterator) SeekToLast() {
+	iter.err = nil
+	iter.initDataIter(len(iter.files) - 1)
+	if iter.dataIter != nil {
+		iter.dataIter.SeekToLast()
+	}
+	iter.skipEmptyFilesBackward()
+}
+
+func (iter *LevelIterator) Next() {
+	iter.dataIter.Next()
+	iter.skipEmptyFilesForward()
+}
+
+func (iter *LevelIterator) Prev() {
+	iter.dataIter.Prev()
+	iter.skipEmptyFilesBackward()
+}
+
+func (iter *LevelIterator) Error() error {
+	return iter.err
+}
+
+func (iter *LevelIterator) Close() {
+	iter.initDataIter(len(iter.files))
+}
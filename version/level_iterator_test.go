@@ -0,0 +1,88 @@
+package version
+
+import (
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/memtable"
+	"github.com/jo3yzhu/goveldb/opt"
+	"sort"
+	"testing"
+)
+
+func Test_LevelIterator(t *testing.T) {
+	fs := env.NewMemEnv()
+	_ = fs.CreateDir("/level")
+	v := New("/level", &opt.Options{Env: fs})
+
+	// write disjoint files, each of them contains 10 keys
+	var files []*FileMetaData
+	for i := 0; i < 10; i++ {
+		memTable := memtable.New()
+		for j := 0; j < 10; j++ {
+			key := []byte(fmt.Sprintf("%04d", i*10+j))
+			memTable.Add(uint64(i*10+j+1), internal.TypeValue, key, key)
+		}
+		if _, err := v.WriteLevel0Table(memTable); err != nil {
+			t.Fatal("write table fail", err)
+		}
+	}
+	for level := 0; level < internal.NumLevels; level++ {
+		files = append(files, v.files[level]...)
+	}
+	if len(files) != 10 {
+		t.Fatal("write table error")
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return internal.UserKeyComparator(files[i].smallest.UserKey, files[j].smallest.UserKey) < 0
+	})
+
+	iter := NewLevelIterator(v.tableCache, files, nil)
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if string(iter.InternalKey().UserKey) != fmt.Sprintf("%04d", i) {
+			t.Fatal("forward order error", i)
+		}
+		i++
+	}
+	if i != 100 {
+		t.Fatal("forward count error", i)
+	}
+
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		i--
+		if string(iter.InternalKey().UserKey) != fmt.Sprintf("%04d", i) {
+			t.Fatal("backward order error", i)
+		}
+	}
+
+	// seek to the boundary of files
+	iter.Seek([]byte("00295"))
+	if !iter.Valid() || string(iter.InternalKey().UserKey) != "0030" {
+		t.Fatal("seek error")
+	}
+	iter.Prev()
+	if !iter.Valid() || string(iter.InternalKey().UserKey) != "0029" {
+		t.Fatal("prev across files error")
+	}
+}
+
+func Test_LevelIterator_Bounds(t *testing.T) {
+	var files []*FileMetaData
+	for i := 0; i < 10; i++ {
+		files = append(files, &FileMetaData{
+			number:   uint64(i),
+			smallest: internal.NewInternalKey(0, internal.TypeValue, []byte(fmt.Sprintf("%04d", i*10)), nil),
+			largest:  internal.NewInternalKey(0, internal.TypeValue, []byte(fmt.Sprintf("%04d", i*10+9)), nil),
+		})
+	}
+
+	// files entirely out of bounds are never opened
+	iter := NewLevelIterator(nil, files, &opt.ReadOptions{
+		LowerBound: []byte("0015"),
+		UpperBound: []byte("0040"),
+	})
+	if len(iter.files) != 3 || iter.files[0].number != 1 || iter.files[2].number != 3 {
+		t.Fatal("files out of bounds are not skipped")
+	}
+}
@@ -1,41 +1,58 @@
 // Merge sort among inputs in Compaction instance is implemented by MergingIterator
-// Internal keys in different sstable can be iterated in order with mergingIterator
+// Internal keys in different iterators can be iterated in order with mergingIterator
+// Children are kept in a heap, which is a min heap when moving forward and a max heap when moving backward
 
 package version
 
 import (
+	"container/heap"
 	"github.com/jo3yzhu/goveldb/internal"
-	"github.com/jo3yzhu/goveldb/sstable"
 )
 
+const (
+	kForward = iota
+	kReverse
+)
+
+var _ internal.InternalIterator = (*MergingIterator)(nil)
+
 type MergingIterator struct {
-	list    []*sstable.Iterator
-	current *sstable.Iterator
+	list      []internal.InternalIterator
+	current   internal.InternalIterator
+	direction int
+	heap      iteratorHeap // valid children, current one is at the top of it
 }
 
-func NewMergingIterator(list []*sstable.Iterator) *MergingIterator {
+func NewMergingIterator(list []internal.InternalIterator) *MergingIterator {
 	return &MergingIterator{
 		list: list,
+		heap: iteratorHeap{items: make([]internal.InternalIterator, 0, len(list))},
 	}
 }
 
-// @description: detect the iterator with smallest internal key among iterator list
+// @description: rebuild the heap of valid children in certain direction, and the top of it becomes current
 
-func (iter *MergingIterator) findSmallest() {
-	var smallest *sstable.Iterator = nil
-	for i := 0; i < len(iter.list); i++ {
-		if iter.list[i].Valid() {
-			if smallest == nil {
-				smallest = iter.list[i]
-			} else {
-				if internal.InternalKeyComparator(iter.list[i].InternalKey(), smallest.InternalKey()) < 0 {
-					smallest = iter.list[i]
-				}
-			}
+func (iter *MergingIterator) rebuild(direction int) {
+	iter.direction = direction
+	iter.heap.reverse = direction == kReverse
+	iter.heap.items = iter.heap.items[:0]
+	for _, child := range iter.list {
+		if child.Valid() {
+			iter.heap.items = append(iter.heap.items, child)
 		}
 	}
+	heap.Init(&iter.heap)
+	iter.findCurrent()
+}
+
+// @description: the top of heap is the smallest one when forward and the largest one when backward
 
-	iter.current = smallest
+func (iter *MergingIterator) findCurrent() {
+	if iter.heap.Len() == 0 {
+		iter.current = nil
+	} else {
+		iter.current = iter.heap.items[0]
+	}
 }
 
 func (iter *MergingIterator) Valid() bool {
@@ -47,15 +64,139 @@ func (iter *MergingIterator) InternalKey() *internal.InternalKey {
 }
 
 func (iter *MergingIterator) SeekToFirst() {
-	for i := 0; i < len(iter.list); i++ {
-		iter.list[i].SeekToFirst()
+	for _, child := range iter.list {
+		child.SeekToFirst()
+	}
+	iter.rebuild(kForward)
+}
+
+func (iter *MergingIterator) SeekToLast() {
+	for _, child := range iter.list {
+		child.SeekToLast()
+	}
+	iter.rebuild(kReverse)
+}
+
+func (iter *MergingIterator) Seek(target []byte) {
+	for _, child := range iter.list {
+		child.Seek(target)
 	}
-	iter.findSmallest()
+	iter.rebuild(kForward)
 }
 
 func (iter *MergingIterator) Next() {
-	if iter.current != nil {
-		iter.current.Next() // advance the smallest iterator
+	// all children other than current are before current when moving backward,
+	// so they need to be positioned at the first entry after current
+	if iter.direction != kForward {
+		key := iter.current.InternalKey()
+		for _, child := range iter.list {
+			if child == iter.current {
+				continue
+			}
+			child.Seek(key.UserKey)
+			for child.Valid() && internal.InternalKeyComparator(child.InternalKey(), key) <= 0 {
+				child.Next()
+			}
+		}
+		iter.current.Next()
+		iter.rebuild(kForward)
+		return
 	}
-	iter.findSmallest() // then find the least large iterator
+
+	// advance the smallest iterator, and then find the least large iterator
+	iter.current.Next()
+	if iter.current.Valid() {
+		heap.Fix(&iter.heap, 0)
+	} else {
+		heap.Pop(&iter.heap)
+	}
+	iter.findCurrent()
+}
+
+func (iter *MergingIterator) Prev() {
+	// all children other than current are after current when moving forward,
+	// so they need to be positioned at the last entry before current
+	if iter.direction != kReverse {
+		key := iter.current.InternalKey()
+		for _, child := range iter.list {
+			if child == iter.current {
+				continue
+			}
+			child.Seek(key.UserKey)
+			for child.Valid() && internal.InternalKeyComparator(child.InternalKey(), key) < 0 {
+				child.Next()
+			}
+			if child.Valid() {
+				child.Prev()
+			} else {
+				child.SeekToLast()
+			}
+		}
+		iter.current.Prev()
+		iter.rebuild(kReverse)
+		return
+	}
+
+	// move back the largest iterator, and then find the most small iterator
+	iter.current.Prev()
+	if iter.current.Valid() {
+		heap.Fix(&iter.heap, 0)
+	} else {
+		heap.Pop(&iter.heap)
+	}
+	iter.findCurrent()
+}
+
+// @return: the first error among children
+
+func (iter *MergingIterator) Error() error {
+	for _, child := range iter.list {
+		if err := child.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (iter *MergingIterator) Close() {
+	for _, child := range iter.list {
+		child.Close()
+	}
+	iter.list = nil
+	iter.heap.items = nil
+	iter.current = nil
+}
+
+// iteratorHeap implements heap.Interface over children of MergingIterator
+
+type iteratorHeap struct {
+	items   []internal.InternalIterator
+	reverse bool // max heap if reverse
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	r := internal.InternalKeyComparator(h.items[i].InternalKey(), h.items[j].InternalKey())
+	if h.reverse {
+		return r > 0
+	}
+	return r < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *iteratorHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(internal.InternalIterator))
+}
+
+func (h *iteratorHeap) Pop() interface{} {
+	n := len(h.items)
+	x := h.items[n-1]
+	h.items = h.items[:n-1]
+	return x
 }
@@ -0,0 +1,86 @@
+package version
+
+import (
+	"fmt"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/memtable"
+	"testing"
+)
+
+// @description: build merging iterator over memtables, key i is put into memtable i%n with seq i
+
+func newTestMergingIterator(n, numKeys int) *MergingIterator {
+	var list []internal.InternalIterator
+	tables := make([]*memtable.MemTable, n)
+	for i := range tables {
+		tables[i] = memtable.New()
+		list = append(list, tables[i].NewIterator())
+	}
+	for i := 0; i < numKeys; i++ {
+		key := []byte(fmt.Sprintf("%04d", i))
+		tables[i%n].Add(uint64(i+1), internal.TypeValue, key, key)
+	}
+	return NewMergingIterator(list)
+}
+
+func Test_MergingIterator(t *testing.T) {
+	iter := newTestMergingIterator(5, 100)
+
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if string(iter.InternalKey().UserKey) != fmt.Sprintf("%04d", i) {
+			t.Fatal("forward order error", i)
+		}
+		i++
+	}
+	if i != 100 {
+		t.Fatal("forward count error", i)
+	}
+
+	i = 99
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		if string(iter.InternalKey().UserKey) != fmt.Sprintf("%04d", i) {
+			t.Fatal("backward order error", i)
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatal("backward count error", i)
+	}
+}
+
+func Test_MergingIterator_SwitchDirection(t *testing.T) {
+	iter := newTestMergingIterator(3, 30)
+
+	iter.Seek([]byte("0010"))
+	iter.Prev()
+	if !iter.Valid() || string(iter.InternalKey().UserKey) != "0009" {
+		t.Fatal("prev after seek error")
+	}
+	iter.Next()
+	iter.Next()
+	if !iter.Valid() || string(iter.InternalKey().UserKey) != "0011" {
+		t.Fatal("next after prev error")
+	}
+
+	// the same user key in different children, the newer one comes first
+	m := memtable.New()
+	m.Add(100, internal.TypeValue, []byte("0011"), []byte("new"))
+	iter.list = append(iter.list, m.NewIterator())
+	iter.Seek([]byte("0011"))
+	if string(iter.InternalKey().UserValue) != "new" {
+		t.Fatal("newer entry should come first")
+	}
+	iter.Next()
+	if string(iter.InternalKey().UserValue) != "0011" {
+		t.Fatal("older entry should come next")
+	}
+	iter.Prev()
+	if string(iter.InternalKey().UserValue) != "new" {
+		t.Fatal("prev to the same user key error")
+	}
+	iter.Prev()
+	if string(iter.InternalKey().UserKey) != "0010" {
+		t.Fatal("prev across user key error")
+	}
+}
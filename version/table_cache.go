@@ -3,8 +3,11 @@ package version
 import (
 	"github.com/hashicorp/golang-lru"
 	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/opt"
 	"github.com/jo3yzhu/goveldb/sstable"
+	"github.com/jo3yzhu/goveldb/statistics"
 	"sync"
+	"sync/atomic"
 )
 
 // TableCache is used to cache several sstables in memory of one database file
@@ -13,65 +16,137 @@ import (
 type TableCache struct {
 	mu     sync.Mutex // golang-lru is thread-safe, but still need to protect local file in findTable
 	dbName string     // a database contains many sstables
-	cache  *lru.Cache // key is file number of sstable, value is *sstable
+	opts   *opt.Options
+	cache  *lru.Cache // key is file number of sstable, value is *cachedTable
 }
 
-func NewTableCache(dbName string) *TableCache {
-	c, _ := lru.New(internal.MaxOpenFiles - internal.NumNonTableCacheFiles) // lru cache size
+// cachedTable is a sstable in table cache, whose file is closed when it's evicted and no one is reading it
+// the cache holds a reference, and so does each reader until it's done
+
+type cachedTable struct {
+	table *sstable.SsTable
+	refs  int32
+}
+
+func (c *cachedTable) ref() {
+	atomic.AddInt32(&c.refs, 1)
+}
+
+func (c *cachedTable) unref() {
+	if atomic.AddInt32(&c.refs, -1) == 0 {
+		_ = c.table.Close()
+	}
+}
+
+func NewTableCache(dbName string, opts *opt.Options) *TableCache {
+	// lru cache size, the file of evicted sstable is closed once it's not read
+	c, _ := lru.NewWithEvict(internal.MaxOpenFiles-internal.NumNonTableCacheFiles, func(_, value interface{}) {
+		value.(*cachedTable).unref()
+	})
 	return &TableCache{
 		dbName: dbName,
+		opts:   opts,
 		cache:  c,
 	}
 }
 
 // @description: get a sstable by its file number, maybe in cache or disk and then loaded in cache
 // @param: file number, in other words, file name
-// @return: pointer of sstable and error if any
+// @return: the referenced sstable which must be unref after use and error if any
 // @notice: all sstable file name is generated by file number
 
-func (tableCache *TableCache) findTable(fileNum uint64) (*sstable.SsTable, error) {
+func (tableCache *TableCache) findTable(fileNum uint64) (*cachedTable, error) {
 	tableCache.mu.Lock()
 	defer tableCache.mu.Unlock()
 
 	// if already exists, return it
-	if table, ok := tableCache.cache.Get(fileNum); ok {
-		return table.(*sstable.SsTable), nil
+	if c, ok := tableCache.cache.Get(fileNum); ok {
+		tableCache.opts.GetStatistics().RecordTick(statistics.TableCacheHit, 1)
+		c.(*cachedTable).ref()
+		return c.(*cachedTable), nil
 	} else {
+		tableCache.opts.GetStatistics().RecordTick(statistics.TableCacheMiss, 1)
+
 		// if sstable with fileNum doesn't exist in lru, add it in cache and return
-		ssTable, err := sstable.Open(internal.TableFileName(tableCache.dbName, fileNum))
-		tableCache.cache.Add(fileNum, ssTable)
-		return ssTable, err
+		ssTable, err := sstable.Open(internal.TableFileName(tableCache.dbName, fileNum), tableCache.opts)
+		if err != nil {
+			return nil, err
+		}
+		c := &cachedTable{table: ssTable, refs: 2}
+		tableCache.cache.Add(fileNum, c)
+		return c, nil
+	}
+}
+
+// tableIterator is the iterator of a sstable in table cache, which holds a reference of the sstable until it's closed
+
+type tableIterator struct {
+	internal.InternalIterator
+	c *cachedTable
+}
+
+func (iter *tableIterator) Close() {
+	if iter.c != nil {
+		iter.InternalIterator.Close()
+		iter.c.unref()
+		iter.c = nil
 	}
 }
 
 // @description: get a iterator of sstable in table cache
-// @param: file number, in other words, file name
-// @return: the iterator of the sstable, if any error return nil
+// @param: file number, in other words, file name and read options
+// @return: the iterator of the sstable, if any error return an empty iterator reporting it
 
-func (tableCache *TableCache) NewIterator(fileNum uint64) *sstable.Iterator {
-	if table, _ := tableCache.findTable(fileNum); table != nil {
-		return table.NewIterator()
+func (tableCache *TableCache) NewIterator(fileNum uint64, opts *opt.ReadOptions) internal.InternalIterator {
+	c, err := tableCache.findTable(fileNum)
+	if err != nil {
+		return internal.NewEmptyIterator(err)
 	}
 
-	return nil
+	return &tableIterator{InternalIterator: c.table.NewIterator(opts), c: c}
 }
 
 // @description: get value of key in sstable with file number
-// @param: file number, in other words, file name and key
+// @param: file number, in other words, file name, key and the perf context to record into
 // @return: value and error
 
-func (tableCache *TableCache) Get(fileNum uint64, key []byte) ([]byte, error) {
-	table, err := tableCache.findTable(fileNum)
-	if table != nil {
-		return table.Get(key)
+func (tableCache *TableCache) Get(fileNum uint64, key []byte, perf *statistics.PerfContext) ([]byte, error) {
+	c, err := tableCache.findTable(fileNum)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unref()
+
+	return c.table.Get(key, perf)
+}
+
+// @description: estimate the offset in sstable with file number where the data of key is
+// @param: file number, in other words, file name and key
+// @return: the offset and error if the sstable can't be opened
+
+func (tableCache *TableCache) ApproximateOffsetOf(fileNum uint64, key []byte) (uint64, error) {
+	c, err := tableCache.findTable(fileNum)
+	if err != nil {
+		return 0, err
 	}
+	defer c.unref()
 
-	return nil, err
+	return c.table.ApproximateOffsetOf(key), nil
 }
 
-// @description: erase a sstable with file in cache
+// @description: erase a sstable with file in cache, its file is closed once it's not read
 // @param: file number, in other words, file name
 
 func (tableCache *TableCache) Evict(fileNum uint64) {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
 	tableCache.cache.Remove(fileNum)
 }
+
+// @description: erase all sstables in cache, their files are closed once they are not read
+
+func (tableCache *TableCache) Purge() {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
+	tableCache.cache.Purge()
+}
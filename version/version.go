@@ -2,11 +2,16 @@ package version
 
 import (
 	"encoding/binary"
+	"fmt"
+	"github.com/jo3yzhu/goveldb/env"
+	"github.com/jo3yzhu/goveldb/event"
 	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/logger"
+	"github.com/jo3yzhu/goveldb/opt"
+	"github.com/jo3yzhu/goveldb/statistics"
 	"io"
-	"log"
-	"os"
 	"sort"
+	"strings"
 )
 
 type FileMetaData struct {
@@ -17,70 +22,60 @@ type FileMetaData struct {
 	largest    *internal.InternalKey // indicate the key range of the file
 }
 
-// @description: encode a file meta data to a writer
-// @param: the writer
-// @return: error if any
-
-func (meta *FileMetaData) EncodeTo(w io.Writer) error {
-	var err error
-	write := func(data interface{}) {
-		if err != nil {
-			return
-		}
-		err = binary.Write(w, binary.LittleEndian, data)
-	}
-
-	write(meta.allowSeeks)
-	write(meta.fileSize)
-	write(meta.number)
-	if err != nil {
-		return err
-	}
-
-	err = meta.smallest.EncodeTo(w)
-	if err != nil {
-		return err
-	}
-
-	err = meta.largest.EncodeTo(w)
-	if err != nil {
-		return err
-	}
-
-	return nil
+// @description: append encoded file meta data to a byte slice
+//				 the encoding sequence is allowSeeks -> fileSize -> number in varint64 -> smallest -> largest
+// @param: the byte slice
+// @return: the extended byte slice
+
+func (meta *FileMetaData) AppendTo(dst []byte) []byte {
+	dst = binary.AppendUvarint(dst, meta.allowSeeks)
+	dst = binary.AppendUvarint(dst, meta.fileSize)
+	dst = binary.AppendUvarint(dst, meta.number)
+	dst = meta.smallest.AppendTo(dst)
+	dst = meta.largest.AppendTo(dst)
+	return dst
 }
 
-// @description: decode a file meta data from a reader
-// @param: the reader
-// @return: error if any
+// @description: decode a file meta data from a byte slice
+// @param: the byte slice, keys of file meta data refer to it after decoding
+// @return: the number of bytes read and error if any
 
-func (meta *FileMetaData) DecodeFrom(r io.Reader) error {
-	var err error
-	read := func(data interface{}) {
-		if err != nil {
-			return
+func (meta *FileMetaData) DecodeFrom(p []byte) (int, error) {
+	offset := 0
+	for _, x := range []*uint64{&meta.allowSeeks, &meta.fileSize, &meta.number} {
+		v, n := binary.Uvarint(p[offset:])
+		if n <= 0 {
+			return 0, internal.ErrCorruption
 		}
-		err = binary.Read(r, binary.LittleEndian, data)
+		*x = v
+		offset += n
 	}
 
-	read(&meta.allowSeeks)
-	read(&meta.fileSize)
-	read(&meta.number)
+	meta.smallest = new(internal.InternalKey)
+	n, err := meta.smallest.DecodeFrom(p[offset:])
 	if err != nil {
-		return err
+		return 0, err
 	}
+	offset += n
 
-	meta.smallest = new(internal.InternalKey)
-	err = meta.smallest.DecodeFrom(r)
+	meta.largest = new(internal.InternalKey)
+	n, err = meta.largest.DecodeFrom(p[offset:])
 	if err != nil {
-		return err
+		return 0, err
 	}
+	offset += n
 
-	meta.largest = new(internal.InternalKey)
-	err = meta.largest.DecodeFrom(r)
-	return err
+	return offset, nil
 }
 
+// manifest of goveldb starts with a magic number and a format version, so that the layout can be changed later
+// manifests without them are written by goveldb before the layout of internal keys was changed, they are read in legacy format
+
+const (
+	kManifestMagicNumber   uint64 = 0x676f76656c64626d // "goveldbm"
+	kManifestFormatVersion        = 1
+)
+
 // Version contains a set of sstable file in each level
 // In LevelDB, MVCC is implemented by version set, which is not implemented here
 
@@ -88,6 +83,7 @@ type Version struct {
 	tableCache     *TableCache // lru cache of sstable files
 	nextFileNumber uint64
 	seq            uint64
+	logNumber      uint64 // log files whose number is less than it are compacted into sstable
 	files          [internal.NumLevels][]*FileMetaData // file meta data in each level
 	compactPointer [internal.NumLevels]*internal.InternalKey // pre-level key at which the next compaction at that level should start
 }
@@ -97,90 +93,157 @@ type Version struct {
 // @return: error if any
 
 func (v *Version) EncodeTo(w io.Writer) error {
-	var err error
-	write := func(data interface{}) {
-		if err != nil {
-			return
+	var buf []byte
+
+	// first encode the header telling the format, then next file number, seq and log number
+	buf = binary.LittleEndian.AppendUint64(buf, kManifestMagicNumber)
+	buf = binary.AppendUvarint(buf, kManifestFormatVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, v.nextFileNumber)
+	buf = binary.LittleEndian.AppendUint64(buf, v.seq)
+	buf = binary.LittleEndian.AppendUint64(buf, v.logNumber)
+
+	// write num of files and write file meta data of each file
+	for level := 0; level < internal.NumLevels; level++ {
+		buf = binary.AppendUvarint(buf, uint64(len(v.files[level])))
+		for _, f := range v.files[level] {
+			buf = f.AppendTo(buf)
 		}
-		err = binary.Write(w, binary.LittleEndian, data)
 	}
 
-	// first encode next file number and seq
-	write(v.nextFileNumber)
-	write(v.seq)
+	_, err := w.Write(buf)
+	return err
+}
+
+// @description: decode a version from a byte slice
+// @param: the byte slice
+// @return: error if any, internal.ErrUnsupportedFormat if it's written in an unknown format
+
+func (v *Version) DecodeFrom(p []byte) error {
+	if len(p) < 8 || binary.LittleEndian.Uint64(p) != kManifestMagicNumber {
+		return v.decodeLegacy(p)
+	}
+	formatVersion, n := binary.Uvarint(p[8:])
+	if n <= 0 {
+		return internal.ErrCorruption
+	}
+	if formatVersion != kManifestFormatVersion {
+		return internal.ErrUnsupportedFormat
+	}
+	p = p[8+n:]
+
+	if len(p) < 24 {
+		return internal.ErrCorruption
+	}
+	v.nextFileNumber = binary.LittleEndian.Uint64(p)
+	v.seq = binary.LittleEndian.Uint64(p[8:])
+	v.logNumber = binary.LittleEndian.Uint64(p[16:])
+	p = p[24:]
 
-	// write num of files and write file meta data of each file
 	for level := 0; level < internal.NumLevels; level++ {
-		numFiles := len(v.files[level])
-		write(int32(numFiles))
+		numFiles, n := binary.Uvarint(p)
+		if n <= 0 || numFiles > uint64(len(p)) {
+			return internal.ErrCorruption
+		}
+		p = p[n:]
 
-		for i := 0; i < numFiles; i++ {
-			if err = v.files[level][i].EncodeTo(w); err != nil {
+		v.files[level] = make([]*FileMetaData, numFiles)
+		for i := range v.files[level] {
+			var meta FileMetaData
+			n, err := meta.DecodeFrom(p)
+			if err != nil {
 				return err
 			}
+			p = p[n:]
+			v.files[level][i] = &meta
 		}
 	}
 
 	return nil
 }
 
-// @description: decode a version from a reader
-// @param: the reader
-// @return: error if any
+// @description: decode a version in legacy format, which has no log number:
+//		fixed64 next file number -> fixed64 seq -> for each level: int32 number of files -> file meta data of each file
+//		file meta data: fixed64 allowSeeks -> fixed64 fileSize -> fixed64 number -> smallest -> largest in legacy key encoding
+// @param: the byte slice
+// @return: error if any, internal.ErrUnsupportedFormat if it's not a well-formed manifest of legacy format
 
-func (v *Version) DecodeFrom(r io.Reader) error {
-	var err error
-	read := func(data interface{}) {
-		if err != nil {
-			return
-		}
-		err = binary.Read(r, binary.LittleEndian, data)
+func (v *Version) decodeLegacy(p []byte) error {
+	if len(p) < 16 {
+		return internal.ErrUnsupportedFormat
 	}
-
-	read(&v.nextFileNumber)
-	read(&v.seq)
+	v.nextFileNumber = binary.LittleEndian.Uint64(p)
+	v.seq = binary.LittleEndian.Uint64(p[8:])
+	p = p[16:]
 
 	for level := 0; level < internal.NumLevels; level++ {
-		var numFiles int32
-		read(&numFiles)
-		v.files[level] = make([]*FileMetaData, numFiles)
+		if len(p) < 4 {
+			return internal.ErrUnsupportedFormat
+		}
+		numFiles := binary.LittleEndian.Uint32(p)
+		p = p[4:]
+		if uint64(numFiles) > uint64(len(p)) {
+			return internal.ErrUnsupportedFormat
+		}
 
-		for i := 0; i < int(numFiles); i++ {
-			var meta FileMetaData
-			err = meta.DecodeFrom(r)
-			if err != nil {
-				return err
+		v.files[level] = make([]*FileMetaData, numFiles)
+		for i := range v.files[level] {
+			if len(p) < 24 {
+				return internal.ErrUnsupportedFormat
+			}
+			meta := FileMetaData{
+				allowSeeks: binary.LittleEndian.Uint64(p),
+				fileSize:   binary.LittleEndian.Uint64(p[8:]),
+				number:     binary.LittleEndian.Uint64(p[16:]),
+				smallest:   new(internal.InternalKey),
+				largest:    new(internal.InternalKey),
+			}
+			p = p[24:]
+			for _, key := range []*internal.InternalKey{meta.smallest, meta.largest} {
+				n, err := key.DecodeLegacyFrom(p)
+				if err != nil {
+					return internal.ErrUnsupportedFormat
+				}
+				p = p[n:]
 			}
 			v.files[level][i] = &meta
 		}
 	}
 
+	// anything left means it's not a manifest of legacy format
+	if len(p) > 0 {
+		return internal.ErrUnsupportedFormat
+	}
 	return nil
 }
 
-func New(dbName string) *Version {
+func New(dbName string, opts *opt.Options) *Version {
 	return &Version{
-		tableCache:     NewTableCache(dbName),
+		tableCache:     NewTableCache(dbName, opts),
 		nextFileNumber: 1,
 	}
 }
 
 // @description: load a version from a file
-// @param: the database name and file number
+// @param: the database name, file number and options
 // @return: version and error if any
 
-func Load(dbName string, number uint64) (*Version, error) {
+func Load(dbName string, number uint64, opts *opt.Options) (*Version, error) {
 	// generate file name by file file number
 	// file name formats like "DBName/MANIFEST-000123"
 	fileName := internal.DescriptorFileName(dbName, number)
-	file, err := os.Open(fileName)
+	v := New(dbName, opts)
+
+	// manifest of leveldb is a log of version edits
+	if opts.GetLevelDBCompatible() {
+		return v, v.loadLevelDBManifest(fileName)
+	}
+
+	p, err := env.ReadFile(opts.GetEnv(), fileName)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	v := New(dbName)
-	err = v.DecodeFrom(file)
+	err = v.DecodeFrom(p)
 	return v, err
 }
 
@@ -192,24 +255,47 @@ func (v *Version) Save() (uint64, error) {
 	fileName := internal.DescriptorFileName(v.tableCache.dbName, v.nextFileNumber)
 
 	v.nextFileNumber++
-	file, err := os.Create(fileName)
+	if v.tableCache.opts.GetLevelDBCompatible() {
+		return tmp, v.saveLevelDBManifest(fileName)
+	}
+
+	file, err := v.env().NewWritableFile(fileName)
 	if err != nil {
 		return tmp, err
 	}
 	defer file.Close()
-	return tmp, v.EncodeTo(file)
+	if err = v.EncodeTo(file); err != nil {
+		return tmp, err
+	}
+	return tmp, file.Sync()
 }
 
 // @description: log file info in each level of version
 
 func (v *Version) Log() {
 	for level := 0; level < internal.NumLevels; level++ {
-		for i := 0; i < len(v.files[level]); i++ {
-			log.Printf("version[%d]: %d", level, v.files[level][i].number)
+		if len(v.files[level]) > 0 {
+			v.infoLog().Log(logger.Debug, "version", "level", level, "files", fileNumbers(v.files[level]))
 		}
 	}
 }
 
+func (v *Version) env() env.Env {
+	return v.tableCache.opts.GetEnv()
+}
+
+func (v *Version) infoLog() logger.Logger {
+	return v.tableCache.opts.GetInfoLog()
+}
+
+func (v *Version) listeners() event.Listeners {
+	return v.tableCache.opts.GetEventListeners()
+}
+
+func (v *Version) statistics() *statistics.Statistics {
+	return v.tableCache.opts.GetStatistics()
+}
+
 // @description: deep copy a version
 // @return: pointer of copied new version
 
@@ -219,6 +305,7 @@ func (v *Version) Copy() *Version {
 	c.tableCache = v.tableCache
 	c.nextFileNumber = v.nextFileNumber
 	c.seq = v.seq
+	c.logNumber = v.logNumber
 	for level := 0; level < internal.NumLevels; level++ {
 		c.files[level] = make([]*FileMetaData, len(v.files[level]))
 		copy(c.files[level], v.files[level]) // deep copy
@@ -226,20 +313,183 @@ func (v *Version) Copy() *Version {
 	return &c
 }
 
-func (v *Version) NextSeq() uint64 {
-	v.seq++
+func (v *Version) LastSequence() uint64 {
 	return v.seq
 }
 
+func (v *Version) SetLastSequence(seq uint64) {
+	v.seq = seq
+}
+
+func (v *Version) LogNumber() uint64 {
+	return v.logNumber
+}
+
+func (v *Version) SetLogNumber(number uint64) {
+	v.logNumber = number
+}
+
+// @description: allocate a file number for a new file, such as log file
+
+func (v *Version) NewFileNumber() uint64 {
+	number := v.nextFileNumber
+	v.nextFileNumber++
+	return number
+}
+
+// @description: return the number of the next new file, the files numbered from it are not in any version yet
+
+func (v *Version) NextFileNumber() uint64 {
+	return v.nextFileNumber
+}
+
+// @description: drop a table from table cache, which is called when the table file is removed
+
+func (v *Version) EvictTable(number uint64) {
+	v.tableCache.Evict(number)
+}
+
+// @description: drop all tables from table cache, which is called when the database is closed
+
+func (v *Version) PurgeTables() {
+	v.tableCache.Purge()
+}
+
+// @description: add a table file to version, which is used when the version is rebuilt from tables on disk
+// @param: the level, the file number and size, and the key range of it
+
+func (v *Version) AddFile(level int, number, fileSize uint64, smallest, largest *internal.InternalKey) {
+	v.addFile(level, &FileMetaData{
+		allowSeeks: 1 << 30,
+		number:     number,
+		fileSize:   fileSize,
+		smallest:   smallest,
+		largest:    largest,
+	})
+	v.MarkFileNumberUsed(number)
+}
+
+// @description: make sure that the file number won't be allocated again, which is used when recovering files from disk
+
+func (v *Version) MarkFileNumberUsed(number uint64) {
+	if v.nextFileNumber <= number {
+		v.nextFileNumber = number + 1
+	}
+}
+
+// @description: collect the table files in all levels of version
+// @return: the file sizes indexed by file numbers
+
+func (v *Version) LiveFiles() map[uint64]uint64 {
+	live := make(map[uint64]uint64)
+	for level := 0; level < internal.NumLevels; level++ {
+		for _, f := range v.files[level] {
+			live[f.number] = f.fileSize
+		}
+	}
+	return live
+}
+
+// @description: check that files in each level >= 1 are sorted by key range and don't overlap each other
+// @return: internal.ErrCorruption if they're not
+
+func (v *Version) CheckLevels() error {
+	for level := 1; level < internal.NumLevels; level++ {
+		for i, f := range v.files[level] {
+			if internal.InternalKeyComparator(f.smallest, f.largest) > 0 {
+				v.infoLog().Log(logger.Error, "file range is reversed", "level", level, "file", f.number)
+				return internal.ErrCorruption
+			}
+			if i > 0 && internal.InternalKeyComparator(v.files[level][i-1].largest, f.smallest) >= 0 {
+				v.infoLog().Log(logger.Error, "files overlap or are out of order", "level", level,
+					"file", f.number, "previous", v.files[level][i-1].number)
+				return internal.ErrCorruption
+			}
+		}
+	}
+	return nil
+}
+
 func (v *Version) NumLevelFiles(l int) int {
 	return len(v.files[l])
 }
 
+func (v *Version) NumLevelBytes(l int) uint64 {
+	return totalFileSize(v.files[l])
+}
+
+// @description: describe files in each level of version, each file is described as number:size[smallest .. largest]
+// @return: the description
+
+func (v *Version) DebugString() string {
+	var b strings.Builder
+	for level := 0; level < internal.NumLevels; level++ {
+		fmt.Fprintf(&b, "--- level %d ---\n", level)
+		for _, f := range v.files[level] {
+			fmt.Fprintf(&b, " %d:%d[%q @ %d : %d .. %q @ %d : %d]\n", f.number, f.fileSize,
+				f.smallest.UserKey, f.smallest.Seq, f.smallest.Type, f.largest.UserKey, f.largest.Seq, f.largest.Type)
+		}
+	}
+	return b.String()
+}
+
+// @description: create iterators over all files in version
+// @param: read options, files entirely out of the bounds in it are skipped
+// @return: iterators of each file in level0 and iterators of other non-empty levels
+
+func (v *Version) NewIterators(opts *opt.ReadOptions) []internal.InternalIterator {
+	lowerBound, upperBound := opts.GetLowerBound(), opts.GetUpperBound()
+
+	var list []internal.InternalIterator
+	for _, f := range v.files[0] {
+		if lowerBound != nil && internal.UserKeyComparator(f.largest.UserKey, lowerBound) < 0 {
+			continue
+		}
+		if upperBound != nil && internal.UserKeyComparator(f.smallest.UserKey, upperBound) >= 0 {
+			continue
+		}
+		list = append(list, v.tableCache.NewIterator(f.number, opts))
+	}
+	for level := 1; level < internal.NumLevels; level++ {
+		if len(v.files[level]) > 0 {
+			list = append(list, NewLevelIterator(v.tableCache, v.files[level], opts))
+		}
+	}
+	return list
+}
+
+// @description: estimate the number of bytes in files of version before key
+// @param: the user key
+// @return: sum of sizes of files entirely before key and offsets of key in files containing it
+
+func (v *Version) ApproximateOffsetOf(key []byte) uint64 {
+	var result uint64
+	for level := 0; level < internal.NumLevels; level++ {
+		for _, f := range v.files[level] {
+			if internal.UserKeyComparator(f.largest.UserKey, key) < 0 {
+				// entire file is before key
+				result += f.fileSize
+			} else if internal.UserKeyComparator(f.smallest.UserKey, key) > 0 {
+				// entire file is after key, and so are the rest files in levels other than level0
+				if level > 0 {
+					break
+				}
+			} else {
+				// key is in the range of file, a file which can't be opened contributes nothing
+				if offset, err := v.tableCache.ApproximateOffsetOf(f.number, key); err == nil {
+					result += offset
+				}
+			}
+		}
+	}
+	return result
+}
+
 // @description: get key-value from version with binary search
-// @param: the target key
+// @param: the target key and the perf context to record into, which may be nil
 // @return: the value and error if any
 
-func (v *Version) Get(key []byte) ([]byte, error) {
+func (v *Version) Get(key []byte, perf *statistics.PerfContext) ([]byte, error) {
 	var tmp []*FileMetaData
 	var files []*FileMetaData
 
@@ -254,29 +504,26 @@ func (v *Version) Get(key []byte) ([]byte, error) {
 		// files in level0 is allowed to overlap each other, every file may contain target key
 		// so each file needs to be examined
 		if level == 0 {
+			tmp = tmp[:0]
 			for i := 0; i < numFiles; i++ {
 				f := v.files[level][i]
 				if internal.UserKeyComparator(key, f.smallest.UserKey) >= 0 && internal.UserKeyComparator(key, f.largest.UserKey) <= 0 {
 					tmp = append(tmp, f)
 				}
-
-				// if there's no matched file in level0, go to next level
-				if len(tmp) == 0 {
-					continue
-				}
-
-				// for level0, if expected file more than 1, sort them by file number
-				sort.Slice(tmp, func(i, j int) bool {
-					return tmp[i].number > tmp[j].number
-				})
-				numFiles = len(tmp)
-				files = tmp
 			}
+
+			// for level0, if expected file more than 1, sort them by file number
+			// if there's no matched file in level0, nothing is searched and go to next level
+			sort.Slice(tmp, func(i, j int) bool {
+				return tmp[i].number > tmp[j].number
+			})
+			numFiles = len(tmp)
+			files = tmp
 		} else {
 
 			// files in other level is divided in range, so binary search is available here
 			// only one file contain target key
-			index := v.findFile(v.files[level], key)
+			index := findFile(v.files[level], key)
 
 			// if current level doesn't contain such range
 			if index >= numFiles {
@@ -301,8 +548,23 @@ func (v *Version) Get(key []byte) ([]byte, error) {
 		// search in every possible and put them in table cache
 		for i := 0; i < numFiles; i++ {
 			f := files[i]
-			value, err := v.tableCache.Get(f.number, key)
+			if perf != nil {
+				if level == 0 {
+					perf.Level0FilesConsulted++
+				} else {
+					perf.DeeperFilesConsulted++
+				}
+			}
+			value, err := v.tableCache.Get(f.number, key, perf)
 			if err != internal.ErrNotFound {
+				switch level {
+				case 0:
+					v.statistics().RecordTick(statistics.GetHitL0, 1)
+				case 1:
+					v.statistics().RecordTick(statistics.GetHitL1, 1)
+				default:
+					v.statistics().RecordTick(statistics.GetHitL2AndUp, 1)
+				}
 				return value, err
 			}
 		}
@@ -315,7 +577,7 @@ func (v *Version) Get(key []byte) ([]byte, error) {
 // @param: the level and target key
 // @return: the file index in the input level
 
-func (v *Version) findFile(files []*FileMetaData, key []byte) int {
+func findFile(files []*FileMetaData, key []byte) int {
 	left := 0
 	right := len(files)
 
@@ -342,7 +604,7 @@ func (v *Version) deleteFile(level int, meta *FileMetaData) {
 	for i := 0; i < numFiles; i++ {
 		if v.files[level][i].number == meta.number {
 			v.files[level] = append(v.files[level][:i], v.files[level][i+1:]...) // delete a element using append
-			log.Printf("deleteFile, level:%d, num:%d", level, meta.number)
+			v.infoLog().Log(logger.Debug, "delete file", "level", level, "file", meta.number)
 			break
 		}
 	}
@@ -353,7 +615,8 @@ func (v *Version) deleteFile(level int, meta *FileMetaData) {
 // @notice: the number of file number is unique
 
 func (v *Version) addFile(level int, meta *FileMetaData) {
-	log.Printf("addFile, level:%d, num:%d, %s-%s", level, meta.number, string(meta.smallest.UserKey), string(meta.largest.UserKey))
+	v.infoLog().Log(logger.Debug, "add file", "level", level, "file", meta.number,
+		"smallest", meta.smallest.UserKey, "largest", meta.largest.UserKey)
 
 	if level == 0 {
 		// level0 is unordered and maybe overlap each other
@@ -361,7 +624,7 @@ func (v *Version) addFile(level int, meta *FileMetaData) {
 	} else {
 		// ordered and no overlap
 		numFiles := len(v.files[level])
-		index := v.findFile(v.files[level], meta.smallest.UserKey)
+		index := findFile(v.files[level], meta.smallest.UserKey)
 
 		if index >= numFiles {
 			// there's no file whose largest key greater than meta's smallest key
@@ -398,7 +661,7 @@ func (v *Version) overlapInLevel(level int, smallestKey, largestKey []byte) bool
 		}
 	} else {
 		// no overlap in other level, use binary search
-		index := v.findFile(v.files[level], smallestKey)
+		index := findFile(v.files[level], smallestKey)
 		if index >= numFiles {
 			return false
 		} else {
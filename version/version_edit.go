@@ -0,0 +1,258 @@
+// Manifest file of leveldb is a log file of version edits, each record is an edit applied to the previous version
+// An edit is a sequence of fields, each field is prefixed by a varint32 tag:
+//		comparator: length prefixed name of comparator
+//		log number, prev log number, next file number, last sequence: varint64
+//		compact pointer: varint32 level -> length prefixed internal key
+//		deleted file: varint32 level -> varint64 file number
+//		new file: varint32 level -> varint64 file number -> varint64 file size -> length prefixed smallest and largest internal key
+// the internal key here is user key followed by fixed64 tag of seq and type
+
+package version
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/wal"
+	"io"
+	"sort"
+)
+
+const (
+	kComparator     = 1
+	kLogNumber      = 2
+	kNextFileNumber = 3
+	kLastSequence   = 4
+	kCompactPointer = 5
+	kDeletedFile    = 6
+	kNewFile        = 7
+	kPrevLogNumber  = 9 // 8 was used for large value refs
+)
+
+// goveldb only supports bytewise comparator, which is the default one of leveldb
+
+const kComparatorName = "leveldb.BytewiseComparator"
+
+var errComparatorMismatch = errors.New("comparator of database mismatches leveldb.BytewiseComparator")
+
+// @description: encode the whole version as a single edit of leveldb manifest
+// @return: the encoded edit
+
+func (v *Version) encodeLevelDBEdit() []byte {
+	var p []byte
+	p = binary.AppendUvarint(p, kComparator)
+	p = appendLengthPrefixed(p, []byte(kComparatorName))
+	p = binary.AppendUvarint(p, kLogNumber)
+	p = binary.AppendUvarint(p, v.logNumber)
+	p = binary.AppendUvarint(p, kPrevLogNumber)
+	p = binary.AppendUvarint(p, 0)
+	p = binary.AppendUvarint(p, kNextFileNumber)
+	p = binary.AppendUvarint(p, v.nextFileNumber)
+	p = binary.AppendUvarint(p, kLastSequence)
+	p = binary.AppendUvarint(p, v.seq)
+
+	for level := 0; level < internal.NumLevels; level++ {
+		for _, f := range v.files[level] {
+			p = binary.AppendUvarint(p, kNewFile)
+			p = binary.AppendUvarint(p, uint64(level))
+			p = binary.AppendUvarint(p, f.number)
+			p = binary.AppendUvarint(p, f.fileSize)
+			p = appendLengthPrefixed(p, encodeLevelDBKey(f.smallest))
+			p = appendLengthPrefixed(p, encodeLevelDBKey(f.largest))
+		}
+	}
+
+	return p
+}
+
+// @description: apply an edit of leveldb manifest to version
+// @param: the encoded edit
+// @return: error if the edit is malformed
+
+func (v *Version) applyLevelDBEdit(p []byte) error {
+	for len(p) > 0 {
+		tag, n := binary.Uvarint(p)
+		if n <= 0 {
+			return internal.ErrCorruption
+		}
+		p = p[n:]
+
+		switch tag {
+		case kComparator:
+			name, n := getLengthPrefixed(p)
+			if n <= 0 {
+				return internal.ErrCorruption
+			}
+			if string(name) != kComparatorName {
+				return errComparatorMismatch
+			}
+			p = p[n:]
+		case kLogNumber, kPrevLogNumber, kNextFileNumber, kLastSequence:
+			x, n := binary.Uvarint(p)
+			if n <= 0 {
+				return internal.ErrCorruption
+			}
+			p = p[n:]
+			switch tag {
+			case kLogNumber:
+				v.logNumber = x
+			case kNextFileNumber:
+				v.nextFileNumber = x
+			case kLastSequence:
+				v.seq = x
+			}
+		case kCompactPointer:
+			level, n := binary.Uvarint(p)
+			if n <= 0 || level >= internal.NumLevels {
+				return internal.ErrCorruption
+			}
+			p = p[n:]
+			key, n := getLengthPrefixed(p)
+			if n <= 0 {
+				return internal.ErrCorruption
+			}
+			p = p[n:]
+			if v.compactPointer[level], n = decodeLevelDBKey(key); n <= 0 {
+				return internal.ErrCorruption
+			}
+		case kDeletedFile:
+			var fields [2]uint64 // level and file number
+			for i := range fields {
+				x, n := binary.Uvarint(p)
+				if n <= 0 {
+					return internal.ErrCorruption
+				}
+				fields[i] = x
+				p = p[n:]
+			}
+			if fields[0] >= internal.NumLevels {
+				return internal.ErrCorruption
+			}
+			v.deleteFile(int(fields[0]), &FileMetaData{number: fields[1]})
+		case kNewFile:
+			var fields [3]uint64 // level, file number and file size
+			for i := range fields {
+				x, n := binary.Uvarint(p)
+				if n <= 0 {
+					return internal.ErrCorruption
+				}
+				fields[i] = x
+				p = p[n:]
+			}
+			if fields[0] >= internal.NumLevels {
+				return internal.ErrCorruption
+			}
+
+			meta := FileMetaData{
+				allowSeeks: 1 << 30,
+				number:     fields[1],
+				fileSize:   fields[2],
+			}
+			for _, key := range []**internal.InternalKey{&meta.smallest, &meta.largest} {
+				encoded, n := getLengthPrefixed(p)
+				if n <= 0 {
+					return internal.ErrCorruption
+				}
+				p = p[n:]
+				if *key, n = decodeLevelDBKey(encoded); n <= 0 {
+					return internal.ErrCorruption
+				}
+			}
+			v.files[fields[0]] = append(v.files[fields[0]], &meta)
+		default:
+			return internal.ErrCorruption
+		}
+	}
+
+	return nil
+}
+
+// @description: save version to a manifest file in leveldb format
+// @param: the manifest file name
+
+func (v *Version) saveLevelDBManifest(fileName string) error {
+	file, err := v.env().NewWritableFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = wal.NewWriter(file).AddRecord(v.encodeLevelDBEdit()); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// @description: replay edits in a manifest file in leveldb format
+// @param: the manifest file name
+
+func (v *Version) loadLevelDBManifest(fileName string) error {
+	file, err := v.env().NewSequentialFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := wal.NewReader(file)
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// keys of file meta data refer to the record, which is reused by reader
+		if err = v.applyLevelDBEdit(append([]byte(nil), record...)); err != nil {
+			return err
+		}
+	}
+
+	// files in levels other than level0 are sorted by key range, and ordered by edits in manifest
+	for level := 1; level < internal.NumLevels; level++ {
+		files := v.files[level]
+		sort.Slice(files, func(i, j int) bool {
+			return internal.UserKeyComparator(files[i].smallest.UserKey, files[j].smallest.UserKey) < 0
+		})
+	}
+
+	return nil
+}
+
+// @description: encode internal key into user key followed by tag, value is dismissed
+
+func encodeLevelDBKey(key *internal.InternalKey) []byte {
+	p := append([]byte(nil), key.UserKey...)
+	return binary.LittleEndian.AppendUint64(p, internal.PackSequenceAndType(key.Seq, key.Type))
+}
+
+// @return: the internal key referring to p and the number of bytes read, 0 if p is malformed
+
+func decodeLevelDBKey(p []byte) (*internal.InternalKey, int) {
+	if len(p) < 8 {
+		return nil, 0
+	}
+	userKeySize := len(p) - 8
+	seq, valueType := internal.UnpackSequenceAndType(binary.LittleEndian.Uint64(p[userKeySize:]))
+	return &internal.InternalKey{
+		Seq:     seq,
+		Type:    valueType,
+		UserKey: p[:userKeySize:userKeySize],
+	}, len(p)
+}
+
+func appendLengthPrefixed(dst, p []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(p)))
+	return append(dst, p...)
+}
+
+// @return: the byte slice and the number of bytes read, 0 if p is malformed
+
+func getLengthPrefixed(p []byte) ([]byte, int) {
+	length, n := binary.Uvarint(p)
+	if n <= 0 || uint64(len(p)-n) < length {
+		return nil, 0
+	}
+	end := n + int(length)
+	return p[n:end:end], end
+}
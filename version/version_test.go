@@ -1,37 +1,315 @@
 package version
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/binary"
+	"github.com/jo3yzhu/goveldb/env"
 	"github.com/jo3yzhu/goveldb/internal"
 	"github.com/jo3yzhu/goveldb/memtable"
+	"github.com/jo3yzhu/goveldb/opt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
 	"testing"
 )
 
 func Test_Version_Get(t *testing.T) {
-	v := New("./")
-	var f FileMetaData
-	f.number = 123
-	f.smallest = internal.NewInternalKey(1, internal.TypeValue, []byte("123"), nil)
-	f.largest = internal.NewInternalKey(1, internal.TypeValue, []byte("125"), nil)
-	v.files[0] = append(v.files[0], &f)
+	opts := &opt.Options{Env: env.NewMemEnv()}
+	v := New("./", opts)
+	memTable := memtable.New()
+	memTable.Add(1, internal.TypeValue, []byte("123"), []byte("v123"))
+	memTable.Add(2, internal.TypeValue, []byte("125"), []byte("v125"))
+	memTable.Add(3, internal.TypeDeletion, []byte("124"), nil)
+	if _, err := v.WriteLevel0Table(memTable); err != nil {
+		t.Fatal("write table fail", err)
+	}
 
-	value, err := v.Get([]byte("123"))
-	fmt.Println(err, value)
+	for key, expected := range map[string]string{"123": "v123", "125": "v125"} {
+		if value, err := v.Get([]byte(key), nil); err != nil || string(value) != expected {
+			t.Fatal("get fail", key, err, string(value))
+		}
+	}
+	for _, key := range []string{"122", "124", "126"} {
+		if _, err := v.Get([]byte(key), nil); err == nil {
+			t.Fatal("absent key is found", key)
+		}
+	}
 }
 
 func Test_Version_Load(t *testing.T) {
-	v := New("./")
+	opts := &opt.Options{Env: env.NewMemEnv()}
+	v := New("./", opts)
 	memTable := memtable.New()
 	memTable.Add(1234567, internal.TypeValue, []byte("aadsa34a"), []byte("bb23b3423"))
-	v.WriteLevel0Table(memTable)
-	n, _ := v.Save()
+	if _, err := v.WriteLevel0Table(memTable); err != nil {
+		t.Fatal("write table fail", err)
+	}
+	n, err := v.Save()
+	if err != nil {
+		t.Fatal("save fail", err)
+	}
+
+	v2, err := Load("./", n, opts)
+	if err != nil {
+		t.Fatal("load fail", err)
+	}
+	if value, err := v2.Get([]byte("aadsa34a"), nil); err != nil || string(value) != "bb23b3423" {
+		t.Fatal("get fail", err, string(value))
+	}
+	if v2.LastSequence() != v.LastSequence() || v2.nextFileNumber != v.nextFileNumber {
+		t.Fatal("numbers of loaded version error")
+	}
+}
+
+func Test_Version_CheckLevels(t *testing.T) {
+	v := New("./", nil)
+	addFile := func(level int, number uint64, smallest, largest string) {
+		v.files[level] = append(v.files[level], &FileMetaData{
+			number:   number,
+			smallest: internal.NewInternalKey(number, internal.TypeValue, []byte(smallest), nil),
+			largest:  internal.NewInternalKey(number, internal.TypeValue, []byte(largest), nil),
+		})
+	}
+
+	// level0 may overlap
+	addFile(0, 1, "a", "z")
+	addFile(0, 2, "b", "c")
+	addFile(1, 3, "a", "c")
+	addFile(1, 4, "d", "f")
+	if err := v.CheckLevels(); err != nil {
+		t.Fatal("check sorted levels fail", err)
+	}
+
+	addFile(1, 5, "f", "g")
+	if err := v.CheckLevels(); err != internal.ErrCorruption {
+		t.Fatal("overlapping files are not detected", err)
+	}
+	v.files[1] = v.files[1][:2]
+	addFile(2, 6, "b", "a")
+	if err := v.CheckLevels(); err != internal.ErrCorruption {
+		t.Fatal("reversed file range is not detected", err)
+	}
+}
+
+func Test_Version_ManifestFormat(t *testing.T) {
+	fs := env.NewMemEnv()
+	opts := &opt.Options{Env: fs}
+	v := New("./", opts)
+	n, err := v.Save()
+	if err != nil {
+		t.Fatal("save fail", err)
+	}
+	p, _ := env.ReadFile(fs, internal.DescriptorFileName("./", n))
+
+	// manifest without header which is malformed in legacy format, and the one of a newer format are unknown
+	var old []byte
+	for _, x := range []uint64{2, 0, 0} {
+		old = binary.LittleEndian.AppendUint64(old, x)
+	}
+	for i := 0; i < internal.NumLevels; i++ {
+		old = append(old, 0)
+	}
+	newer := append([]byte(nil), p...)
+	newer[8] = kManifestFormatVersion + 1
+	for _, contents := range [][]byte{old, newer} {
+		_ = env.WriteFile(fs, internal.DescriptorFileName("./", n), contents, false)
+		if _, err := Load("./", n, opts); err != internal.ErrUnsupportedFormat {
+			t.Fatal("manifest of unsupported format is loaded", err)
+		}
+	}
+}
+
+// 000123.ldb is written by goveldb before the footer is versioned, it contains 123 -> 1234, 124 -> 1245 and 125 -> 0245
+
+func Test_Version_LegacyManifest(t *testing.T) {
+	table, err := ioutil.ReadFile("000123.ldb")
+	if err != nil {
+		t.Fatal("read table fail", err)
+	}
+	fs := env.NewMemEnv()
+	opts := &opt.Options{Env: fs}
+	_ = env.WriteFile(fs, internal.TableFileName("./", 123), table, false)
+
+	// the manifest of legacy format has fixed length numbers and keys, and no log number
+	legacyKey := func(seq uint64, key string) []byte {
+		p := binary.LittleEndian.AppendUint64(nil, seq)
+		p = append(p, byte(internal.TypeValue))
+		p = binary.LittleEndian.AppendUint32(p, uint32(len(key)))
+		p = append(p, key...)
+		return binary.LittleEndian.AppendUint32(p, 0)
+	}
+	var p []byte
+	for _, x := range []uint64{124, 3} {
+		p = binary.LittleEndian.AppendUint64(p, x)
+	}
+	p = binary.LittleEndian.AppendUint32(p, 1)
+	for _, x := range []uint64{1 << 30, uint64(len(table)), 123} {
+		p = binary.LittleEndian.AppendUint64(p, x)
+	}
+	p = append(p, legacyKey(1, "123")...)
+	p = append(p, legacyKey(3, "125")...)
+	for level := 1; level < internal.NumLevels; level++ {
+		p = binary.LittleEndian.AppendUint32(p, 0)
+	}
+	_ = env.WriteFile(fs, internal.DescriptorFileName("./", 2), p, false)
+
+	v, err := Load("./", 2, opts)
+	if err != nil {
+		t.Fatal("load legacy manifest fail", err)
+	}
+	if v.nextFileNumber != 124 || v.LastSequence() != 3 || v.LogNumber() != 0 || v.NumLevelFiles(0) != 1 {
+		t.Fatal("numbers of legacy manifest error", v.nextFileNumber, v.LastSequence(), v.LogNumber())
+	}
+	for key, expected := range map[string]string{"123": "1234", "124": "1245", "125": "0245"} {
+		if value, err := v.Get([]byte(key), nil); err != nil || string(value) != expected {
+			t.Fatal("get from legacy table fail", key, err, string(value))
+		}
+	}
 
-	v2, _ := Load("./", n)
+	// the version is saved in the current format
+	n, err := v.Save()
+	if err != nil {
+		t.Fatal("save fail", err)
+	}
+	saved, _ := env.ReadFile(fs, internal.DescriptorFileName("./", n))
+	if binary.LittleEndian.Uint64(saved) != kManifestMagicNumber {
+		t.Fatal("legacy manifest is saved in legacy format")
+	}
+	if v2, err := Load("./", n, opts); err != nil || !reflect.DeepEqual(v2.LiveFiles(), v.LiveFiles()) {
+		t.Fatal("reload saved version fail", err)
+	}
+}
+
+// the golden manifests are laid out as leveldb's VersionEdit::EncodeTo and log::Writer write them,
+// leveldb_snapshot.manifest is a snapshot record of current version followed by an edit record like VersionSet::LogAndApply
 
-	value, err := v2.Get([]byte("aadsa34a"))
+func Test_Version_LevelDBManifest(t *testing.T) {
+	fs := env.NewMemEnv()
+	opts := &opt.Options{Env: fs, LevelDBCompatible: true}
+	key := func(userKey string, seq uint64, valueType internal.ValueType) *internal.InternalKey {
+		return internal.NewInternalKey(seq, valueType, []byte(userKey), nil)
+	}
 
+	// goveldb writes a version as a single edit the same as leveldb
+	v := New("./", opts)
+	v.AddFile(0, 7, 1000, key("a", 5, internal.TypeValue), key("m", 3, internal.TypeDeletion))
+	v.AddFile(1, 4, 2000, key("a", 1, internal.TypeValue), key("f", 2, internal.TypeValue))
+	v.AddFile(1, 5, 3000, key("g", 1, internal.TypeValue), key("z", 2, internal.TypeValue))
+	v.SetLogNumber(6)
+	v.SetLastSequence(5)
+	v.nextFileNumber = 8
+	n, err := v.Save()
+	if err != nil {
+		t.Fatal("save fail", err)
+	}
+	p, _ := env.ReadFile(fs, internal.DescriptorFileName("./", n))
+	expected, err := ioutil.ReadFile("testdata/leveldb_single.manifest")
 	if err != nil {
-		t.Fail()
+		t.Fatal("read golden manifest fail", err)
 	}
-	fmt.Println(err, value)
-}
\ No newline at end of file
+	if !bytes.Equal(p, expected) {
+		t.Fatal("manifest is different from golden manifest")
+	}
+
+	for _, name := range []string{"leveldb_single.manifest", "leveldb_snapshot.manifest"} {
+		contents, _ := ioutil.ReadFile(filepath.Join("testdata", name))
+		_ = env.WriteFile(fs, internal.DescriptorFileName("./", 100), contents, false)
+		v2, err := Load("./", 100, opts)
+		if err != nil {
+			t.Fatal("load golden manifest fail", name, err)
+		}
+		if v2.LogNumber() != 6 || v2.LastSequence() != 5 || v2.nextFileNumber != 9 {
+			t.Fatal("numbers of golden manifest error", name)
+		}
+		if len(v2.files[0]) != 1 || len(v2.files[1]) != 2 || !reflect.DeepEqual(v2.LiveFiles(), v.LiveFiles()) {
+			t.Fatal("files of golden manifest error", name)
+		}
+		for level := 0; level < 2; level++ {
+			for i, f := range v2.files[level] {
+				expected := v.files[level][i]
+				if internal.InternalKeyComparator(f.smallest, expected.smallest) != 0 || f.smallest.Type != expected.smallest.Type ||
+					internal.InternalKeyComparator(f.largest, expected.largest) != 0 || f.largest.Type != expected.largest.Type {
+					t.Fatal("key range of golden manifest error", name, level, i)
+				}
+			}
+		}
+	}
+}
+
+// countingEnv counts the random access files which are opened but not closed
+
+type countingEnv struct {
+	env.Env
+	open int32
+}
+
+type countingFile struct {
+	env.RandomAccessFile
+	e *countingEnv
+}
+
+func (f *countingFile) Close() error {
+	atomic.AddInt32(&f.e.open, -1)
+	return f.RandomAccessFile.Close()
+}
+
+func (e *countingEnv) NewRandomAccessFile(name string) (env.RandomAccessFile, error) {
+	f, err := e.Env.NewRandomAccessFile(name)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&e.open, 1)
+	return &countingFile{RandomAccessFile: f, e: e}, nil
+}
+
+func Test_TableCache_Evict(t *testing.T) {
+	fs := &countingEnv{Env: env.NewMemEnv()}
+	v := New("./", &opt.Options{Env: fs})
+	memTable := memtable.New()
+	memTable.Add(1, internal.TypeValue, []byte("123"), []byte("v123"))
+	if _, err := v.WriteLevel0Table(memTable); err != nil {
+		t.Fatal("write table fail", err)
+	}
+	var number uint64
+	for number = range v.LiveFiles() {
+	}
+
+	// the file of table is closed once it's evicted
+	if value, err := v.Get([]byte("123"), nil); err != nil || string(value) != "v123" {
+		t.Fatal("get fail", err)
+	}
+	if atomic.LoadInt32(&fs.open) != 1 {
+		t.Fatal("table is not cached", fs.open)
+	}
+	v.EvictTable(number)
+	if atomic.LoadInt32(&fs.open) != 0 {
+		t.Fatal("evicted table is not closed", fs.open)
+	}
+
+	// an iterator keeps reading the evicted table until it's closed
+	iters := v.NewIterators(nil)
+	for _, iter := range iters {
+		iter.SeekToFirst()
+	}
+	v.EvictTable(number)
+	if atomic.LoadInt32(&fs.open) != 1 {
+		t.Fatal("table read by iterator is closed", fs.open)
+	}
+	found := false
+	for _, iter := range iters {
+		for ; iter.Valid(); iter.Next() {
+			found = found || string(iter.InternalKey().UserValue) == "v123"
+		}
+		if iter.Error() != nil {
+			t.Fatal("iterate evicted table fail", iter.Error())
+		}
+		iter.Close()
+	}
+	if !found {
+		t.Fatal("key is not found in evicted table")
+	}
+	if atomic.LoadInt32(&fs.open) != 0 {
+		t.Fatal("evicted table is not closed after iterator is closed", fs.open)
+	}
+}
@@ -0,0 +1,19 @@
+// Log file is a sequence of 32KB blocks, and each write batch is stored as a record in log file which may span several blocks
+// A record is split into fragments so that a fragment never crosses a block boundary, each fragment is prefixed by a header:
+//		checksum: masked crc32c of type and data, 4 bytes
+//		length: length of data, 2 bytes
+//		type: one of FULL, FIRST, MIDDLE and LAST, 1 byte
+// The layout is the same as leveldb's, and the trailer of a block which is too short for a header is filled with zeros
+
+package wal
+
+const (
+	kZeroType   = 0 // reserved for preallocated files
+	kFullType   = 1
+	kFirstType  = 2
+	kMiddleType = 3
+	kLastType   = 4
+
+	kBlockSize  = 32768
+	kHeaderSize = 4 + 2 + 1
+)
@@ -0,0 +1,118 @@
+package wal
+
+import (
+	"encoding/binary"
+	"github.com/jo3yzhu/goveldb/internal"
+	"github.com/jo3yzhu/goveldb/utils"
+	"io"
+)
+
+type Reader struct {
+	src    io.Reader
+	block  [kBlockSize]byte
+	buf    []byte // unread part of current block
+	eof    bool   // the last block has been read
+	record []byte // reassembled record of fragments
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{
+		src: src,
+	}
+}
+
+// @description: read next record from log
+// @return: the record and error if any, io.EOF is returned when there's no more record
+// @note: the returned slice is valid only until the next call of ReadRecord
+//        a record torn by crash at the tail of log is treated as io.EOF instead of corruption
+
+func (r *Reader) ReadRecord() ([]byte, error) {
+	inFragmentedRecord := false
+	r.record = r.record[:0]
+
+	for {
+		fragment, recordType, err := r.readPhysicalRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		switch recordType {
+		case kFullType:
+			if inFragmentedRecord {
+				return nil, internal.ErrCorruption
+			}
+			return fragment, nil
+		case kFirstType:
+			if inFragmentedRecord {
+				return nil, internal.ErrCorruption
+			}
+			r.record = append(r.record, fragment...)
+			inFragmentedRecord = true
+		case kMiddleType:
+			if !inFragmentedRecord {
+				return nil, internal.ErrCorruption
+			}
+			r.record = append(r.record, fragment...)
+		case kLastType:
+			if !inFragmentedRecord {
+				return nil, internal.ErrCorruption
+			}
+			r.record = append(r.record, fragment...)
+			return r.record, nil
+		default:
+			return nil, internal.ErrCorruption
+		}
+	}
+}
+
+// @description: read a fragment from current block, read next block from src if current block is exhausted
+// @return: the fragment, its type and error if any
+
+func (r *Reader) readPhysicalRecord() ([]byte, byte, error) {
+	for {
+		// the rest of block is too short for a header, it's trailer of zeros
+		if len(r.buf) < kHeaderSize {
+			if r.eof {
+				return nil, 0, io.EOF
+			}
+
+			n, err := io.ReadFull(r.src, r.block[:])
+			if err == io.EOF {
+				return nil, 0, io.EOF
+			} else if err == io.ErrUnexpectedEOF {
+				r.eof = true
+			} else if err != nil {
+				return nil, 0, err
+			}
+			r.buf = r.block[:n]
+			continue
+		}
+
+		length := int(binary.LittleEndian.Uint16(r.buf[4:]))
+		recordType := r.buf[6]
+		if kHeaderSize+length > len(r.buf) {
+			r.buf = nil
+			if r.eof {
+				// writer died in the middle of writing the record
+				return nil, 0, io.EOF
+			}
+			return nil, 0, internal.ErrCorruption
+		}
+
+		// skip zero length record of preallocated file
+		if recordType == kZeroType && length == 0 {
+			r.buf = nil
+			continue
+		}
+
+		data := r.buf[kHeaderSize : kHeaderSize+length]
+		expected := utils.UnmaskCrc(binary.LittleEndian.Uint32(r.buf))
+		if utils.Crc32c(r.buf[6:7], data) != expected {
+			r.buf = nil
+			return nil, 0, internal.ErrCorruption
+		}
+
+		r.buf = r.buf[kHeaderSize+length:]
+		return data, recordType, nil
+	}
+}
@@ -0,0 +1,67 @@
+package wal
+
+import (
+	"bytes"
+	"github.com/jo3yzhu/goveldb/internal"
+	"io"
+	"testing"
+)
+
+func Test_Wal(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+
+	// small records, a record spans several blocks and an empty one
+	records := [][]byte{
+		[]byte("123"),
+		bytes.Repeat([]byte("x"), 3*kBlockSize),
+		[]byte(""),
+		bytes.Repeat([]byte("y"), kBlockSize-2*kHeaderSize),
+		[]byte("456"),
+	}
+	for _, record := range records {
+		if err := writer.AddRecord(record); err != nil {
+			t.Fatal("add record fail")
+		}
+	}
+
+	reader := NewReader(&buf)
+	for _, record := range records {
+		p, err := reader.ReadRecord()
+		if err != nil {
+			t.Fatal("read record fail", err)
+		}
+		if !bytes.Equal(p, record) {
+			t.Fatal("record mismatch")
+		}
+	}
+
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Fatal("expect EOF", err)
+	}
+}
+
+func Test_Wal_Corruption(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	_ = writer.AddRecord([]byte("123"))
+	_ = writer.AddRecord([]byte("456"))
+
+	// torn write at tail is not an error
+	torn := buf.Bytes()[:buf.Len()-1]
+	reader := NewReader(bytes.NewReader(torn))
+	if p, err := reader.ReadRecord(); err != nil || string(p) != "123" {
+		t.Fatal("read record fail")
+	}
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Fatal("expect EOF", err)
+	}
+
+	// flip a bit of data
+	corrupted := append([]byte(nil), buf.Bytes()...)
+	corrupted[kHeaderSize] ^= 1
+	reader = NewReader(bytes.NewReader(corrupted))
+	if _, err := reader.ReadRecord(); err != internal.ErrCorruption {
+		t.Fatal("expect corruption", err)
+	}
+}
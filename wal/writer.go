@@ -0,0 +1,87 @@
+package wal
+
+import (
+	"encoding/binary"
+	"github.com/jo3yzhu/goveldb/utils"
+	"io"
+)
+
+type Writer struct {
+	dest        io.Writer
+	blockOffset int    // current offset in block
+	buf         []byte // header and fragment are written to dest at once
+}
+
+func NewWriter(dest io.Writer) *Writer {
+	return &Writer{
+		dest: dest,
+	}
+}
+
+// @description: append a record to log, the record may be fragmented if it's larger than the room of current block
+// @param: the record
+// @return: error if any
+
+func (w *Writer) AddRecord(p []byte) error {
+	begin := true
+	for {
+		// switch to a new block if there's no room for header, and fill the trailer with zeros
+		leftover := kBlockSize - w.blockOffset
+		if leftover < kHeaderSize {
+			if leftover > 0 {
+				var zeros [kHeaderSize]byte
+				if _, err := w.dest.Write(zeros[:leftover]); err != nil {
+					return err
+				}
+			}
+			w.blockOffset = 0
+		}
+
+		avail := kBlockSize - w.blockOffset - kHeaderSize
+		fragmentLength := len(p)
+		if fragmentLength > avail {
+			fragmentLength = avail
+		}
+
+		end := fragmentLength == len(p)
+		var recordType byte
+		switch {
+		case begin && end:
+			recordType = kFullType
+		case begin:
+			recordType = kFirstType
+		case end:
+			recordType = kLastType
+		default:
+			recordType = kMiddleType
+		}
+
+		if err := w.emitPhysicalRecord(recordType, p[:fragmentLength]); err != nil {
+			return err
+		}
+
+		p = p[fragmentLength:]
+		begin = false
+		if end {
+			return nil
+		}
+	}
+}
+
+// @description: write a fragment with its header to log
+
+func (w *Writer) emitPhysicalRecord(recordType byte, p []byte) error {
+	var header [kHeaderSize]byte
+	header[6] = recordType
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(p)))
+	binary.LittleEndian.PutUint32(header[0:], utils.MaskCrc(utils.Crc32c(header[6:], p)))
+
+	w.buf = append(w.buf[:0], header[:]...)
+	w.buf = append(w.buf, p...)
+	if _, err := w.dest.Write(w.buf); err != nil {
+		return err
+	}
+
+	w.blockOffset += kHeaderSize + len(p)
+	return nil
+}